@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// testAttachment is an attachment exported from an xcresult bundle
+type testAttachment struct {
+	Name string
+	Path string
+}
+
+// attachmentManifest is the manifest.json written by `xcresulttool export
+// attachments`, listing the exported files per test
+type attachmentManifest []struct {
+	TestIdentifier string `json:"testIdentifier"`
+	Attachments    []struct {
+		ExportedFileName           string `json:"exportedFileName"`
+		SuggestedHumanReadableName string `json:"suggestedHumanReadableName"`
+	} `json:"attachments"`
+}
+
+// exportAttachments exports the attachments of the failed tests of the bundle
+// into dir and returns them by test identifier
+func exportAttachments(xcresultPath, dir string) (map[string][]testAttachment, error) {
+	if err := createOutputDir(dir); err != nil {
+		return nil, fmt.Errorf("failed to create attachments directory: %w", err)
+	}
+
+	if _, err := runXCResultTool("export", "attachments", "--path", xcresultPath, "--output-path", dir, "--only-failures"); err != nil {
+		return nil, err
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachments manifest: %w", err)
+	}
+	return parseAttachmentManifest(manifest, dir)
+}
+
+// parseAttachmentManifest returns the attachments listed in the manifest by
+// test identifier, with their paths in dir
+func parseAttachmentManifest(data []byte, dir string) (map[string][]testAttachment, error) {
+	var manifest attachmentManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse attachments manifest: %w", err)
+	}
+
+	attachments := map[string][]testAttachment{}
+	for _, test := range manifest {
+		for _, attachment := range test.Attachments {
+			if attachment.ExportedFileName == "" {
+				continue
+			}
+			name := attachment.SuggestedHumanReadableName
+			if name == "" {
+				name = attachment.ExportedFileName
+			}
+			attachments[test.TestIdentifier] = append(attachments[test.TestIdentifier], testAttachment{
+				Name: name,
+				Path: filepath.Join(dir, attachment.ExportedFileName),
+			})
+		}
+	}
+	return attachments, nil
+}
+
+// defaultInlineAttachmentMaxSize is the size limit of the inlined images in
+// bytes, small enough for the screenshots of a simulator
+const defaultInlineAttachmentMaxSize = 100 * 1024
+
+// addAttachmentNotes adds a system-out note to the test cases for each of
+// their attachments, with its path relative to the report directory. Missing
+// files are noted instead of failing the conversion. Images up to
+// inlineMaxSize bytes are inlined as data URIs, 0 inlines nothing.
+func addAttachmentNotes(testSuites *JUnitTestSuites, attachments map[string][]testAttachment, reportDir string, inlineMaxSize int64) {
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		for i := range suite.TestCases {
+			tc := &suite.TestCases[i]
+			var notes []string
+			for _, attachment := range attachments[tc.identifier] {
+				if inlineMaxSize > 0 {
+					notes = append(notes, inlineAttachmentNote(attachment, reportDir, inlineMaxSize))
+				} else {
+					notes = append(notes, attachmentNote(attachment, reportDir))
+				}
+			}
+			if len(notes) == 0 {
+				continue
+			}
+			if tc.SystemOut != "" {
+				notes = append([]string{string(tc.SystemOut)}, notes...)
+			}
+			tc.SystemOut = cdata(strings.Join(notes, "\n"))
+		}
+	})
+}
+
+// attachmentNote returns the system-out note referencing an exported attachment
+func attachmentNote(attachment testAttachment, reportDir string) string {
+	if _, err := os.Stat(attachment.Path); err != nil {
+		return fmt.Sprintf("Attachment %s missing: %s", attachment.Name, filepath.Base(attachment.Path))
+	}
+
+	pth := attachment.Path
+	if rel, err := filepath.Rel(reportDir, pth); err == nil {
+		pth = rel
+	}
+	return fmt.Sprintf("Attachment %s: %s", attachment.Name, filepath.ToSlash(pth))
+}
+
+// Reasons for not inlining an attachment
+var (
+	errAttachmentNotImage = errors.New("not an image")
+	errAttachmentTooLarge = errors.New("exceeds the size limit")
+)
+
+// attachmentDataURI encodes an image attachment as a base64 data URI. The size
+// limit is enforced before reading the file, attachments larger than maxSize
+// bytes are never inlined.
+func attachmentDataURI(pth string, maxSize int64) (string, error) {
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(pth)))
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", errAttachmentNotImage
+	}
+
+	info, err := os.Stat(pth)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxSize {
+		return "", errAttachmentTooLarge
+	}
+
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(content)) > maxSize {
+		return "", errAttachmentTooLarge
+	}
+
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(content)), nil
+}
+
+// inlineAttachmentNote returns the system-out note of an attachment holding
+// its data URI. Attachments which aren't images are referenced by their path
+// as usual, so are the oversized images, noting why they weren't inlined.
+func inlineAttachmentNote(attachment testAttachment, reportDir string, maxSize int64) string {
+	dataURI, err := attachmentDataURI(attachment.Path, maxSize)
+	switch {
+	case err == nil:
+		return fmt.Sprintf("Attachment %s: %s", attachment.Name, dataURI)
+	case errors.Is(err, errAttachmentTooLarge):
+		return fmt.Sprintf("%s (not inlined: %s of %d bytes)", attachmentNote(attachment, reportDir), err, maxSize)
+	}
+	return attachmentNote(attachment, reportDir)
+}
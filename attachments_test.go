@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAttachmentDataURI(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "attachments")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	screenshot := filepath.Join(tempDir, "Screenshot.png")
+	if err := os.WriteFile(screenshot, []byte("png"), 0644); err != nil {
+		t.Fatalf("Failed to write attachment: %v", err)
+	}
+	log := filepath.Join(tempDir, "Console.txt")
+	if err := os.WriteFile(log, []byte("log"), 0644); err != nil {
+		t.Fatalf("Failed to write attachment: %v", err)
+	}
+
+	t.Run("small image is inlined", func(t *testing.T) {
+		dataURI, err := attachmentDataURI(screenshot, 3)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if dataURI != "data:image/png;base64,cG5n" {
+			t.Errorf("Unexpected data URI: %s", dataURI)
+		}
+	})
+
+	t.Run("oversized image is skipped", func(t *testing.T) {
+		if _, err := attachmentDataURI(screenshot, 2); err != errAttachmentTooLarge {
+			t.Errorf("Expected errAttachmentTooLarge, got %v", err)
+		}
+
+		note := inlineAttachmentNote(testAttachment{Name: "Screenshot.png", Path: screenshot}, tempDir, 2)
+		if note != "Attachment Screenshot.png: Screenshot.png (not inlined: exceeds the size limit of 2 bytes)" {
+			t.Errorf("Expected a note about the skipped attachment, got %s", note)
+		}
+	})
+
+	t.Run("non image is skipped", func(t *testing.T) {
+		if _, err := attachmentDataURI(log, 1024); err != errAttachmentNotImage {
+			t.Errorf("Expected errAttachmentNotImage, got %v", err)
+		}
+	})
+}
+
+func TestAttachmentNotes(t *testing.T) {
+	outputDir := t.TempDir()
+	dir := filepath.Join(outputDir, "attachments", "Tests")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create attachments dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "0A1B.png"), []byte("png"), 0644); err != nil {
+		t.Fatalf("Failed to write attachment: %v", err)
+	}
+
+	attachments, err := parseAttachmentManifest([]byte(`[
+  {
+    "testIdentifier": "LoginTests/testLogin()",
+    "attachments": [
+      {"exportedFileName": "0A1B.png", "suggestedHumanReadableName": "Screenshot of failure.png", "isAssociatedWithFailure": true},
+      {"exportedFileName": "2C3D.txt", "suggestedHumanReadableName": "Console.txt", "isAssociatedWithFailure": true}
+    ]
+  }
+]`), dir)
+	if err != nil {
+		t.Fatalf("parseAttachmentManifest returned error: %v", err)
+	}
+	if len(attachments["LoginTests/testLogin()"]) != 2 {
+		t.Fatalf("Expected 2 attachments of testLogin(), got %v", attachments)
+	}
+
+	convert := func(t *testing.T) JUnitTestSuites {
+		testSuites, err := ConvertXCResultJSONToTestSuites([]byte(`{"testNodes": [{"name": "LoginTests", "nodeType": "Test Suite", "children": [
+  {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Failed"},
+  {"name": "testLogout()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogout()", "result": "Passed"}
+]}]}`), ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		return testSuites
+	}
+
+	testSuites := convert(t)
+	addAttachmentNotes(&testSuites, attachments, outputDir, 0)
+
+	cases := testSuites.TestSuites[0].TestCases
+	expected := "Attachment Screenshot of failure.png: attachments/Tests/0A1B.png\nAttachment Console.txt missing: 2C3D.txt"
+	if string(cases[0].SystemOut) != expected {
+		t.Errorf("Expected %q, got %q", expected, cases[0].SystemOut)
+	}
+	if cases[1].SystemOut != "" {
+		t.Errorf("Expected no notes for testLogout(), got %q", cases[1].SystemOut)
+	}
+
+	t.Run("inlined images", func(t *testing.T) {
+		testSuites := convert(t)
+		addAttachmentNotes(&testSuites, attachments, outputDir, defaultInlineAttachmentMaxSize)
+		expected := "Attachment Screenshot of failure.png: data:image/png;base64,cG5n\nAttachment Console.txt missing: 2C3D.txt"
+		if got := string(testSuites.TestSuites[0].TestCases[0].SystemOut); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("invalid manifest", func(t *testing.T) {
+		if _, err := parseAttachmentManifest([]byte("{"), dir); err == nil {
+			t.Errorf("Expected an error for an invalid manifest")
+		}
+	})
+}
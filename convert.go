@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Options controls Convert. It holds the inputs of the step which affect the
+// JUnit XML, the outputs of the step (files and exported env vars) are left
+// to the caller.
+type Options struct {
+	ConvertOptions
+
+	// XCResultPaths are the xcresult bundles to convert, their test results
+	// are merged into one report
+	XCResultPaths []string
+	// JSONInput is test results JSON (or a legacy object graph) exported
+	// earlier, converted instead of the bundles when set
+	JSONInput []byte
+	// Name is the name of the testsuites element, defaults to the names of
+	// the xcresult bundles
+	Name string
+	// Compact writes the JUnit XML without indentation. It is indented by
+	// default, like the report of the step and MarshalJUnitXML.
+	Compact bool
+	// PerformanceMetrics adds the measurements of the performance tests to
+	// their test cases, see addPerformanceProperties
+	PerformanceMetrics bool
+	// FailOnUnexpectedFormat fails the conversion of test results JSON
+	// without test nodes, which is only logged as a warning otherwise
+	FailOnUnexpectedFormat bool
+	// Enrich, when set, is called with the converted test suites before they
+	// are marshaled, e.g. to add CI build metadata as properties. An error
+	// fails the conversion.
+	Enrich func(*JUnitTestSuites) error
+}
+
+// conversion is the result of converting the test results of the bundles
+type conversion struct {
+	testSuites JUnitTestSuites
+	// testResultsPaths are the bundles read as test results, as opposed to
+	// the ones read in the legacy format
+	testResultsPaths []string
+	legacyBundles    int
+}
+
+// Convert converts the test results of the xcresult bundles (or of the JSON
+// input) to JUnit XML. It runs xcresulttool, but unlike the step it neither
+// reads its configuration from env vars nor exits the process on errors.
+func Convert(opts Options) ([]byte, error) {
+	result, err := convertTestResults(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalJUnitXML(result.testSuites, !opts.Compact)
+}
+
+// convertTestResults reads the test results of the bundles (or the JSON input)
+// into the JUnit model
+func convertTestResults(opts Options) (conversion, error) {
+	var result conversion
+	var jsonDocs, legacyDocs [][]byte
+	xcresultPaths := opts.XCResultPaths
+	if opts.JSONInput != nil {
+		// Pre-exported JSON is converted as is, without any xcresult bundle
+		xcresultPaths = nil
+		if isLegacyXCResultJSON(opts.JSONInput) {
+			legacyDocs = append(legacyDocs, opts.JSONInput)
+		} else {
+			if err := opts.checkTestResultsFormat(opts.JSONInput, "the JSON input"); err != nil {
+				return result, err
+			}
+			jsonDocs = append(jsonDocs, opts.JSONInput)
+		}
+	} else if len(xcresultPaths) == 0 {
+		return result, fmt.Errorf("no XCResult path provided")
+	}
+
+	// Check if the XCResult paths exist
+	for _, xcresultPath := range xcresultPaths {
+		if exists, err := pathutil.IsPathExists(xcresultPath); err != nil {
+			return result, fmt.Errorf("failed to check if XCResult path exists: %w", err)
+		} else if !exists {
+			return result, fmt.Errorf("XCResult path does not exist: %s", xcresultPath)
+		}
+	}
+
+	// Convert XCResult to JSON
+	var startTime time.Time
+	if len(xcresultPaths) > 0 {
+		log.Infof("Converting XCResult to JSON...")
+	}
+	for _, xcresultPath := range xcresultPaths {
+		log.Printf("- %s", xcresultPath)
+		jsonData, format, err := convertXCResultToJSON(xcresultPath)
+		if err != nil {
+			return result, fmt.Errorf("failed to convert XCResult to JSON: %w", err)
+		}
+		if format == formatLegacy {
+			// The legacy object graph contains the start of the run
+			legacyDocs = append(legacyDocs, jsonData)
+			continue
+		}
+		if err := opts.checkTestResultsFormat(jsonData, xcresultPath); err != nil {
+			return result, err
+		}
+		jsonDocs = append(jsonDocs, jsonData)
+		result.testResultsPaths = append(result.testResultsPaths, xcresultPath)
+
+		// The test results JSON doesn't contain the start of the run, the summary does
+		bundleStartTime, err := readTestRunStartTime(xcresultPath)
+		if err != nil {
+			log.Warnf("Failed to read the test run start time, using the current time as timestamp: %s", err)
+		} else if startTime.IsZero() || bundleStartTime.Before(startTime) {
+			startTime = bundleStartTime
+		}
+	}
+	if len(jsonDocs) > 0 && len(legacyDocs) > 0 {
+		log.Printf("%d of the %d bundles were read in the legacy format", len(legacyDocs), len(xcresultPaths))
+	}
+	result.legacyBundles = len(legacyDocs)
+
+	// Convert JSON to JUnit XML
+	log.Infof("Converting JSON to JUnit XML...")
+	convertOpts := opts.ConvertOptions
+	if convertOpts.StartTime.IsZero() {
+		convertOpts.StartTime = startTime
+	}
+
+	var jsonData, legacyJSONData []byte
+	var err error
+	if len(jsonDocs) > 0 {
+		if jsonData, err = MergeXCResultJSON(jsonDocs...); err != nil {
+			return result, fmt.Errorf("failed to merge XCResult JSON: %w", err)
+		}
+	}
+	if len(legacyDocs) > 0 {
+		if legacyJSONData, err = mergeLegacyXCResultJSON(legacyDocs...); err != nil {
+			return result, fmt.Errorf("failed to merge XCResult JSON: %w", err)
+		}
+	}
+
+	if result.testSuites, err = ConvertMixedXCResultJSONToTestSuites(jsonData, legacyJSONData, convertOpts); err != nil {
+		return result, fmt.Errorf("failed to convert JSON to JUnit XML: %w", err)
+	}
+	result.testSuites.Name = opts.Name
+	if result.testSuites.Name == "" {
+		result.testSuites.Name = reportName(xcresultPaths)
+	}
+
+	// Report the measurements of the performance tests, a bundle whose metrics
+	// can't be read doesn't fail the conversion
+	if opts.PerformanceMetrics {
+		log.Infof("Reading the performance test metrics...")
+		metrics := map[string][]performanceMetric{}
+		for _, pth := range result.testResultsPaths {
+			bundleMetrics, err := readPerformanceMetrics(pth)
+			if err != nil {
+				log.Warnf("Failed to read the performance test metrics of %s: %s", pth, err)
+				continue
+			}
+			log.Printf("- %s: %d performance test(s)", pth, len(bundleMetrics))
+			for identifier, testMetrics := range bundleMetrics {
+				metrics[identifier] = append(metrics[identifier], testMetrics...)
+			}
+		}
+		if result.legacyBundles > 0 {
+			log.Warnf("The performance test metrics of the bundles read in the legacy format aren't reported")
+		}
+		addPerformanceProperties(&result.testSuites, metrics)
+	}
+
+	if opts.Enrich != nil {
+		if err := opts.Enrich(&result.testSuites); err != nil {
+			return result, fmt.Errorf("failed to enrich the test suites: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// reportName returns the names of the xcresult bundles without extension,
+// e.g. "UnitTests, UITests"
+func reportName(xcresultPaths []string) string {
+	names := make([]string, 0, len(xcresultPaths))
+	for _, pth := range xcresultPaths {
+		name := filepath.Base(filepath.Clean(pth))
+		names = append(names, strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+	return strings.Join(names, ", ")
+}
+
+// errUnexpectedFormat is returned for test results JSON without test nodes
+var errUnexpectedFormat = errors.New("unexpected test results JSON format")
+
+// checkTestResultsFormat checks that the test results JSON has test nodes. A
+// new xcresulttool may change the shape of the JSON, which would otherwise
+// silently result in an empty report. An empty object isn't reported, nor
+// are JSON syntax errors, which the conversion reports.
+func (opts Options) checkTestResultsFormat(jsonData []byte, source string) error {
+	var root map[string]ignoredJSON
+	if err := json.Unmarshal(jsonData, &root); err != nil || len(root) == 0 {
+		return nil
+	}
+	if _, found := root["testNodes"]; found {
+		return nil
+	}
+
+	keys := make([]string, 0, len(root))
+	for key := range root {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	err := fmt.Errorf("%w: no testNodes in the test results of %s, the top level keys are: %s", errUnexpectedFormat, source, strings.Join(keys, ", "))
+	if opts.FailOnUnexpectedFormat {
+		return err
+	}
+	log.Warnf("%s", err)
+	log.Warnf("The report will miss these test results, xcresulttool may have changed its JSON format")
+	return nil
+}
+
+// ignoredJSON skips a JSON value without copying it
+type ignoredJSON struct{}
+
+func (*ignoredJSON) UnmarshalJSON([]byte) error {
+	return nil
+}
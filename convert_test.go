@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "LoginTests", "nodeType": "Test Suite", "children": [
+  {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "duration": "1s", "result": "Passed"}
+]}]}`)
+
+	t.Run("JSON input", func(t *testing.T) {
+		xmlData, err := Convert(Options{JSONInput: jsonData, XCResultPaths: []string{"ignored.xcresult"}})
+		if err != nil {
+			t.Fatalf("Convert returned error: %v", err)
+		}
+		if !strings.Contains(string(xmlData), `<testsuite name="LoginTests" tests="1"`) {
+			t.Errorf("Expected the LoginTests suite, got:\n%s", xmlData)
+		}
+		if !strings.Contains(string(xmlData), "\n  <testsuite") {
+			t.Errorf("Expected indented XML, got:\n%s", xmlData)
+		}
+	})
+
+	t.Run("options", func(t *testing.T) {
+		xmlData, err := Convert(Options{JSONInput: jsonData, Compact: true, ConvertOptions: ConvertOptions{SuiteIDs: true}})
+		if err != nil {
+			t.Fatalf("Convert returned error: %v", err)
+		}
+		if !strings.Contains(string(xmlData), "><testsuite id=\"0\"") {
+			t.Errorf("Expected compact XML with suite ids, got:\n%s", xmlData)
+		}
+	})
+
+	t.Run("name", func(t *testing.T) {
+		xmlData, err := Convert(Options{JSONInput: jsonData, Name: "Unit Tests"})
+		if err != nil {
+			t.Fatalf("Convert returned error: %v", err)
+		}
+		if !strings.Contains(string(xmlData), `<testsuites name="Unit Tests" tests="1"`) {
+			t.Errorf("Expected the name of the testsuites element, got:\n%s", xmlData)
+		}
+	})
+
+	t.Run("enrich", func(t *testing.T) {
+		enrich := func(testSuites *JUnitTestSuites) error {
+			testSuites.TestSuites[0].Properties = &JUnitProperties{Properties: []JUnitProperty{{Name: "build", Value: "42"}}}
+			return nil
+		}
+		xmlData, err := Convert(Options{JSONInput: jsonData, Enrich: enrich})
+		if err != nil {
+			t.Fatalf("Convert returned error: %v", err)
+		}
+		if !strings.Contains(string(xmlData), `<property name="build" value="42">`) {
+			t.Errorf("Expected the property added by the hook, got:\n%s", xmlData)
+		}
+
+		hookErr := errors.New("no build number")
+		_, err = Convert(Options{JSONInput: jsonData, Enrich: func(*JUnitTestSuites) error { return hookErr }})
+		if !errors.Is(err, hookErr) {
+			t.Errorf("Expected the error of the hook, got %v", err)
+		}
+	})
+
+	t.Run("no input", func(t *testing.T) {
+		if _, err := Convert(Options{}); err == nil {
+			t.Errorf("Expected an error without XCResult paths")
+		}
+	})
+
+	t.Run("missing bundle", func(t *testing.T) {
+		pth := filepath.Join(t.TempDir(), "Missing.xcresult")
+		_, err := Convert(Options{XCResultPaths: []string{pth}})
+		if err == nil || !strings.Contains(err.Error(), "does not exist") {
+			t.Errorf("Expected an error for the missing bundle, got %v", err)
+		}
+	})
+
+	t.Run("unexpected format", func(t *testing.T) {
+		jsonData := []byte(`{"tests": [{"name": "testLogin()"}], "devices": []}`)
+		xmlData, err := Convert(Options{JSONInput: jsonData})
+		if err != nil {
+			t.Fatalf("Convert returned error: %v", err)
+		}
+		if !strings.Contains(string(xmlData), `tests="0"`) {
+			t.Errorf("Expected an empty report, got:\n%s", xmlData)
+		}
+
+		_, err = Convert(Options{JSONInput: jsonData, FailOnUnexpectedFormat: true})
+		if !errors.Is(err, errUnexpectedFormat) {
+			t.Fatalf("Expected an unexpected format error, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "the top level keys are: devices, tests") {
+			t.Errorf("Expected the top level keys in the error, got %v", err)
+		}
+
+		for _, jsonData := range []string{`{}`, `{"testNodes": []}`, `{"testNodes": null}`} {
+			if _, err := Convert(Options{JSONInput: []byte(jsonData), FailOnUnexpectedFormat: true}); err != nil {
+				t.Errorf("Expected no error for %s, got %v", jsonData, err)
+			}
+		}
+	})
+}
+
+func TestReportName(t *testing.T) {
+	tests := []struct {
+		paths    []string
+		expected string
+	}{
+		{[]string{"/tmp/UnitTests.xcresult"}, "UnitTests"},
+		{[]string{"UnitTests.xcresult/", "Shard 2.xcresult"}, "UnitTests, Shard 2"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := reportName(tt.paths); got != tt.expected {
+			t.Errorf("Expected %q for %v, got %q", tt.expected, tt.paths, got)
+		}
+	}
+}
@@ -1,41 +1,171 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/go-utils/log"
 )
 
-// JUnitTestSuites represents the root XML element
+// JUnitTestSuites represents the root XML element. The totals are the sums
+// of the top level suites, they are computed when marshaling.
 type JUnitTestSuites struct {
 	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr,omitempty"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       float64          `xml:"time,attr"`
 	TestSuites []JUnitTestSuite `xml:"testsuite"`
 }
 
-// JUnitTestSuite represents a test suite
+// setTotals sets the totals of the root element from the top level suites
+func (testSuites *JUnitTestSuites) setTotals() {
+	summary := NewTestSummary(*testSuites)
+	testSuites.Tests = summary.Tests
+	testSuites.Failures = summary.Failures
+	testSuites.Errors = summary.Errors
+	testSuites.Skipped = summary.Skipped
+	testSuites.Time = summary.Time
+}
+
+// JUnitTestSuite represents a test suite. Tests counts every test case of the
+// suite and its nested suites, skipped ones included, Failures, Errors and
+// Skipped are subsets of it.
 type JUnitTestSuite struct {
-	XMLName   xml.Name        `xml:"testsuite"`
-	Name      string          `xml:"name,attr"`
-	Tests     int             `xml:"tests,attr"`
-	Failures  int             `xml:"failures,attr"`
-	Errors    int             `xml:"errors,attr"`
-	Time      float64         `xml:"time,attr"`
-	Timestamp string          `xml:"timestamp,attr"`
-	TestCases []JUnitTestCase `xml:"testcase"`
+	XMLName    xml.Name         `xml:"testsuite"`
+	ID         *int             `xml:"id,attr,omitempty"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       float64          `xml:"time,attr"`
+	Timestamp  string           `xml:"timestamp,attr"`
+	Hostname   string           `xml:"hostname,attr,omitempty"`
+	Package    string           `xml:"package,attr,omitempty"`
+	Properties *JUnitProperties `xml:"properties,omitempty"`
+	TestCases  []JUnitTestCase  `xml:"testcase"`
+	TestSuites []JUnitTestSuite `xml:"testsuite,omitempty"`
+	SystemOut  string           `xml:"system-out,omitempty"`
+
+	// reportedTime is the duration the xcresult reports for the suite
+	reportedTime float64
+	// device is the device the test cases of the suite ran on, when the
+	// suites are disambiguated by device
+	device string
+	// bundles are the test bundles (test targets) the test cases of the
+	// suite come from, reported as bundle properties
+	bundles []string
+	// configuration is the test plan configuration the test cases of the
+	// suite ran with
+	configuration string
+}
+
+// addBundle records the test bundle of a test case of the suite
+func (suite *JUnitTestSuite) addBundle(bundle string) {
+	if bundle == "" {
+		return
+	}
+	for _, existing := range suite.bundles {
+		if existing == bundle {
+			return
+		}
+	}
+	suite.bundles = append(suite.bundles, bundle)
+}
+
+// addProperty appends a property to the test suite
+func (suite *JUnitTestSuite) addProperty(name, value string) {
+	// The properties may be shared with the other suites
+	var properties []JUnitProperty
+	if suite.Properties != nil {
+		properties = append(properties, suite.Properties.Properties...)
+	}
+	suite.Properties = &JUnitProperties{Properties: append(properties, JUnitProperty{Name: name, Value: value})}
+}
+
+// JUnitProperties wraps the properties of a test suite, a "properties>property"
+// path would emit an empty <properties> element for suites without properties
+type JUnitProperties struct {
+	Properties []JUnitProperty `xml:"property"`
+}
+
+// JUnitProperty represents a property of a test suite, like the devices the tests ran on
+type JUnitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// MarshalXML emits the properties sorted by name and value, so the report of
+// the same results is identical byte for byte whatever order they were added in
+func (p JUnitProperties) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	sorted := append([]JUnitProperty(nil), p.Properties...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	return e.EncodeElement(struct {
+		Properties []JUnitProperty `xml:"property"`
+	}{sorted}, start)
 }
 
 // JUnitTestCase represents a test case
 type JUnitTestCase struct {
-	XMLName   xml.Name      `xml:"testcase"`
-	Name      string        `xml:"name,attr"`
-	Classname string        `xml:"classname,attr"`
-	Time      float64       `xml:"time,attr"`
-	Failure   *JUnitFailure `xml:"failure,omitempty"`
-	Skipped   *JUnitSkipped `xml:"skipped,omitempty"`
+	XMLName       xml.Name            `xml:"testcase"`
+	Name          string              `xml:"name,attr"`
+	Classname     string              `xml:"classname,attr"`
+	Time          float64             `xml:"time,attr"`
+	Assertions    int                 `xml:"assertions,attr,omitempty"`
+	Properties    *JUnitProperties    `xml:"properties,omitempty"`
+	Failure       *JUnitFailure       `xml:"failure,omitempty"`
+	Error         *JUnitError         `xml:"error,omitempty"`
+	Skipped       *JUnitSkipped       `xml:"skipped,omitempty"`
+	FlakyFailures []JUnitFlakyFailure `xml:"flakyFailure,omitempty"`
+	SystemOut     cdata               `xml:"system-out,omitempty"`
+	SystemErr     cdata               `xml:"system-err,omitempty"`
+	// Devices lists the devices the test case ran on, FailedDevices the ones it failed on
+	Devices       []string `xml:"-"`
+	FailedDevices []string `xml:"-"`
+	// File and Line are the source location of the first failure, see
+	// ConvertOptions.LocationAttributes
+	File string `xml:"file,attr,omitempty"`
+	Line int    `xml:"line,attr,omitempty"`
+
+	// identifier is the xcresult identifier of the test, e.g. "LoginTests/testLogin()"
+	identifier string
+	// runs counts the executions merged into the test case, 0 means a single
+	// one. repeated marks executions repeated on purpose rather than retried,
+	// see isRepeatedRun.
+	runs     int
+	repeated bool
+}
+
+// cdata is serialized as a CDATA section, so logged output full of special
+// characters stays readable in the XML
+type cdata string
+
+// MarshalXML implements xml.Marshaler
+func (c cdata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Content string `xml:",cdata"`
+	}{string(c)}, start)
 }
 
 // JUnitFailure represents a test failure
@@ -46,6 +176,49 @@ type JUnitFailure struct {
 	Content string   `xml:",chardata"`
 }
 
+// MarshalXML implements xml.Marshaler, the body is emitted as a CDATA section
+// so multi-line assertion output stays legible while the message attribute
+// remains an escaped one-line summary
+func (f JUnitFailure) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return encodeFailureElement(e, start, f.Message, f.Type, f.Content)
+}
+
+// JUnitError represents a test which errored out, e.g. by throwing an
+// unexpected error, rather than failing an assertion
+type JUnitError struct {
+	XMLName xml.Name `xml:"error"`
+	Message string   `xml:"message,attr"`
+	Type    string   `xml:"type,attr"`
+	Content string   `xml:",chardata"`
+}
+
+// MarshalXML implements xml.Marshaler, see JUnitFailure.MarshalXML
+func (e JUnitError) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	return encodeFailureElement(enc, start, e.Message, e.Type, e.Content)
+}
+
+// JUnitFlakyFailure represents a failed attempt of a test which passed on retry
+type JUnitFlakyFailure struct {
+	XMLName xml.Name `xml:"flakyFailure"`
+	Message string   `xml:"message,attr"`
+	Type    string   `xml:"type,attr"`
+	Content string   `xml:",chardata"`
+}
+
+// MarshalXML implements xml.Marshaler, see JUnitFailure.MarshalXML
+func (f JUnitFlakyFailure) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return encodeFailureElement(e, start, f.Message, f.Type, f.Content)
+}
+
+// encodeFailureElement encodes a failure like element with its body as CDATA
+func encodeFailureElement(e *xml.Encoder, start xml.StartElement, message, failureType, content string) error {
+	return e.EncodeElement(struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr"`
+		Content string `xml:",cdata"`
+	}{message, failureType, content}, start)
+}
+
 // JUnitSkipped represents a skipped test
 type JUnitSkipped struct {
 	XMLName xml.Name `xml:"skipped"`
@@ -56,6 +229,8 @@ type JUnitSkipped struct {
 type XCResultRoot struct {
 	Devices   []Device   `json:"devices"`
 	TestNodes []TestNode `json:"testNodes"`
+	// StartTime is the start of the test run in seconds since the epoch, when reported
+	StartTime float64 `json:"startTime,omitempty"`
 }
 
 // Device represents device information
@@ -73,11 +248,87 @@ type TestNode struct {
 	Children          []TestNode        `json:"children,omitempty"`
 	Name              string            `json:"name"`
 	NodeType          string            `json:"nodeType"`
-	Duration          string            `json:"duration"`
+	Duration          Duration          `json:"duration"`
 	Result            string            `json:"result"`
 	NodeIdentifier    string            `json:"nodeIdentifier,omitempty"`
+	Severity          string            `json:"severity,omitempty"`
+	SourceLocation    *SourceLocation   `json:"sourceLocation,omitempty"`
 	SummaryRef        SummaryRef        `json:"summaryRef,omitempty"`
 	ActivitySummaries ActivitySummaries `json:"activitySummaries,omitempty"`
+	Tags              []string          `json:"tags,omitempty"`
+	// Source is the 1-based index of the xcresult bundle a test case comes
+	// from when several are merged, see MergeXCResultJSON
+	Source int `json:"mergedSource,omitempty"`
+}
+
+// Duration is the duration of a test node as reported by xcresulttool, e.g.
+// "1.5s" or "1m 2s", see parseDuration
+type Duration string
+
+// UnmarshalJSON implements json.Unmarshaler. Some xcresulttool versions report
+// the duration as a number of seconds, which is read as e.g. "0.5s".
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = formatDuration(seconds)
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration %s: neither a string nor a number", data)
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// SourceLocation represents the source file, line and column an issue was
+// reported at
+type SourceLocation struct {
+	FilePath     string `json:"filePath"`
+	LineNumber   int    `json:"lineNumber"`
+	ColumnNumber int    `json:"columnNumber,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. XCTest issues report their
+// location as {filePath, lineNumber}, Swift Testing issues as {fileID,
+// _filePath, line, column}, both shapes are read.
+func (loc *SourceLocation) UnmarshalJSON(data []byte) error {
+	var location struct {
+		FilePath     string `json:"filePath"`
+		LineNumber   int    `json:"lineNumber"`
+		ColumnNumber int    `json:"columnNumber"`
+		// Swift Testing
+		SwiftFilePath string `json:"_filePath"`
+		FileID        string `json:"fileID"`
+		Line          int    `json:"line"`
+		Column        int    `json:"column"`
+	}
+	if err := json.Unmarshal(data, &location); err != nil {
+		return err
+	}
+
+	*loc = SourceLocation{
+		FilePath:     location.FilePath,
+		LineNumber:   location.LineNumber,
+		ColumnNumber: location.ColumnNumber,
+	}
+	if loc.FilePath == "" {
+		loc.FilePath = location.SwiftFilePath
+	}
+	if loc.FilePath == "" {
+		loc.FilePath = location.FileID
+	}
+	if loc.LineNumber == 0 {
+		loc.LineNumber = location.Line
+	}
+	if loc.ColumnNumber == 0 {
+		loc.ColumnNumber = location.Column
+	}
+	return nil
 }
 
 // SummaryRef represents a reference to a summary
@@ -99,49 +350,479 @@ type ActivitySummaryEntry struct {
 
 // ActivitySummary represents an activity summary
 type ActivitySummary struct {
-	Title    string `json:"title"`
-	Messages []struct {
+	Title        string `json:"title"`
+	ActivityType string `json:"activityType"`
+	Messages     []struct {
 		StringValue string `json:"string_value"`
 	} `json:"messages"`
 }
 
+// ConvertOptions controls how XCResult test results are converted
+type ConvertOptions struct {
+	// MaxTestCases caps the number of emitted test cases, 0 means no limit
+	MaxTestCases int
+	// PreserveHierarchy nests the test suites following the test bundle,
+	// suite and subsuite hierarchy instead of emitting flat suites
+	PreserveHierarchy bool
+	// CollapseSingletonSuites merges the suites holding a single test case
+	// into one suite named CollapsedSuiteName
+	CollapseSingletonSuites bool
+	CollapsedSuiteName      string
+	// Flatten merges all the test cases into one suite named
+	// FlattenedSuiteName, see flattenSuites
+	Flatten            bool
+	FlattenedSuiteName string
+	// EmptySuiteName is the name of the placeholder suite reported when no
+	// tests were found, defaults to defaultEmptySuiteName. OmitEmptySuite
+	// reports no suite at all instead.
+	EmptySuiteName string
+	OmitEmptySuite bool
+	// FailureContextLines adds the titles of up to this many activities
+	// logged before and after the failed assertion to the failure details,
+	// see failureContext. 0 adds no context.
+	FailureContextLines int
+	// Strict fails the conversion on the conditions it doesn't fully
+	// understand (unknown node types, unparseable durations), which are
+	// only summarized as warnings otherwise
+	Strict bool
+	// FailureElement is the element the assertion failures are reported
+	// with, defaults to FailureElementFailure. Unexpected errors and crashes
+	// are always reported as errors.
+	FailureElement string
+	// TimeDecimals rounds the test case and suite times to the number of
+	// decimals, defaults to defaultTimeDecimals when 0
+	TimeDecimals int
+	// MaxNodeDepth is the deepest level of the test node tree processed,
+	// defaults to defaultMaxNodeDepth when 0. The deeper nodes of a malformed
+	// tree are dropped with a warning.
+	MaxNodeDepth int
+	// SourceRootPath makes the source file paths found in failures and notes
+	// relative to it, paths are left untouched when empty
+	SourceRootPath string
+	// StripMethodParens removes the empty trailing "()" of Swift test method names
+	StripMethodParens bool
+	// ClassnamePrefixStrip is removed from the start of every classname, e.g.
+	// the test bundle name. ClassnameReplaceSpaces replaces the spaces of the
+	// classnames with underscores.
+	ClassnamePrefixStrip   string
+	ClassnameReplaceSpaces bool
+	// ReportPortalCompat applies the adjustments needed by the ReportPortal
+	// JUnit importer, see applyReportPortalCompat
+	ReportPortalCompat bool
+	// SuiteTimeSource selects where the suite time comes from, defaults to SuiteTimeFromXCResult
+	SuiteTimeSource string
+	// StartTime is the start of the test run, used as the suite timestamp.
+	// Falls back to the start time found in the JSON, then to the current time.
+	StartTime time.Time
+	// SuiteHostAttributes sets the hostname and package attributes of the
+	// suites, see applyHostAttributes. Hostname is used as the hostname when
+	// the tests didn't run on a single device.
+	SuiteHostAttributes bool
+	Hostname            string
+	// LocationAttributes sets the file and line attributes of the
+	// failed test cases to the source location of their first failure
+	LocationAttributes bool
+	// IncludePattern keeps only the test cases whose "classname.name" matches
+	// it, ExcludePattern drops the matching ones. Nil patterns match nothing.
+	IncludePattern *regexp.Regexp
+	ExcludePattern *regexp.Regexp
+	// OmitSkipped drops the skipped test cases, so the suites only count the
+	// tests which ran
+	OmitSkipped bool
+	// SuiteIDs numbers the suites with an id attribute, see assignSuiteIDs
+	SuiteIDs bool
+	// MaxFailureLength caps the number of characters of the failure and
+	// error contents, 0 means no limit
+	MaxFailureLength int
+	// OmitTags leaves out the "tag" properties of the Swift Testing test cases
+	OmitTags bool
+	// SystemOutOn selects the test cases whose activity log is written to
+	// their system-out and system-err, defaults to SystemOutFailures
+	SystemOutOn string
+	// RawNames keeps the suite names and classnames as found in the test
+	// results instead of passing them through sanitizeName
+	RawNames bool
+	// GroupBy selects the flat suite of the test cases, defaults to
+	// GroupBySuite. The hierarchy isn't affected.
+	GroupBy string
+	// DisambiguateByDevice splits the flat suites of multi-device runs per
+	// device, e.g. "LoginTests [iPhone 15, iOS 17.0]". The hierarchy isn't
+	// affected.
+	DisambiguateByDevice bool
+}
+
+// Flat suites of the test cases
+const (
+	// GroupBySuite groups the test cases by the suite path of their
+	// identifier, see flatSuiteName
+	GroupBySuite = "suite"
+	// GroupByClassname groups the test cases by their classname, so a class
+	// found in several test bundles ends up in one suite per bundle
+	GroupByClassname = "classname"
+)
+
+// Test cases with their activity log in system-out
+const (
+	SystemOutAlways = "always"
+	// SystemOutFailures keeps the activity log of the failed and errored test
+	// cases only, the activity logs of big passing UI test suites would
+	// dominate the report otherwise
+	SystemOutFailures = "failures"
+	SystemOutNever    = "never"
+)
+
+// Suite time sources
+const (
+	// SuiteTimeFromTestCases sums the time of the suite's test cases
+	SuiteTimeFromTestCases = "testcases"
+	// SuiteTimeFromXCResult uses the duration the xcresult reports for the
+	// suite, which includes its setup and teardown, falling back to the sum of
+	// its test cases
+	SuiteTimeFromXCResult = "xcresult"
+)
+
+// Elements of the assertion failures
+const (
+	FailureElementFailure = "failure"
+	FailureElementError   = "error"
+)
+
+// defaultCollapsedSuiteName is the name of the suite holding the test cases of
+// collapsed singleton suites
+const defaultCollapsedSuiteName = "SingleTestSuites"
+
+// defaultFlattenedSuiteName is the name of the suite holding all the test
+// cases when flattened
+const defaultFlattenedSuiteName = "AllTests"
+
+// defaultEmptySuiteName is the name of the placeholder suite reported when no
+// tests were found
+const defaultEmptySuiteName = "XCTest"
+
+// defaultTimeDecimals is the number of decimals of the reported times,
+// milliseconds are precise enough for test durations
+const defaultTimeDecimals = 3
+
+// maxTimeDecimals is the precision of the durations, nanoseconds
+const maxTimeDecimals = 9
+
+// defaultMaxNodeDepth is the deepest level of the test node tree processed,
+// real test results are nested a dozen levels at most
+const defaultMaxNodeDepth = 100
+
 // ConvertXCResultJSONToJUnitXML converts XCResult JSON to JUnit XML
-func ConvertXCResultJSONToJUnitXML(jsonData []byte) ([]byte, error) {
-	var root XCResultRoot
-	if err := json.Unmarshal(jsonData, &root); err != nil {
-		return nil, fmt.Errorf("failed to parse XCResult JSON: %w", err)
+func ConvertXCResultJSONToJUnitXML(jsonData []byte, opts ConvertOptions) ([]byte, error) {
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, opts)
+	if err != nil {
+		return nil, err
 	}
+	if err := validateSuiteCounts(testSuites); err != nil {
+		return nil, err
+	}
+	return MarshalJUnitXML(testSuites)
+}
+
+// ConvertXCResultJSONToTestSuites parses XCResult JSON into the JUnit model,
+// which can then be serialized into any of the supported output formats
+func ConvertXCResultJSONToTestSuites(jsonData []byte, opts ConvertOptions) (JUnitTestSuites, error) {
+	return ConvertMixedXCResultJSONToTestSuites(jsonData, nil, opts)
+}
 
+// ConvertMixedXCResultJSONToTestSuites converts the test results JSON and the
+// legacy object graph JSON (see legacy.go) into one model, so bundles exported
+// in different formats end up in one report. Either of them may be nil.
+func ConvertMixedXCResultJSONToTestSuites(jsonData, legacyJSONData []byte, opts ConvertOptions) (JUnitTestSuites, error) {
 	testSuites := JUnitTestSuites{
 		TestSuites: []JUnitTestSuite{},
 	}
-	suiteMap := make(map[string]*JUnitTestSuite)
+	c := &converter{
+		opts:           opts,
+		suiteMap:       make(map[string]*JUnitTestSuite),
+		suiteDurations: make(map[string]float64),
+		caseIndex:      make(map[string]int),
+	}
+
+	// The flat suites are built while decoding, see stream.go
+	var root XCResultRoot
+	if jsonData != nil {
+		var err error
+		if root, err = c.decodeTestResults(jsonData, !opts.PreserveHierarchy); err != nil {
+			return JUnitTestSuites{}, fmt.Errorf("failed to parse XCResult JSON: %w", err)
+		}
+	}
+
+	var legacyRoot map[string]interface{}
+	if legacyJSONData != nil {
+		if err := json.Unmarshal(legacyJSONData, &legacyRoot); err != nil {
+			return JUnitTestSuites{}, fmt.Errorf("failed to parse legacy XCResult JSON: %w", err)
+		}
+	}
+
+	if opts.PreserveHierarchy {
+		c.limitNodeDepth(root.TestNodes, 1)
+		testSuites.TestSuites = append(testSuites.TestSuites, c.buildSuiteTree(root.TestNodes, "")...)
+		for i := range testSuites.TestSuites {
+			rollupSuite(&testSuites.TestSuites[i])
+		}
+	}
+
+	// The legacy format has no suite hierarchy, its testables are always flat suites
+	c.processLegacyRoot(legacyRoot)
 
-	processTestNodes(root.TestNodes, "", suiteMap)
+	if len(c.warnings) > 0 {
+		if opts.Strict {
+			return JUnitTestSuites{}, fmt.Errorf("%w: %s", errStrict, strings.Join(c.warnings, "; "))
+		}
+		log.Warnf("%d conversion warning(s), the report may be incomplete:", len(c.warnings))
+		for _, warning := range c.warnings {
+			log.Warnf("- %s", warning)
+		}
+	}
 
 	// Convert map to slice and calculate totals
-	for _, suite := range suiteMap {
+	for key, suite := range c.suiteMap {
+		if suite.device != "" {
+			suite.Name = c.outputSuiteName(suite.Name + " [" + c.deviceLabel(suite.device) + "]")
+		}
 		suite.Tests = len(suite.TestCases)
 		suite.Time = totalSuiteTime(suite.TestCases)
+		suite.reportedTime = c.suiteDurations[key]
 		testSuites.TestSuites = append(testSuites.TestSuites, *suite)
 	}
 
+	// The suites of the same tests run with several test plan configurations
+	// are told apart by the configuration, e.g. "LoginTests [ASan]"
+	if len(c.configurations) > 1 {
+		for i := range testSuites.TestSuites {
+			suite := &testSuites.TestSuites[i]
+			if suite.configuration != "" {
+				suite.Name = c.outputSuiteName(suite.Name + " [" + suite.configuration + "]")
+			}
+		}
+	}
+
+	if opts.SuiteTimeSource != SuiteTimeFromTestCases {
+		useReportedSuiteTimes(testSuites.TestSuites)
+	}
+
+	// The run info may follow the test nodes in the JSON, apply it once all is decoded
+	startTime := root.StartTime
+	if legacyStartTime := getFloatByPath(legacyRoot, []string{"startTime"}); legacyStartTime > 0 && (startTime == 0 || legacyStartTime < startTime) {
+		startTime = legacyStartTime
+	}
+	c.timestamp = runTimestamp(opts.StartTime, startTime)
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		suite.Timestamp = c.timestamp
+	})
+	c.applyRunDevice(testSuites.TestSuites)
+
+	c.finish(&testSuites)
+
+	if properties := deviceProperties(c.devices); len(properties) > 0 {
+		for i := range testSuites.TestSuites {
+			testSuites.TestSuites[i].Properties = &JUnitProperties{Properties: properties}
+		}
+	}
+	// The test bundles allow grouping the suites by test target, the test plan
+	// configurations by configuration
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		for _, bundle := range suite.bundles {
+			suite.addProperty("bundle", bundle)
+		}
+		if suite.configuration != "" {
+			suite.addProperty("configuration", suite.configuration)
+		}
+	})
+
+	if opts.SuiteHostAttributes {
+		c.applyHostAttributes(testSuites.TestSuites)
+	}
+
+	return testSuites, nil
+}
+
+// applyHostAttributes sets the hostname and package attributes expected by
+// some JUnit consumers (e.g. the Jenkins JUnit plugin) on every suite:
+//   - the hostname is the name of the device of a single device run, the
+//     configured hostname otherwise
+//   - the package is the classname prefix (the classname without the test
+//     class) shared by the test cases of the suite, e.g. the test bundle
+func (c *converter) applyHostAttributes(suites []JUnitTestSuite) {
+	hostname := c.opts.Hostname
+	if len(c.devices) == 1 && c.devices[0].DeviceName != "" {
+		hostname = c.devices[0].DeviceName
+	}
+
+	walkSuites(suites, func(suite *JUnitTestSuite) {
+		suite.Hostname = hostname
+		suite.Package = suitePackage(suite)
+	})
+}
+
+// suitePackage returns the classname prefix shared by all test cases of the
+// suite and its nested suites, or "" if they don't share one
+func suitePackage(suite *JUnitTestSuite) string {
+	pkg, found, shared := "", false, true
+	walkSuites([]JUnitTestSuite{*suite}, func(s *JUnitTestSuite) {
+		for _, tc := range s.TestCases {
+			prefix := ""
+			if idx := strings.LastIndex(tc.Classname, "."); idx != -1 {
+				prefix = tc.Classname[:idx]
+			}
+			if !found {
+				pkg, found = prefix, true
+			} else if prefix != pkg {
+				shared = false
+			}
+		}
+	})
+	if !shared {
+		return ""
+	}
+	return pkg
+}
+
+// deviceProperties returns a "device" property for every device of the run,
+// describing its name, model, OS and architecture
+func deviceProperties(devices []Device) []JUnitProperty {
+	var properties []JUnitProperty
+	for _, device := range devices {
+		value := device.DeviceName
+		if device.ModelName != "" && device.ModelName != device.DeviceName {
+			value += " (" + device.ModelName + ")"
+		}
+
+		details := []string{strings.TrimSpace(device.Platform + " " + device.OsVersion), device.Architecture}
+		for _, detail := range details {
+			if detail != "" {
+				value += ", " + detail
+			}
+		}
+
+		properties = append(properties, JUnitProperty{
+			Name:  "device",
+			Value: strings.TrimPrefix(value, ", "),
+		})
+	}
+	return properties
+}
+
+// finish applies the post-processing options shared by the xcresult formats
+func (c *converter) finish(testSuites *JUnitTestSuites) {
+	opts := c.opts
+	if opts.SourceRootPath != "" {
+		relativizeSourcePaths(testSuites, opts.SourceRootPath)
+	}
+
+	if opts.ReportPortalCompat {
+		applyReportPortalCompat(testSuites)
+	}
+
+	if opts.CollapseSingletonSuites {
+		name := opts.CollapsedSuiteName
+		if name == "" {
+			name = defaultCollapsedSuiteName
+		}
+		collapseSingletonSuites(testSuites, name)
+	}
+
+	if opts.Flatten {
+		name := opts.FlattenedSuiteName
+		if name == "" {
+			name = defaultFlattenedSuiteName
+		}
+		flattenSuites(testSuites, name)
+	}
+
+	// Names and messages end up in attributes and CDATA sections, where
+	// characters illegal in XML would make the report unparsable
+	sanitizeTestSuites(testSuites)
+
 	// Sort test suites and test cases
-	sortTestSuites(&testSuites)
+	sortTestSuites(testSuites)
 
-	// If no test suites were created, add a default one
-	if len(testSuites.TestSuites) == 0 {
+	if opts.MaxTestCases > 0 {
+		truncateTestCases(testSuites, opts.MaxTestCases)
+	}
+
+	// If no test suites were created, add a placeholder one
+	if len(testSuites.TestSuites) == 0 && !opts.OmitEmptySuite {
+		name := opts.EmptySuiteName
+		if name == "" {
+			name = defaultEmptySuiteName
+		}
 		testSuites.TestSuites = append(testSuites.TestSuites, JUnitTestSuite{
-			Name:      "XCTest",
+			Name:      name,
 			Tests:     0,
 			Failures:  0,
 			Errors:    0,
 			Time:      0,
-			Timestamp: time.Now().Format(time.RFC3339),
+			Timestamp: c.timestamp,
 		})
 	}
 
-	xmlData, err := xml.MarshalIndent(testSuites, "", "  ")
+	// Numbered once sorted, so the ids are stable
+	if opts.SuiteIDs {
+		assignSuiteIDs(testSuites)
+	}
+
+	// Summed durations end up with long decimals like 0.49999999
+	decimals := opts.TimeDecimals
+	if decimals == 0 {
+		decimals = defaultTimeDecimals
+	}
+	roundTimes(testSuites, decimals)
+}
+
+// roundTimes rounds the time of every test suite and test case to the number
+// of decimals
+func roundTimes(testSuites *JUnitTestSuites, decimals int) {
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		suite.Time = roundSeconds(suite.Time, decimals)
+		for i := range suite.TestCases {
+			suite.TestCases[i].Time = roundSeconds(suite.TestCases[i].Time, decimals)
+		}
+	})
+}
+
+// roundSeconds rounds the seconds to the number of decimals
+func roundSeconds(seconds float64, decimals int) float64 {
+	scale := math.Pow10(decimals)
+	return math.Round(seconds*scale) / scale
+}
+
+// assignSuiteIDs numbers all test suites in document order, starting at 0
+func assignSuiteIDs(testSuites *JUnitTestSuites) {
+	id := 0
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		suiteID := id
+		suite.ID = &suiteID
+		id++
+	})
+}
+
+// MarshalJUnitXML serializes the test suites as an indented JUnit XML document
+func MarshalJUnitXML(testSuites JUnitTestSuites) ([]byte, error) {
+	return marshalJUnitXML(testSuites, true)
+}
+
+// MarshalCompactJUnitXML serializes the test suites as a JUnit XML document
+// without indentation, which is considerably smaller for big reports
+func MarshalCompactJUnitXML(testSuites JUnitTestSuites) ([]byte, error) {
+	return marshalJUnitXML(testSuites, false)
+}
+
+func marshalJUnitXML(testSuites JUnitTestSuites, indent bool) ([]byte, error) {
+	// Many tools read the totals of the root element instead of summing the suites
+	testSuites.setTotals()
+
+	var xmlData []byte
+	var err error
+	if indent {
+		xmlData, err = xml.MarshalIndent(testSuites, "", "  ")
+	} else {
+		xmlData, err = xml.Marshal(testSuites)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal JUnit XML: %w", err)
 	}
@@ -149,126 +830,1904 @@ func ConvertXCResultJSONToJUnitXML(jsonData []byte) ([]byte, error) {
 	return append([]byte(xml.Header), xmlData...), nil
 }
 
-func processTestNodes(nodes []TestNode, classname string, suiteMap map[string]*JUnitTestSuite) {
-	for _, node := range nodes {
-		switch node.NodeType {
-		case "Unit test bundle", "UI test bundle", "Test Suite":
-			newClassname := buildClassName(classname, node.Name)
-			processTestNodes(node.Children, newClassname, suiteMap)
+// ParseJUnit parses a JUnit XML document. Both a <testsuites> root and a
+// single <testsuite> root are accepted.
+func ParseJUnit(r io.Reader) (*JUnitTestSuites, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JUnit XML: %w", err)
+	}
+
+	var testSuites JUnitTestSuites
+	err = xml.Unmarshal(data, &testSuites)
+	if err == nil {
+		return &testSuites, nil
+	}
 
-		case "Test Case":
-			processTestCase(node, classname, suiteMap)
+	var testSuite JUnitTestSuite
+	if xml.Unmarshal(data, &testSuite) == nil {
+		return &JUnitTestSuites{TestSuites: []JUnitTestSuite{testSuite}}, nil
+	}
 
-		case "Test Plan", "Test Plan Configuration":
-			// Process children of Test Plan nodes
-			processTestNodes(node.Children, classname, suiteMap)
+	return nil, fmt.Errorf("failed to parse JUnit XML: %w", err)
+}
+
+// VerifyRoundTrip unmarshals the generated JUnit XML and checks that it
+// describes the same number of test suites and test cases, and the same suite
+// counts, as the model it was generated from
+func VerifyRoundTrip(xmlData []byte, testSuites JUnitTestSuites) error {
+	parsed, err := ParseJUnit(bytes.NewReader(xmlData))
+	if err != nil {
+		return err
+	}
+
+	expectedSuites, expectedCases := countSuitesAndCases(testSuites)
+	parsedSuites, parsedCases := countSuitesAndCases(*parsed)
+	if expectedSuites != parsedSuites {
+		return fmt.Errorf("test suite count mismatch: expected %d, got %d", expectedSuites, parsedSuites)
+	}
+	if expectedCases != parsedCases {
+		return fmt.Errorf("test case count mismatch: expected %d, got %d", expectedCases, parsedCases)
+	}
 
-		case "Failure Message":
-			// Handled in test case processing
+	expectedCounts, parsedCounts := suiteCounts(testSuites), suiteCounts(*parsed)
+	for i := range expectedCounts {
+		if expectedCounts[i] != parsedCounts[i] {
+			return fmt.Errorf("test suite counts mismatch: expected %s, got %s", expectedCounts[i], parsedCounts[i])
 		}
 	}
+
+	return nil
 }
 
-func processTestCase(node TestNode, classname string, suiteMap map[string]*JUnitTestSuite) {
-	// Skip test configurations, only process actual test cases
-	if !strings.Contains(node.NodeIdentifier, "/") {
-		return
+// suiteCounts describes the name and counts of every suite, in document order
+func suiteCounts(testSuites JUnitTestSuites) []string {
+	var counts []string
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		counts = append(counts, fmt.Sprintf("%s (tests=%d failures=%d errors=%d skipped=%d)",
+			suite.Name, suite.Tests, suite.Failures, suite.Errors, suite.Skipped))
+	})
+	return counts
+}
+
+// validateSuiteCounts checks the JUnit invariant of every suite: the failed,
+// errored and skipped test cases are subsets of its tests. A violation points
+// to a bug in the conversion.
+func validateSuiteCounts(testSuites JUnitTestSuites) error {
+	var err error
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		if err != nil {
+			return
+		}
+		if suite.Tests < 0 || suite.Failures < 0 || suite.Errors < 0 || suite.Skipped < 0 {
+			err = fmt.Errorf("test suite %s has negative counts: %d tests, %d failures, %d errors, %d skipped",
+				suite.Name, suite.Tests, suite.Failures, suite.Errors, suite.Skipped)
+		} else if outcomes := suite.Failures + suite.Errors + suite.Skipped; outcomes > suite.Tests {
+			err = fmt.Errorf("test suite %s has %d failures, %d errors and %d skipped, more than its %d tests",
+				suite.Name, suite.Failures, suite.Errors, suite.Skipped, suite.Tests)
+		}
+	})
+	return err
+}
+
+func countSuitesAndCases(testSuites JUnitTestSuites) (int, int) {
+	suites, cases := 0, 0
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		suites++
+		cases += len(suite.TestCases)
+	})
+	return suites, cases
+}
+
+// converter holds the state of a single conversion
+type converter struct {
+	opts    ConvertOptions
+	devices []Device
+	// timestamp is the start of the test run, used for every suite
+	timestamp string
+	suiteMap  map[string]*JUnitTestSuite
+	// suiteDurations holds the durations reported for the suites by name
+	suiteDurations map[string]float64
+	// caseIndex holds the index of the test cases in their flat suite by
+	// identifier, to collapse the re-runs of a test
+	caseIndex map[string]int
+	// bundle is the name of the test bundle whose nodes are processed
+	bundle string
+	// configuration is the name of the test plan configuration whose nodes
+	// are processed, configurations holds all of them
+	configuration  string
+	configurations map[string]bool
+	// warnings are the distinct conditions the conversion doesn't fully
+	// understand, see ConvertOptions.Strict
+	warnings []string
+	// depth is the level of the test nodes (or legacy test groups) which
+	// are streamed
+	depth int
+}
+
+// errStrict is returned for the conversion warnings in strict mode
+var errStrict = errors.New("conversion warnings in strict mode")
+
+// warnf records a conversion warning, once
+func (c *converter) warnf(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	for _, existing := range c.warnings {
+		if existing == warning {
+			return
+		}
 	}
+	log.Debugf("Warning: %s", warning)
+	c.warnings = append(c.warnings, warning)
+}
 
-	parts := strings.Split(node.NodeIdentifier, "/")
-	if len(parts) < 2 {
-		return
+// knownNodeTypes lists the lowercased node types without test cases, found
+// in test cases (issues, devices, arguments, repetitions…)
+var knownNodeTypes = map[string]bool{
+	"failure message":       true,
+	"runtime warning":       true,
+	"device":                true,
+	"arguments":             true,
+	"repetition":            true,
+	"test case run":         true,
+	"source code reference": true,
+	"attachment":            true,
+	"expression":            true,
+	"test value":            true,
+}
+
+// checkNodeTypes warns about the unknown node types of the nodes and their
+// descendants
+func (c *converter) checkNodeTypes(nodes []TestNode) {
+	for _, node := range nodes {
+		nodeType := strings.ToLower(strings.TrimSpace(node.NodeType))
+		if classifyNodeType(nodeType) == nodeKindOther && !knownNodeTypes[nodeType] {
+			c.warnf("unknown node type %q of %s", node.NodeType, describeNode(node))
+		}
+		c.checkNodeTypes(node.Children)
 	}
+}
 
-	suiteName := parts[0]
-	if suiteName == "" {
-		suiteName = "UnknownSuite"
+// maxNodeDepth returns the deepest level of the test node tree processed
+func (c *converter) maxNodeDepth() int {
+	if c.opts.MaxNodeDepth > 0 {
+		return c.opts.MaxNodeDepth
 	}
+	return defaultMaxNodeDepth
+}
 
-	// Get or create test suite
-	suite, exists := suiteMap[suiteName]
-	if !exists {
-		suite = &JUnitTestSuite{
-			Name:      suiteName,
-			Timestamp: time.Now().Format(time.RFC3339),
-			TestCases: []JUnitTestCase{},
+// limitNodeDepth drops the children of the nodes at the deepest level
+// processed, so a malformed or cyclic tree can't exhaust the stack. The nodes
+// are at the given level of the tree, the top level nodes are at level 1.
+func (c *converter) limitNodeDepth(nodes []TestNode, depth int) {
+	for i := range nodes {
+		if len(nodes[i].Children) == 0 {
+			continue
+		}
+		if depth >= c.maxNodeDepth() {
+			c.warnf("test nodes nested deeper than %d levels are dropped under %s", c.maxNodeDepth(), describeNode(nodes[i]))
+			nodes[i].Children = nil
+			continue
 		}
-		suiteMap[suiteName] = suite
+		c.limitNodeDepth(nodes[i].Children, depth+1)
 	}
+}
 
-	// Parse duration
-	duration := parseDuration(node.Duration)
+// nodeDuration returns the duration of the node in seconds, warning about a
+// duration which can't be parsed
+func (c *converter) nodeDuration(node TestNode) float64 {
+	seconds, ok := parseDurationValue(string(node.Duration))
+	if !ok {
+		c.warnf("unparseable duration %q of %s", node.Duration, describeNode(node))
+	}
+	return seconds
+}
 
-	// Create test case
-	testCase := JUnitTestCase{
-		Name:      node.Name,
-		Classname: classname,
-		Time:      duration,
+// runTimestamp returns the start of the test run: the given start time, the
+// start time found in the JSON (seconds since the epoch) or the current time
+func runTimestamp(startTime time.Time, jsonStartTime float64) string {
+	if startTime.IsZero() && jsonStartTime > 0 {
+		startTime = time.Unix(0, int64(jsonStartTime*float64(time.Second)))
 	}
+	if startTime.IsZero() {
+		startTime = time.Now()
+	}
+	return startTime.Format(time.RFC3339)
+}
 
-	// Handle failures
-	if node.Result == "Failed" {
-		failureMessage := extractFailureMessage(node)
-		testCase.Failure = &JUnitFailure{
-			Message: failureMessage,
-			Type:    "Failure",
-			Content: failureMessage,
-		}
-		suite.Failures++
+// nodeKind is the role of a test node in the conversion
+type nodeKind int
+
+const (
+	nodeKindOther nodeKind = iota
+	// nodeKindContainer groups test cases (test bundles and suites), its name
+	// is part of the classname
+	nodeKindContainer
+	nodeKindTestCase
+	// nodeKindPassThrough groups containers without being part of the
+	// classname (test plans and their configurations)
+	nodeKindPassThrough
+)
+
+// containerNodeTypes lists the lowercased node types of test bundles and
+// suites, including the naming variants of different Xcode versions
+var containerNodeTypes = map[string]bool{
+	"unit test bundle": true,
+	"ui test bundle":   true,
+	"test bundle":      true,
+	"test target":      true,
+	"test suite":       true,
+	"test class":       true,
+}
+
+// classifyNode returns the role of a node. Node types are matched
+// case-insensitively and unknown node types holding test cases (at any depth)
+// are treated as containers so their tests aren't dropped.
+func classifyNode(node TestNode) nodeKind {
+	if kind := classifyNodeType(node.NodeType); kind != nodeKindOther {
+		return kind
 	}
 
-	suite.TestCases = append(suite.TestCases, testCase)
+	if containsTestCases(node) {
+		return nodeKindContainer
+	}
+	return nodeKindOther
 }
 
-func parseDuration(dur string) float64 {
-	dur = strings.TrimSuffix(dur, "s")
-	if dur == "" {
-		return 0
+// classifyNodeType returns the role of the known node types, unknown node
+// types are nodeKindOther
+func classifyNodeType(nodeType string) nodeKind {
+	nodeType = strings.ToLower(strings.TrimSpace(nodeType))
+	switch {
+	case containerNodeTypes[nodeType]:
+		return nodeKindContainer
+	case nodeType == "test case":
+		return nodeKindTestCase
+	case nodeType == "test plan", nodeType == "test plan configuration":
+		return nodeKindPassThrough
 	}
-	seconds, _ := strconv.ParseFloat(dur, 64)
-	return seconds
+	return nodeKindOther
 }
 
-func extractFailureMessage(node TestNode) string {
+// containsTestCases reports whether any descendant of the node is a test case
+func containsTestCases(node TestNode) bool {
 	for _, child := range node.Children {
-		if child.NodeType == "Failure Message" {
-			return child.Name
-		}
-
-		// Check deeper children
-		message := extractFailureMessage(child)
-		if message != "Test failed" {
-			return message
+		if strings.EqualFold(strings.TrimSpace(child.NodeType), "Test Case") || containsTestCases(child) {
+			return true
 		}
 	}
-	return "Test failed"
+	return false
 }
 
-func buildClassName(current, newPart string) string {
-	if current == "" {
-		return newPart
+// isConfigurationNode reports whether the node is a test plan configuration
+func isConfigurationNode(node TestNode) bool {
+	return strings.EqualFold(strings.TrimSpace(node.NodeType), "Test Plan Configuration")
+}
+
+// withConfiguration calls fn with the configuration of the node as the
+// current one, when the node is a test plan configuration
+func (c *converter) withConfiguration(node TestNode, fn func()) {
+	if !isConfigurationNode(node) {
+		fn()
+		return
 	}
-	return current + "." + newPart
+	if c.configurations == nil {
+		c.configurations = map[string]bool{}
+	}
+	configuration := c.configuration
+	c.configuration = node.Name
+	c.configurations[node.Name] = true
+	fn()
+	c.configuration = configuration
 }
 
-func totalSuiteTime(cases []JUnitTestCase) float64 {
-	var total float64
-	for _, tc := range cases {
-		total += tc.Time
+// configurationKey returns the key of a suite or test case qualified with the
+// current test plan configuration
+func (c *converter) configurationKey(key string) string {
+	if c.configuration == "" {
+		return key
 	}
-	return total
+	return key + " {" + c.configuration + "}"
 }
 
-func sortTestSuites(suites *JUnitTestSuites) {
-	// Sort test suites
-	sort.Slice(suites.TestSuites, func(i, j int) bool {
-		return suites.TestSuites[i].Name < suites.TestSuites[j].Name
+// isBundleNode reports whether the node is a test bundle, i.e. a test target
+func isBundleNode(node TestNode) bool {
+	return strings.HasSuffix(strings.ToLower(node.NodeType), "test bundle")
+}
+
+// isSuiteNode reports whether the node is a test suite (as opposed to a test bundle)
+func isSuiteNode(node TestNode) bool {
+	return strings.EqualFold(node.NodeType, "Test Suite") || strings.EqualFold(node.NodeType, "Test Class")
+}
+
+// processTestNodes adds the test cases found under the nodes to their flat
+// suites. With verbose logging every node is traced, to find out where the
+// tests went (or why they were dropped).
+func (c *converter) processTestNodes(nodes []TestNode, classname string) {
+	for _, node := range nodes {
+		switch classifyNode(node) {
+		case nodeKindContainer:
+			log.Debugf("Container %s (%s)", describeNode(node), node.NodeType)
+			if classifyNodeType(node.NodeType) == nodeKindOther {
+				c.warnf("unknown node type %q of %s, processed as a container of test cases", node.NodeType, describeNode(node))
+			}
+			if isSuiteNode(node) {
+				c.suiteDurations[c.configurationKey(node.Name)] += c.nodeDuration(node)
+			}
+			bundle := c.bundle
+			if isBundleNode(node) {
+				c.bundle = node.Name
+			}
+			c.processTestNodes(node.Children, buildClassName(classname, node.Name))
+			c.bundle = bundle
+
+		case nodeKindTestCase:
+			c.processTestCase(node, classname)
+
+		case nodeKindPassThrough:
+			// Process children of Test Plan nodes
+			log.Debugf("Passing through %s (%s)", describeNode(node), node.NodeType)
+			c.withConfiguration(node, func() {
+				c.processTestNodes(node.Children, classname)
+			})
+
+		default:
+			// Failure messages, repetitions, etc. are handled in test case processing
+			log.Debugf("Ignoring %s (%s) without test cases", describeNode(node), node.NodeType)
+			c.checkNodeTypes([]TestNode{node})
+		}
+	}
+}
+
+// describeNode returns the name and the identifier of the node for the logs
+func describeNode(node TestNode) string {
+	if node.NodeIdentifier == "" || node.NodeIdentifier == node.Name {
+		return fmt.Sprintf("%q", node.Name)
+	}
+	return fmt.Sprintf("%q [%s]", node.Name, node.NodeIdentifier)
+}
+
+// buildSuiteTree mirrors the test node hierarchy (bundle → suite → subsuite)
+// as nested test suites, each holding its direct test cases
+func (c *converter) buildSuiteTree(nodes []TestNode, classname string) []JUnitTestSuite {
+	var suites []JUnitTestSuite
+	for _, node := range nodes {
+		switch classifyNode(node) {
+		case nodeKindContainer:
+			newClassname := buildClassName(classname, node.Name)
+			suite := JUnitTestSuite{
+				Name:          c.outputSuiteName(node.Name),
+				TestCases:     []JUnitTestCase{},
+				reportedTime:  c.nodeDuration(node),
+				configuration: c.configuration,
+			}
+			bundle := c.bundle
+			if isBundleNode(node) {
+				c.bundle = node.Name
+			} else {
+				suite.addBundle(c.bundle)
+			}
+			for _, child := range node.Children {
+				if classifyNode(child) == nodeKindTestCase {
+					if testCase := c.newTestCase(child, newClassname); c.isIncluded(testCase) {
+						suite.TestCases = append(suite.TestCases, testCase)
+					}
+				}
+			}
+			suite.TestSuites = c.buildSuiteTree(node.Children, newClassname)
+			c.bundle = bundle
+			suites = append(suites, suite)
+
+		case nodeKindPassThrough:
+			c.withConfiguration(node, func() {
+				suites = append(suites, c.buildSuiteTree(node.Children, classname)...)
+			})
+		}
+	}
+	return suites
+}
+
+func (c *converter) processTestCase(node TestNode, classname string) {
+	// A test which ran on several devices lists them as children with their
+	// own result
+	if c.opts.DisambiguateByDevice {
+		if runs := deviceRuns(node); len(runs) > 0 {
+			for _, run := range runs {
+				c.addTestCase(run.TestNode, classname, run.device)
+			}
+			return
+		}
+	}
+	c.addTestCase(node, classname, "")
+}
+
+// addTestCase adds the test case to its flat suite, the one of the device
+// when the test ran on several devices
+func (c *converter) addTestCase(node TestNode, classname, device string) {
+	testCase := c.newTestCase(node, classname)
+	if !c.isIncluded(testCase) {
+		log.Debugf("Test case %s dropped by the include and exclude patterns or as skipped", describeNode(node))
+		return
+	}
+
+	suiteName := testCase.Classname
+	if c.opts.GroupBy != GroupByClassname {
+		suiteName = c.outputSuiteName(flatSuiteName(node, classname))
+	}
+	if suiteName == "" {
+		suiteName = "UnknownSuite"
+	}
+
+	// Re-runs of a test are reported as separate nodes with the same identifier,
+	// indexed within the suite they are added to. Identifiers without a suite
+	// path may be shared by several tests, those are told apart by their name.
+	// The tests of different bundles may share an identifier, they aren't
+	// re-runs of each other.
+	identifier := node.NodeIdentifier
+	if !strings.Contains(identifier, "/") {
+		identifier = node.Name
+	}
+	key := suiteName + " " + identifier
+	if c.bundle != "" {
+		key = c.bundle + "/" + key
+	}
+	// The runs of a test with several configurations aren't retries, neither
+	// are the runs of the merged xcresult bundles
+	key = c.configurationKey(key)
+	if node.Source > 0 {
+		key += fmt.Sprintf(" #%d", node.Source)
+	}
+
+	var suite *JUnitTestSuite
+	if device == "" {
+		suite = c.suite(suiteName)
+		// Only the runs on the same devices are re-runs of each other
+		if len(testCase.Devices) > 0 {
+			key += " [" + strings.Join(testCase.Devices, ", ") + "]"
+		}
+	} else {
+		testCase.Devices = []string{device}
+		if testCase.Failure != nil || testCase.Error != nil {
+			testCase.FailedDevices = testCase.Devices
+		}
+		suite = c.deviceSuite(suiteName, device)
+		suiteName += " [" + device + "]"
+		key += " [" + device + "]"
+	}
+
+	if idx, seen := c.caseIndex[key]; seen {
+		log.Debugf("Test case %s (%s) merged into its earlier run in suite %s", describeNode(node), node.Result, suiteName)
+		suite.mergeRetry(idx, testCase)
+		return
+	}
+	log.Debugf("Test case %s (%s) added to suite %s", describeNode(node), node.Result, suiteName)
+	c.caseIndex[key] = len(suite.TestCases)
+	suite.addTestCase(testCase)
+}
+
+// deviceRun is the run of a test case on one of several devices
+type deviceRun struct {
+	TestNode
+	device string
+}
+
+// deviceRuns returns the runs of a test case on each of its devices as test
+// case nodes holding the result, duration and issues of the device
+func deviceRuns(node TestNode) []deviceRun {
+	var runs []deviceRun
+	for _, child := range node.Children {
+		if child.NodeType != "Device" {
+			continue
+		}
+		run := node
+		run.Result = child.Result
+		if child.Duration != "" {
+			run.Duration = child.Duration
+		}
+		run.Children = child.Children
+		runs = append(runs, deviceRun{TestNode: run, device: child.Name})
+	}
+	return runs
+}
+
+// expectedFailureResult is the result of a test whose failures were all
+// expected with XCTExpectFailure
+const expectedFailureResult = "Expected Failure"
+
+// markExpectedFailure marks a test whose failures were expected. The test
+// passes, the expectedFailure property and a note make it distinguishable.
+// A test which didn't record the failure it expected is reported by XCTest
+// as failed, so it is a failure like any other.
+func markExpectedFailure(testCase *JUnitTestCase, message string) {
+	testCase.addProperty("expectedFailure", "true")
+
+	note := "Expected failure"
+	if message != "" {
+		note += ": " + message
+	}
+	if testCase.SystemOut != "" {
+		note = string(testCase.SystemOut) + "\n" + note
+	}
+	testCase.SystemOut = cdata(note)
+}
+
+// addProperty appends a property to the test case
+func (testCase *JUnitTestCase) addProperty(name, value string) {
+	if testCase.Properties == nil {
+		testCase.Properties = &JUnitProperties{}
+	}
+	testCase.Properties.Properties = append(testCase.Properties.Properties, JUnitProperty{Name: name, Value: value})
+}
+
+// setProperty sets the property of the test case, replacing its value if the
+// test case has it already
+func (testCase *JUnitTestCase) setProperty(name, value string) {
+	if testCase.Properties != nil {
+		properties := testCase.Properties.Properties
+		for i := range properties {
+			if properties[i].Name == name {
+				// The properties may be shared with the other runs of the test
+				properties = append([]JUnitProperty{}, properties...)
+				properties[i].Value = value
+				testCase.Properties = &JUnitProperties{Properties: properties}
+				return
+			}
+		}
+	}
+	testCase.addProperty(name, value)
+}
+
+// flatSuiteName returns the name of the flat suite of a test case, the path of
+// its identifier without the test. The suites of nested suites are dotted,
+// e.g. "LoginTests/SSOTests/testGoogle()" belongs to "LoginTests.SSOTests".
+// A single segment identifier (some Swift Testing tests) names the suite
+// itself, without an identifier the innermost container of the test is used.
+func flatSuiteName(node TestNode, classname string) string {
+	identifier := node.NodeIdentifier
+	if identifier == "" {
+		return classname[strings.LastIndex(classname, ".")+1:]
+	}
+	if !strings.Contains(identifier, "/") {
+		return identifier
+	}
+	if suffix := "/" + node.Name; node.Name != "" && strings.HasSuffix(identifier, suffix) {
+		// The test name itself may contain slashes
+		identifier = strings.TrimSuffix(identifier, suffix)
+	} else {
+		identifier = identifier[:strings.LastIndex(identifier, "/")]
+	}
+	return strings.ReplaceAll(identifier, "/", ".")
+}
+
+// isIncluded reports whether the test case passes the include and exclude
+// patterns, which are matched against its "classname.name", and isn't an
+// omitted skipped test
+func (c *converter) isIncluded(testCase JUnitTestCase) bool {
+	if c.opts.OmitSkipped && testCase.Skipped != nil {
+		return false
+	}
+	if c.opts.IncludePattern == nil && c.opts.ExcludePattern == nil {
+		return true
+	}
+
+	fullName := buildClassName(testCase.Classname, testCase.Name)
+	if c.opts.IncludePattern != nil && !c.opts.IncludePattern.MatchString(fullName) {
+		return false
+	}
+	return c.opts.ExcludePattern == nil || !c.opts.ExcludePattern.MatchString(fullName)
+}
+
+// suite returns the flat test suite with the given name, creating it if needed
+func (c *converter) suite(name string) *JUnitTestSuite {
+	key := c.configurationKey(name)
+	suite, exists := c.suiteMap[key]
+	if !exists {
+		suite = &JUnitTestSuite{
+			Name:          name,
+			TestCases:     []JUnitTestCase{},
+			configuration: c.configuration,
+		}
+		c.suiteMap[key] = suite
+	}
+	suite.addBundle(c.bundle)
+	return suite
+}
+
+// deviceSuite returns the flat test suite of the device with the given
+// name, creating it if needed. The device is added to the name of the suite
+// once the devices of the run are known, see deviceLabel.
+func (c *converter) deviceSuite(name, device string) *JUnitTestSuite {
+	key := c.configurationKey(name) + " [" + device + "]"
+	suite, exists := c.suiteMap[key]
+	if !exists {
+		suite = &JUnitTestSuite{
+			Name:          name,
+			TestCases:     []JUnitTestCase{},
+			device:        device,
+			configuration: c.configuration,
+		}
+		c.suiteMap[key] = suite
+	}
+	suite.addBundle(c.bundle)
+	return suite
+}
+
+// deviceLabel describes the device of a suite with its OS version, e.g.
+// "iPhone 15, iOS 17.0", when the run lists the device
+func (c *converter) deviceLabel(name string) string {
+	for _, device := range c.devices {
+		if device.DeviceName != name {
+			continue
+		}
+		if osVersion := strings.TrimSpace(strings.TrimSuffix(device.Platform, " Simulator") + " " + device.OsVersion); osVersion != "" {
+			return name + ", " + osVersion
+		}
+	}
+	return name
+}
+
+// addTestCase appends the test case to the suite and counts its outcome
+func (suite *JUnitTestSuite) addTestCase(testCase JUnitTestCase) {
+	suite.countOutcome(testCase, 1)
+	suite.TestCases = append(suite.TestCases, testCase)
+}
+
+// countOutcome adds delta to the counter of the test case's outcome
+func (suite *JUnitTestSuite) countOutcome(testCase JUnitTestCase, delta int) {
+	if testCase.Failure != nil {
+		suite.Failures += delta
+	}
+	if testCase.Error != nil {
+		suite.Errors += delta
+	}
+	if testCase.Skipped != nil {
+		suite.Skipped += delta
+	}
+}
+
+// mergeRetry collapses a re-run of the test case at idx into it. The test
+// passes if any of its attempts passed, the failed attempts of a passing test
+// are kept as flaky failures. A test failing every attempt keeps the failure
+// of the latest one. A test run again after passing was repeated rather than
+// retried, see mergeRepetition.
+func (suite *JUnitTestSuite) mergeRetry(idx int, retry JUnitTestCase) {
+	existing := &suite.TestCases[idx]
+	suite.countOutcome(*existing, -1)
+
+	if existing.repeated || retry.repeated || isPassing(*existing) {
+		*existing = mergeRepetition(*existing, retry)
+		suite.countOutcome(*existing, 1)
+		return
+	}
+
+	merged := retry
+	merged.Time = existing.Time + retry.Time
+	merged.runs = existing.runCount() + retry.runCount()
+	merged.FlakyFailures = append(append([]JUnitFlakyFailure{}, existing.FlakyFailures...), retry.FlakyFailures...)
+	if isPassing(merged) {
+		if failure, failed := asFlakyFailure(*existing); failed {
+			merged.FlakyFailures = append(merged.FlakyFailures, failure)
+		}
+	}
+	if len(merged.FlakyFailures) == 0 {
+		merged.FlakyFailures = nil
+	}
+
+	*existing = merged
+	suite.countOutcome(*existing, 1)
+}
+
+// mergeRepetition merges the repeated executions of a test (e.g. "Run Tests
+// Repeatedly" of Xcode) into one test case with the worst result, the time
+// of all executions and a repetitions property. Unlike retries, repetitions
+// aren't flaky: a test failing any of them fails.
+func mergeRepetition(existing, repetition JUnitTestCase) JUnitTestCase {
+	merged := existing
+	if outcomeSeverity(repetition) > outcomeSeverity(existing) {
+		merged = repetition
+	}
+	merged.Time = existing.Time + repetition.Time
+	merged.runs = existing.runCount() + repetition.runCount()
+	merged.repeated = true
+	merged.FlakyFailures = nil
+	merged.setProperty("repetitions", strconv.Itoa(merged.runs))
+	return merged
+}
+
+// runCount returns the number of executions merged into the test case
+func (testCase JUnitTestCase) runCount() int {
+	if testCase.runs == 0 {
+		return 1
+	}
+	return testCase.runs
+}
+
+// outcomeSeverity ranks the outcomes of a test case from passed to error
+func outcomeSeverity(testCase JUnitTestCase) int {
+	switch {
+	case testCase.Error != nil:
+		return 3
+	case testCase.Failure != nil:
+		return 2
+	case testCase.Skipped != nil:
+		return 1
+	}
+	return 0
+}
+
+// asFlakyFailure returns the failure or error of a failed attempt as a flaky failure
+func asFlakyFailure(attempt JUnitTestCase) (JUnitFlakyFailure, bool) {
+	switch {
+	case attempt.Failure != nil:
+		return JUnitFlakyFailure{Message: attempt.Failure.Message, Type: attempt.Failure.Type, Content: attempt.Failure.Content}, true
+	case attempt.Error != nil:
+		return JUnitFlakyFailure{Message: attempt.Error.Message, Type: attempt.Error.Type, Content: attempt.Error.Content}, true
+	}
+	return JUnitFlakyFailure{}, false
+}
+
+// newTestCase converts a test case node
+func (c *converter) newTestCase(node TestNode, classname string) JUnitTestCase {
+	duration := c.nodeDuration(node)
+	c.checkNodeTypes(node.Children)
+
+	name := node.Name
+	if c.opts.StripMethodParens {
+		name = stripMethodParens(name)
+	}
+
+	// Create test case
+	testCase := JUnitTestCase{
+		Name:       name,
+		Classname:  c.outputClassname(classname),
+		Time:       duration,
+		identifier: node.NodeIdentifier,
+	}
+	testCase.Devices, testCase.FailedDevices = c.testCaseDevices(node)
+	testCase.Assertions = countAssertions(node)
+
+	// The tags of Swift Testing tests, e.g. @Test(.tags(.critical))
+	if !c.opts.OmitTags {
+		for _, tag := range node.Tags {
+			testCase.addProperty("tag", tag)
+		}
+	}
+
+	// The logged activities and the warning-severity issues, which never fail
+	// a test, are surfaced as notes. The failed assertions go to system-err.
+	crashed := isCrashed(node)
+	var notes, errs []string
+	if c.includeActivityLog(node.Result == "Failed" || crashed) {
+		notes, errs = extractActivityLog(node)
+	}
+	notes = append(notes, extractWarningMessages(node)...)
+	if len(notes) > 0 {
+		testCase.SystemOut = cdata(strings.Join(notes, "\n"))
+	}
+	if len(errs) > 0 {
+		testCase.SystemErr = cdata(strings.Join(errs, "\n"))
+	}
+
+	// Handle failures, unexpected errors are reported as errors
+	if node.Result == "Failed" {
+		failureMessage := failureMessageOrDefault(node)
+		content := failureMessage
+		if details := extractFailureDetails(node); len(details) > 0 {
+			content = strings.Join(details, "\n")
+		}
+		content = c.addFailureContext(content, node, failureMessage)
+		c.setFailure(&testCase, failureMessage, content)
+	}
+
+	// A crash is an error whatever the failure messages say
+	if crashed {
+		content := fmt.Sprintf("The test didn't finish, its result is %q", node.Result)
+		message := ""
+		if details := extractFailureDetails(node); len(details) > 0 {
+			content = strings.Join(details, "\n")
+			message, _ = extractFailureMessage(node)
+		}
+		content = c.addFailureContext(content, node, message)
+		testCase.Failure = nil
+		testCase.Error = &JUnitError{Message: crashedMessage, Type: "Crash", Content: content}
+	}
+
+	// IDEs jump to the failed line with the file and line attributes
+	if c.opts.LocationAttributes && (testCase.Failure != nil || testCase.Error != nil) {
+		if issue, ok := firstFailureIssue(node); ok {
+			loc := issueLocation(issue)
+			testCase.File, testCase.Line = loc.FilePath, loc.LineNumber
+		}
+	}
+
+	// Handle skipped tests
+	if node.Result == "Skipped" {
+		testCase.Skipped = &JUnitSkipped{
+			Message: extractSkipMessage(node),
+		}
+	}
+
+	if node.Result == expectedFailureResult {
+		message, _ := extractFailureMessage(node)
+		markExpectedFailure(&testCase, message)
+	}
+
+	if attempts := repetitionNodes(node); isRepeatedRun(attempts) {
+		c.applyRepetitions(&testCase, attempts)
+	} else {
+		testCase.FlakyFailures = flakyFailures(attempts)
+	}
+	c.limitFailureLength(&testCase)
+
+	return testCase
+}
+
+// setFailure fails the test case with the message. Unexpected errors are
+// reported as errors, assertion failures as FailureElement says.
+func (c *converter) setFailure(testCase *JUnitTestCase, message, content string) {
+	switch {
+	case isErrorMessage(message):
+		testCase.Error = &JUnitError{Message: message, Type: "Error", Content: content}
+	case c.opts.FailureElement == FailureElementError:
+		testCase.Error = &JUnitError{Message: message, Type: "Failure", Content: content}
+	default:
+		testCase.Failure = &JUnitFailure{Message: message, Type: "Failure", Content: content}
+	}
+}
+
+// includeActivityLog reports whether the activity log of a test case goes
+// to its system-out and system-err according to SystemOutOn
+func (c *converter) includeActivityLog(failed bool) bool {
+	switch c.opts.SystemOutOn {
+	case SystemOutAlways:
+		return true
+	case SystemOutNever:
+		return false
+	}
+	return failed
+}
+
+// truncatedMarker ends the failure contents cut at MaxFailureLength
+const truncatedMarker = "...(truncated)"
+
+// limitFailureLength truncates the failure and error contents longer than
+// MaxFailureLength characters. The message of a truncated failure is cut to
+// its first line, which holds the failed assertion.
+func (c *converter) limitFailureLength(testCase *JUnitTestCase) {
+	max := c.opts.MaxFailureLength
+	if max <= 0 {
+		return
+	}
+
+	if failure := testCase.Failure; failure != nil {
+		if content, truncated := truncateText(failure.Content, max); truncated {
+			failure.Content = content
+			failure.Message = firstLine(failure.Message)
+		}
+	}
+	if testErr := testCase.Error; testErr != nil {
+		if content, truncated := truncateText(testErr.Content, max); truncated {
+			testErr.Content = content
+			testErr.Message = firstLine(testErr.Message)
+		}
+	}
+}
+
+// truncateText cuts the text to max characters followed by truncatedMarker,
+// reporting whether it was longer
+func truncateText(text string, max int) (string, bool) {
+	if utf8.RuneCountInString(text) <= max {
+		return text, false
+	}
+	runes := []rune(text)
+	return string(runes[:max]) + truncatedMarker, true
+}
+
+// firstLine returns the text up to its first line break
+func firstLine(text string) string {
+	if idx := strings.IndexByte(text, '\n'); idx >= 0 {
+		return text[:idx]
+	}
+	return text
+}
+
+// outputClassname applies the classname options to a generated classname
+func (c *converter) outputClassname(classname string) string {
+	if !c.opts.RawNames {
+		// Every segment is a container name of its own
+		segments := strings.Split(classname, ".")
+		for i, segment := range segments {
+			segments[i] = sanitizeName(segment)
+		}
+		classname = strings.Join(segments, ".")
+	}
+	if prefix := strings.TrimSuffix(c.opts.ClassnamePrefixStrip, "."); prefix != "" {
+		// Only whole segments are stripped, MyApp is not a prefix of MyAppTests.Login
+		if classname == prefix {
+			classname = ""
+		} else if strings.HasPrefix(classname, prefix+".") {
+			classname = strings.TrimPrefix(classname, prefix+".")
+		}
+	}
+	if c.opts.ClassnameReplaceSpaces {
+		classname = strings.ReplaceAll(classname, " ", "_")
+	}
+	return classname
+}
+
+// outputSuiteName sanitizes the suite name unless raw names are kept
+func (c *converter) outputSuiteName(name string) string {
+	if c.opts.RawNames {
+		return name
+	}
+	return sanitizeName(name)
+}
+
+// sanitizeName trims the whitespace around a suite name or classname and
+// replaces the characters which break file names and some report parsers
+// with "_", see isUnsafeNameRune. The file names derived from the names, see
+// safeFileName, follow the same rules.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if isUnsafeNameRune(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+}
+
+// isUnsafeNameRune reports whether the character is unsafe in names: path
+// separators, the characters reserved in file names on Windows and control
+// characters (e.g. line breaks)
+func isUnsafeNameRune(r rune) bool {
+	return strings.ContainsRune(`/\:*?"<>|`, r) || unicode.IsControl(r)
+}
+
+// stripMethodParens removes the empty trailing "()" of a Swift test method
+// name, names with arguments like "testWith(param:)" are kept as is
+func stripMethodParens(name string) string {
+	return strings.TrimSuffix(name, "()")
+}
+
+// repetitionNodes returns the executions of a test case which ran several
+// times, each of them is a Repetition child node
+func repetitionNodes(node TestNode) []TestNode {
+	var attempts []TestNode
+	for _, child := range node.Children {
+		if child.NodeType == "Repetition" {
+			attempts = append(attempts, child)
+		}
+	}
+	return attempts
+}
+
+// isRepeatedRun reports whether the executions were repetitions rather than
+// retries. A retried test stops running once it passed, so an execution
+// following a passed one was repeated on purpose.
+func isRepeatedRun(attempts []TestNode) bool {
+	for i := 0; i < len(attempts)-1; i++ {
+		if attempts[i].Result == "Passed" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRepetitions reports the repeated executions of a test case with a
+// repetitions property and the worst result: a test case passing overall
+// fails with the failure of its first failed execution
+func (c *converter) applyRepetitions(testCase *JUnitTestCase, attempts []TestNode) {
+	testCase.runs = len(attempts)
+	testCase.repeated = true
+	testCase.addProperty("repetitions", strconv.Itoa(len(attempts)))
+
+	var total float64
+	for _, attempt := range attempts {
+		total += parseDuration(string(attempt.Duration))
+	}
+	if total > testCase.Time {
+		testCase.Time = total
+	}
+
+	if testCase.Failure != nil || testCase.Error != nil {
+		return
+	}
+	for _, attempt := range attempts {
+		if attempt.Result != "Failed" {
+			continue
+		}
+		failureMessage := failureMessageOrDefault(attempt)
+		testCase.Skipped = nil
+		c.setFailure(testCase, failureMessage, failureMessage)
+		return
+	}
+}
+
+// flakyFailures returns the failed attempts of a test case which passed on
+// retry
+func flakyFailures(attempts []TestNode) []JUnitFlakyFailure {
+	if len(attempts) < 2 || attempts[len(attempts)-1].Result != "Passed" {
+		return nil
+	}
+
+	var failures []JUnitFlakyFailure
+	for _, attempt := range attempts[:len(attempts)-1] {
+		if attempt.Result != "Failed" {
+			continue
+		}
+		failureMessage := failureMessageOrDefault(attempt)
+		failures = append(failures, JUnitFlakyFailure{
+			Message: failureMessage,
+			Type:    "Failure",
+			Content: failureMessage,
+		})
+	}
+	return failures
+}
+
+// testCaseDevices returns the devices a test case ran and failed on.
+// Multi-device runs list the devices as children of the test case with their
+// own result, otherwise applyRunDevice sets the only device of the run.
+func (c *converter) testCaseDevices(node TestNode) ([]string, []string) {
+	var devices, failed []string
+	for _, child := range node.Children {
+		if child.NodeType != "Device" {
+			continue
+		}
+		devices = append(devices, child.Name)
+		if child.Result == "Failed" {
+			failed = append(failed, child.Name)
+		}
+	}
+	return devices, failed
+}
+
+// applyRunDevice sets the only device of a single device run on the test
+// cases which don't list their devices
+func (c *converter) applyRunDevice(suites []JUnitTestSuite) {
+	if len(c.devices) != 1 {
+		return
+	}
+
+	device := []string{c.devices[0].DeviceName}
+	walkSuites(suites, func(suite *JUnitTestSuite) {
+		for i := range suite.TestCases {
+			tc := &suite.TestCases[i]
+			if len(tc.Devices) > 0 {
+				continue
+			}
+			tc.Devices = device
+			if tc.Failure != nil || tc.Error != nil {
+				tc.FailedDevices = device
+			}
+		}
 	})
+}
 
-	// Sort test cases within each suite
-	for i := range suites.TestSuites {
-		sort.Slice(suites.TestSuites[i].TestCases, func(a, b int) bool {
-			return suites.TestSuites[i].TestCases[a].Name < suites.TestSuites[i].TestCases[b].Name
+// parseDuration returns the number of seconds of a duration like "0.5s",
+// "250ms", "1m3s" or "1,5s". Plain numbers are seconds, unparseable
+// durations are 0.
+func parseDuration(dur string) float64 {
+	seconds, _ := parseDurationValue(dur)
+	return seconds
+}
+
+// parseDurationValue parses the duration like parseDuration, ok is false for
+// a duration which can't be parsed. No duration at all is fine.
+func parseDurationValue(dur string) (seconds float64, ok bool) {
+	dur = strings.TrimSpace(dur)
+	if dur == "" {
+		return 0, true
+	}
+
+	var total float64
+	for rest := dur; rest != ""; {
+		rest = strings.TrimLeft(rest, " ")
+
+		numberEnd := strings.IndexFunc(rest, func(r rune) bool {
+			return !(r >= '0' && r <= '9' || r == '.' || r == ',')
+		})
+		if numberEnd == -1 {
+			numberEnd = len(rest)
+		}
+		value, err := strconv.ParseFloat(normalizeDecimalSeparator(rest[:numberEnd]), 64)
+		if err != nil {
+			return 0, false
+		}
+		rest = rest[numberEnd:]
+
+		unitEnd := strings.IndexFunc(rest, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z')
 		})
+		if unitEnd == -1 {
+			unitEnd = len(rest)
+		}
+		unit := rest[:unitEnd]
+		rest = rest[unitEnd:]
+
+		switch unit {
+		case "ms":
+			total += value / 1000
+		case "s", "":
+			total += value
+		case "m", "min":
+			total += value * 60
+		case "h":
+			total += value * 3600
+		default:
+			return 0, false
+		}
+	}
+	return total, true
+}
+
+// normalizeDecimalSeparator converts numbers serialized with a locale specific
+// decimal comma (e.g. "1,5") to use a decimal dot. When both separators are
+// present the comma is a thousands separator and gets dropped.
+func normalizeDecimalSeparator(number string) string {
+	if !strings.Contains(number, ",") {
+		return number
+	}
+	if strings.Contains(number, ".") {
+		return strings.ReplaceAll(number, ",", "")
+	}
+	return strings.Replace(number, ",", ".", 1)
+}
+
+// issueSeverity classifies an issue recorded under a test case
+type issueSeverity int
+
+const (
+	severityNone issueSeverity = iota
+	severityWarning
+	severityError
+)
+
+// classifyIssue returns the severity of an issue node. XCTest failure messages
+// are always errors, while Swift Testing may record issues with a warning
+// severity which must not fail the test.
+func classifyIssue(node TestNode) issueSeverity {
+	switch node.NodeType {
+	case "Failure Message":
+		if strings.EqualFold(node.Severity, "warning") {
+			return severityWarning
+		}
+		return severityError
+	case "Runtime Warning":
+		return severityWarning
+	}
+	return severityNone
+}
+
+// extractWarningMessages returns the warning-severity issues of the node and its descendants
+func extractWarningMessages(node TestNode) []string {
+	var warnings []string
+	for _, child := range node.Children {
+		if classifyIssue(child) == severityWarning {
+			warnings = append(warnings, "warning: "+child.Name)
+			continue
+		}
+		warnings = append(warnings, extractWarningMessages(child)...)
+	}
+	return warnings
+}
+
+// extractActivityLog returns the titles and messages of the activities
+// logged while the test ran, in order. The failed assertions are returned
+// separately as errors.
+func extractActivityLog(node TestNode) (lines []string, errs []string) {
+	for _, entry := range node.ActivitySummaries.Values {
+		activity := entry.ActivitySummary
+		var activityLines []string
+		if activity.Title != "" {
+			activityLines = append(activityLines, activity.Title)
+		}
+		for _, message := range activity.Messages {
+			if message.StringValue != "" {
+				activityLines = append(activityLines, "  "+message.StringValue)
+			}
+		}
+
+		if isAssertionActivity(activity) {
+			errs = append(errs, activityLines...)
+		} else {
+			lines = append(lines, activityLines...)
+		}
+	}
+	return lines, errs
+}
+
+// addFailureContext appends the activities logged around the failure to the
+// failure details, when FailureContextLines is set
+func (c *converter) addFailureContext(content string, node TestNode, message string) string {
+	if c.opts.FailureContextLines <= 0 {
+		return content
+	}
+	context := failureContext(node, message, c.opts.FailureContextLines)
+	if len(context) == 0 {
+		return content
+	}
+	return content + "\n\nActivity context:\n" + strings.Join(context, "\n")
+}
+
+// failureContext returns the titles of up to lines activities logged before
+// and after the failed one, which is marked with "> ". The failed activity is
+// the one titled with the failure message, or else the first assertion. No
+// context is returned when neither is found.
+func failureContext(node TestNode, message string, lines int) []string {
+	activities := node.ActivitySummaries.Values
+	failed := -1
+	if message != "" {
+		for i, entry := range activities {
+			if strings.Contains(entry.ActivitySummary.Title, message) {
+				failed = i
+				break
+			}
+		}
+	}
+	if failed == -1 {
+		for i, entry := range activities {
+			if isAssertionActivity(entry.ActivitySummary) {
+				failed = i
+				break
+			}
+		}
+	}
+	if failed == -1 {
+		return nil
+	}
+
+	start, end := failed-lines, failed+lines+1
+	if start < 0 {
+		start = 0
+	}
+	if end > len(activities) {
+		end = len(activities)
+	}
+	var context []string
+	for i := start; i < end; i++ {
+		title := activities[i].ActivitySummary.Title
+		if title == "" {
+			continue
+		}
+		if i == failed {
+			context = append(context, "> "+title)
+		} else {
+			context = append(context, "  "+title)
+		}
+	}
+	return context
+}
+
+// assertionTitlePrefixes start the titles of the activities XCTest and Swift
+// Testing record for assertions
+var assertionTitlePrefixes = []string{"XCTAssert", "XCTFail", "XCTUnwrap", "#expect", "#require"}
+
+// countAssertions returns the number of assertion activities of the test case.
+// XCTest mostly records the failed assertions only, so this is a lower bound.
+func countAssertions(node TestNode) int {
+	count := 0
+	for _, entry := range node.ActivitySummaries.Values {
+		if isAssertionActivity(entry.ActivitySummary) {
+			count++
+		}
+	}
+	return count
+}
+
+// isAssertionActivity reports whether the activity records an assertion,
+// identified by its activity type or title
+func isAssertionActivity(activity ActivitySummary) bool {
+	if strings.Contains(strings.ToLower(activity.ActivityType), "assertion") {
+		return true
+	}
+	for _, prefix := range assertionTitlePrefixes {
+		if strings.HasPrefix(activity.Title, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// crashMarkers identify the (lowercased) failure messages reported for a test
+// whose process crashed
+var crashMarkers = []string{
+	"crash:",
+	"crashed",
+	"unexpected exit",
+	"terminated due to signal",
+}
+
+// crashedMessage is the error message of the tests whose process crashed
+const crashedMessage = "Test crashed"
+
+// isCrashed reports whether the process of the test crashed: the test failed
+// with a crash reported among its issues (e.g. "Crash: MyApp at …" or "Test
+// crashed with signal segv"), or it has none of the results of a finished
+// test, e.g. "unknown"
+func isCrashed(node TestNode) bool {
+	switch node.Result {
+	case "", "Passed", "Skipped", expectedFailureResult:
+		return false
+	case "Failed":
+	default:
+		return true
+	}
+
+	for _, detail := range extractFailureDetails(node) {
+		lower := strings.ToLower(detail)
+		for _, marker := range crashMarkers {
+			if strings.Contains(lower, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errorMessageMarkers identify the (lowercased) failure messages reported for
+// a test throwing an unexpected error or exception
+var errorMessageMarkers = []string{
+	"caught error",
+	"uncaught exception",
+}
+
+// isErrorMessage reports whether a failure message describes an unexpected
+// error rather than a failed assertion
+func isErrorMessage(message string) bool {
+	lower := strings.ToLower(message)
+	for _, marker := range errorMessageMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractSkipMessage returns the reason of a skipped test, reported as a
+// message child of the test case node
+func extractSkipMessage(node TestNode) string {
+	for _, child := range node.Children {
+		if classifyIssue(child) != severityNone {
+			return child.Name
+		}
+	}
+	return ""
+}
+
+// extractFailureDetails returns every distinct failure message of the test
+// case (including its repetitions) in the order of their first occurrence,
+// each prefixed with its file:line location when the xcresult reports it.
+// The failures of a parameterized Swift Testing test are also prefixed with
+// the arguments they failed with.
+func extractFailureDetails(node TestNode) []string {
+	var details []string
+	seen := map[string]bool{}
+	var walk func(TestNode, string)
+	walk = func(node TestNode, arguments string) {
+		for _, child := range node.Children {
+			if classifyIssue(child) != severityError {
+				if child.NodeType == "Arguments" {
+					walk(child, "["+child.Name+"] ")
+				} else {
+					walk(child, arguments)
+				}
+				continue
+			}
+			if detail := arguments + withSourceLocation(child); !seen[detail] {
+				seen[detail] = true
+				details = append(details, detail)
+			}
+		}
+	}
+	walk(node, "")
+	return details
+}
+
+// withSourceLocation prefixes the issue message with its file:line:column
+// location, unless the message already starts with its file:line
+func withSourceLocation(issue TestNode) string {
+	loc := issue.SourceLocation
+	if loc == nil || loc.FilePath == "" {
+		return issue.Name
+	}
+
+	location := loc.FilePath
+	if loc.LineNumber > 0 {
+		location += ":" + strconv.Itoa(loc.LineNumber)
+	}
+	if strings.HasPrefix(issue.Name, location) || strings.HasPrefix(issue.Name, filepath.Base(location)) {
+		return issue.Name
+	}
+	if loc.LineNumber > 0 && loc.ColumnNumber > 0 {
+		location += ":" + strconv.Itoa(loc.ColumnNumber)
+	}
+	return location + ": " + issue.Name
+}
+
+// messageLocationPattern matches the file:line (or file:line:column)
+// location XCTest starts its failure messages with
+var messageLocationPattern = regexp.MustCompile(`^([^:\n]+\.[A-Za-z]+):(\d+)(?::(\d+))?: `)
+
+// issueLocation returns the source location of the issue, reported by the
+// xcresult or else found at the start of its message
+func issueLocation(issue TestNode) SourceLocation {
+	if issue.SourceLocation != nil && issue.SourceLocation.FilePath != "" {
+		return *issue.SourceLocation
+	}
+
+	match := messageLocationPattern.FindStringSubmatch(issue.Name)
+	if match == nil {
+		return SourceLocation{}
+	}
+	loc := SourceLocation{FilePath: match[1]}
+	loc.LineNumber, _ = strconv.Atoi(match[2])
+	loc.ColumnNumber, _ = strconv.Atoi(match[3])
+	return loc
+}
+
+// defaultFailureMessage is used for failed tests which report no failure message
+const defaultFailureMessage = "Test failed"
+
+// extractFailureMessage returns the first failure message of the node. The
+// failure messages of the node itself take precedence over the ones nested
+// deeper (e.g. under repetitions or devices). The returned bool is false if
+// the node has no failure message at all.
+func extractFailureMessage(node TestNode) (string, bool) {
+	issue, ok := firstFailureIssue(node)
+	return issue.Name, ok
+}
+
+// firstFailureIssue returns the issue node of the first failure message of
+// the node, see extractFailureMessage
+func firstFailureIssue(node TestNode) (TestNode, bool) {
+	for _, child := range node.Children {
+		if classifyIssue(child) == severityError {
+			return child, true
+		}
+	}
+
+	// Check deeper children
+	for _, child := range node.Children {
+		if issue, ok := firstFailureIssue(child); ok {
+			return issue, true
+		}
+	}
+	return TestNode{}, false
+}
+
+// failureMessageOrDefault returns the first failure message of the node or the
+// default message if it has none
+func failureMessageOrDefault(node TestNode) string {
+	if message, ok := extractFailureMessage(node); ok {
+		return message
+	}
+	return defaultFailureMessage
+}
+
+func buildClassName(current, newPart string) string {
+	if current == "" {
+		return newPart
+	}
+	return current + "." + newPart
+}
+
+func totalSuiteTime(cases []JUnitTestCase) float64 {
+	var total float64
+	for _, tc := range cases {
+		total += tc.Time
+	}
+	return total
+}
+
+// truncateTestCases keeps at most max test cases. Failed, errored and skipped cases are
+// kept first, passing ones fill up the remaining budget. The suite counts keep
+// describing the whole run and each truncated suite gets a note about the
+// omitted cases per status appended to its system-out. Returns the number of
+// omitted test cases.
+func truncateTestCases(suites *JUnitTestSuites, max int) int {
+	var all []*JUnitTestSuite
+	walkSuites(suites.TestSuites, func(suite *JUnitTestSuite) {
+		all = append(all, suite)
+	})
+
+	total := 0
+	for _, suite := range all {
+		total += len(suite.TestCases)
+	}
+	if total <= max {
+		return 0
+	}
+
+	keep := make([][]bool, len(all))
+	budget := max
+	for i, suite := range all {
+		keep[i] = make([]bool, len(suite.TestCases))
+		for j, tc := range suite.TestCases {
+			if budget > 0 && !isPassing(tc) {
+				keep[i][j] = true
+				budget--
+			}
+		}
+	}
+	for i, suite := range all {
+		for j, tc := range suite.TestCases {
+			if budget > 0 && isPassing(tc) {
+				keep[i][j] = true
+				budget--
+			}
+		}
+	}
+
+	for i, suite := range all {
+		var kept []JUnitTestCase
+		omitted := map[string]int{}
+		for j, tc := range suite.TestCases {
+			if keep[i][j] {
+				kept = append(kept, tc)
+				continue
+			}
+			status, _ := testCaseStatus(tc)
+			omitted[status]++
+		}
+
+		if len(omitted) > 0 {
+			note := fmt.Sprintf("%s test case(s) omitted, the report is limited to %d test cases", omittedCounts(omitted), max)
+			if suite.SystemOut != "" {
+				note = suite.SystemOut + "\n" + note
+			}
+			suite.SystemOut = note
+		}
+		suite.TestCases = kept
+	}
+
+	return total - max
+}
+
+// omittedCounts describes the number of omitted test cases per status, e.g. "3 passed, 1 failed"
+func omittedCounts(omitted map[string]int) string {
+	var parts []string
+	for _, status := range []string{"failed", "error", "skipped", "passed"} {
+		if count := omitted[status]; count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, status))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func isPassing(tc JUnitTestCase) bool {
+	return tc.Failure == nil && tc.Error == nil && tc.Skipped == nil
+}
+
+// relativizeSourcePaths rewrites the absolute source file paths under root
+// found in the failures and notes of the test cases to be relative to root
+func relativizeSourcePaths(suites *JUnitTestSuites, root string) {
+	prefix := strings.TrimSuffix(filepath.Clean(root), string(filepath.Separator)) + string(filepath.Separator)
+	relativize := func(text string) string {
+		return strings.ReplaceAll(text, prefix, "")
+	}
+
+	walkSuites(suites.TestSuites, func(suite *JUnitTestSuite) {
+		for i := range suite.TestCases {
+			tc := &suite.TestCases[i]
+			tc.SystemOut = cdata(relativize(string(tc.SystemOut)))
+			tc.SystemErr = cdata(relativize(string(tc.SystemErr)))
+			if tc.Failure != nil {
+				tc.Failure.Message = relativize(tc.Failure.Message)
+				tc.Failure.Content = relativize(tc.Failure.Content)
+			}
+			if tc.Error != nil {
+				tc.Error.Message = relativize(tc.Error.Message)
+				tc.Error.Content = relativize(tc.Error.Content)
+			}
+			for j := range tc.FlakyFailures {
+				flaky := &tc.FlakyFailures[j]
+				flaky.Message = relativize(flaky.Message)
+				flaky.Content = relativize(flaky.Content)
+			}
+			tc.File = relativize(tc.File)
+		}
+	})
+}
+
+// sanitizeTestSuites removes the characters illegal in XML 1.0 from the names,
+// messages and outputs of the test suites
+func sanitizeTestSuites(suites *JUnitTestSuites) {
+	walkSuites(suites.TestSuites, func(suite *JUnitTestSuite) {
+		suite.Name = sanitizeXMLText(suite.Name)
+		suite.SystemOut = sanitizeXMLText(suite.SystemOut)
+		sanitizeProperties(suite.Properties)
+		for i := range suite.TestCases {
+			tc := &suite.TestCases[i]
+			tc.Name = sanitizeXMLText(tc.Name)
+			tc.Classname = sanitizeXMLText(tc.Classname)
+			sanitizeProperties(tc.Properties)
+			tc.SystemOut = cdata(sanitizeXMLText(string(tc.SystemOut)))
+			tc.SystemErr = cdata(sanitizeXMLText(string(tc.SystemErr)))
+			if tc.Failure != nil {
+				tc.Failure.Message = sanitizeXMLText(tc.Failure.Message)
+				tc.Failure.Content = sanitizeXMLText(tc.Failure.Content)
+			}
+			if tc.Error != nil {
+				tc.Error.Message = sanitizeXMLText(tc.Error.Message)
+				tc.Error.Content = sanitizeXMLText(tc.Error.Content)
+			}
+			if tc.Skipped != nil {
+				tc.Skipped.Message = sanitizeXMLText(tc.Skipped.Message)
+			}
+			for j := range tc.FlakyFailures {
+				flaky := &tc.FlakyFailures[j]
+				flaky.Message = sanitizeXMLText(flaky.Message)
+				flaky.Content = sanitizeXMLText(flaky.Content)
+			}
+		}
+	})
+}
+
+// sanitizeProperties removes the characters illegal in XML 1.0 from the
+// properties, e.g. from the device and bundle names
+func sanitizeProperties(properties *JUnitProperties) {
+	if properties == nil {
+		return
+	}
+	for i := range properties.Properties {
+		property := &properties.Properties[i]
+		property.Name = sanitizeXMLText(property.Name)
+		property.Value = sanitizeXMLText(property.Value)
+	}
+}
+
+// sanitizeXMLText drops the characters illegal in XML 1.0 (e.g. NUL and other
+// control characters) and replaces invalid UTF-8 with U+FFFD
+func sanitizeXMLText(text string) string {
+	isLegal := func(r rune) bool {
+		return r == '\t' || r == '\n' || r == '\r' ||
+			r >= 0x20 && r <= 0xD7FF ||
+			r >= 0xE000 && r <= 0xFFFD ||
+			r >= 0x10000 && r <= 0x10FFFF
+	}
+
+	clean := utf8.ValidString(text)
+	for _, r := range text {
+		if !isLegal(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return text
+	}
+
+	// Ranging over a string yields U+FFFD for every invalid UTF-8 byte
+	var sanitized strings.Builder
+	for _, r := range text {
+		if isLegal(r) {
+			sanitized.WriteRune(r)
+		}
+	}
+	return sanitized.String()
+}
+
+// applyReportPortalCompat adjusts the test cases for the ReportPortal JUnit importer:
+//   - an empty classname is set to the name of the containing suite
+//   - a skipped element without a reason gets the "Skipped" message
+//   - an empty failure message is set to "Test failed", an empty failure body to the message
+//   - an empty error message is set to "Test errored", an empty error body to the message
+func applyReportPortalCompat(suites *JUnitTestSuites) {
+	walkSuites(suites.TestSuites, func(suite *JUnitTestSuite) {
+		for i := range suite.TestCases {
+			tc := &suite.TestCases[i]
+			if tc.Classname == "" {
+				tc.Classname = suite.Name
+			}
+			if tc.Skipped != nil && tc.Skipped.Message == "" {
+				tc.Skipped.Message = "Skipped"
+			}
+			if tc.Failure != nil {
+				if tc.Failure.Message == "" {
+					tc.Failure.Message = defaultFailureMessage
+				}
+				if tc.Failure.Content == "" {
+					tc.Failure.Content = tc.Failure.Message
+				}
+			}
+			if tc.Error != nil {
+				if tc.Error.Message == "" {
+					tc.Error.Message = "Test errored"
+				}
+				if tc.Error.Content == "" {
+					tc.Error.Content = tc.Error.Message
+				}
+			}
+		}
+	})
+}
+
+// collapseSingletonSuites moves the test cases of the top level suites holding
+// a single test case into one shared suite. The test cases keep their names
+// and classnames. Nothing changes if there is only one singleton suite.
+func collapseSingletonSuites(suites *JUnitTestSuites, name string) {
+	var kept, singletons []JUnitTestSuite
+	for _, suite := range suites.TestSuites {
+		if len(suite.TestCases) == 1 && len(suite.TestSuites) == 0 {
+			singletons = append(singletons, suite)
+		} else {
+			kept = append(kept, suite)
+		}
+	}
+	if len(singletons) < 2 {
+		return
+	}
+
+	collapsed := JUnitTestSuite{
+		Name:      name,
+		Timestamp: singletons[0].Timestamp,
+		TestCases: []JUnitTestCase{},
+	}
+	for _, suite := range singletons {
+		collapsed.TestCases = append(collapsed.TestCases, suite.TestCases[0])
+	}
+	rollupSuite(&collapsed)
+
+	suites.TestSuites = append(kept, collapsed)
+}
+
+// filterSuites returns the suites holding only the test cases matching keep,
+// suites left without any test case are dropped. Counts are recomputed.
+func filterSuites(suites []JUnitTestSuite, keep func(JUnitTestCase) bool) []JUnitTestSuite {
+	var filtered []JUnitTestSuite
+	for _, suite := range suites {
+		var cases []JUnitTestCase
+		for _, tc := range suite.TestCases {
+			if keep(tc) {
+				cases = append(cases, tc)
+			}
+		}
+		nested := filterSuites(suite.TestSuites, keep)
+		if len(cases) == 0 && len(nested) == 0 {
+			continue
+		}
+
+		suite.TestCases = cases
+		suite.TestSuites = nested
+		rollupSuite(&suite)
+		filtered = append(filtered, suite)
+	}
+	return filtered
+}
+
+// flattenSuites merges the test cases of all the suites, nested suites
+// included, into one suite. The test cases keep their classnames, the suite
+// time is the total time of the suites.
+func flattenSuites(suites *JUnitTestSuites, name string) {
+	if len(suites.TestSuites) == 0 {
+		return
+	}
+
+	flattened := JUnitTestSuite{
+		Name:      name,
+		Timestamp: suites.TestSuites[0].Timestamp,
+		TestCases: []JUnitTestCase{},
+	}
+	var totalTime float64
+	for _, suite := range suites.TestSuites {
+		totalTime += suite.Time
+	}
+	walkSuites(suites.TestSuites, func(suite *JUnitTestSuite) {
+		flattened.TestCases = append(flattened.TestCases, suite.TestCases...)
+		for _, bundle := range suite.bundles {
+			flattened.addBundle(bundle)
+		}
+	})
+	rollupSuite(&flattened)
+	flattened.Time = totalTime
+
+	suites.TestSuites = []JUnitTestSuite{flattened}
+}
+
+// rollupSuite computes the counts and time of a suite from its own test cases
+// and the totals of its nested suites
+func rollupSuite(suite *JUnitTestSuite) {
+	suite.Tests = len(suite.TestCases)
+	suite.Failures = 0
+	suite.Errors = 0
+	suite.Skipped = 0
+	for _, tc := range suite.TestCases {
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Error != nil {
+			suite.Errors++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+	}
+	suite.Time = totalSuiteTime(suite.TestCases)
+
+	for i := range suite.TestSuites {
+		child := &suite.TestSuites[i]
+		rollupSuite(child)
+		suite.Tests += child.Tests
+		suite.Failures += child.Failures
+		suite.Errors += child.Errors
+		suite.Skipped += child.Skipped
+		suite.Time += child.Time
+	}
+}
+
+// useReportedSuiteTimes sets the time of the suites to the duration reported by
+// the xcresult. Suites without a reported duration keep the sum of their test
+// cases and nested suites.
+func useReportedSuiteTimes(suites []JUnitTestSuite) {
+	for i := range suites {
+		suite := &suites[i]
+		useReportedSuiteTimes(suite.TestSuites)
+
+		if suite.reportedTime > 0 {
+			suite.Time = suite.reportedTime
+			continue
+		}
+
+		suite.Time = totalSuiteTime(suite.TestCases)
+		for _, child := range suite.TestSuites {
+			suite.Time += child.Time
+		}
+	}
+}
+
+// walkSuites calls fn for every suite, nested suites included, depth first
+func walkSuites(suites []JUnitTestSuite, fn func(*JUnitTestSuite)) {
+	for i := range suites {
+		fn(&suites[i])
+		walkSuites(suites[i].TestSuites, fn)
+	}
+}
+
+func sortTestSuites(suites *JUnitTestSuites) {
+	sortSuites(suites.TestSuites)
+}
+
+func sortSuites(suites []JUnitTestSuite) {
+	// Sort test cases and nested suites within each suite first, the suites
+	// with the same name are ordered by their first test case
+	for i := range suites {
+		cases := suites[i].TestCases
+		sort.SliceStable(cases, func(a, b int) bool {
+			if cases[a].Name != cases[b].Name {
+				return cases[a].Name < cases[b].Name
+			}
+			return cases[a].Classname < cases[b].Classname
+		})
+		sortSuites(suites[i].TestSuites)
+	}
+
+	// Sort test suites, stable so the output is reproducible
+	sort.SliceStable(suites, func(i, j int) bool {
+		return suiteLess(suites[i], suites[j])
+	})
+}
+
+// suiteLess orders suites by name, then timestamp, then first test case
+func suiteLess(a, b JUnitTestSuite) bool {
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return firstTestCaseName(a) < firstTestCaseName(b)
+}
+
+// firstTestCaseName returns the name of the first test case of the suite or
+// of its nested suites
+func firstTestCaseName(suite JUnitTestSuite) string {
+	if len(suite.TestCases) > 0 {
+		return suite.TestCases[0].Classname + "." + suite.TestCases[0].Name
+	}
+	for _, nested := range suite.TestSuites {
+		if name := firstTestCaseName(nested); name != "" {
+			return name
+		}
 	}
+	return ""
 }
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
 )
 
 func TestProcessXCResultJSON(t *testing.T) {
@@ -195,3 +207,3151 @@ func TestHelperFunctions(t *testing.T) {
 		}
 	})
 }
+
+func TestIssueSeverityClassification(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "swift_testing_mixed_severity.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+	}
+
+	var testSuites JUnitTestSuites
+	if err := xml.Unmarshal(xmlData, &testSuites); err != nil {
+		t.Fatalf("Failed to unmarshal generated XML: %v", err)
+	}
+
+	if len(testSuites.TestSuites) != 1 {
+		t.Fatalf("Expected 1 test suite, got %d", len(testSuites.TestSuites))
+	}
+
+	suite := testSuites.TestSuites[0]
+	if suite.Failures != 1 {
+		t.Errorf("Expected 1 failure, got %d", suite.Failures)
+	}
+
+	cases := make(map[string]JUnitTestCase)
+	for _, tc := range suite.TestCases {
+		cases[tc.Name] = tc
+	}
+
+	t.Run("warning only test passes with a note", func(t *testing.T) {
+		tc := cases["parsesEmptyInput()"]
+		if tc.Failure != nil {
+			t.Errorf("Expected no failure, got %v", tc.Failure)
+		}
+		if tc.SystemOut != "warning: ParserTests.swift:12: Fixture uses a deprecated format" {
+			t.Errorf("Unexpected system-out: %q", tc.SystemOut)
+		}
+	})
+
+	t.Run("error issue fails the test, warning is kept as a note", func(t *testing.T) {
+		tc := cases["rejectsGarbage()"]
+		if tc.Failure == nil {
+			t.Fatalf("Expected failure to be set, got nil")
+		}
+		if tc.Failure.Message != "ParserTests.swift:24: Expectation failed: result == nil" {
+			t.Errorf("Unexpected failure message: %q", tc.Failure.Message)
+		}
+		if tc.SystemOut != "warning: ParserTests.swift:20: Input was slow to parse" {
+			t.Errorf("Unexpected system-out: %q", tc.SystemOut)
+		}
+	})
+
+	t.Run("runtime warning is a note", func(t *testing.T) {
+		tc := cases["roundTrips()"]
+		if tc.Failure != nil {
+			t.Errorf("Expected no failure, got %v", tc.Failure)
+		}
+		if !strings.Contains(string(tc.SystemOut), "Main Thread Checker") {
+			t.Errorf("Expected runtime warning in system-out, got %q", tc.SystemOut)
+		}
+	})
+}
+
+func TestSwiftTestingResults(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "swift_testing_tags.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+	}
+
+	var testSuites JUnitTestSuites
+	if err := xml.Unmarshal(xmlData, &testSuites); err != nil {
+		t.Fatalf("Failed to unmarshal generated XML: %v", err)
+	}
+
+	suites := testSuites.TestSuites
+	if len(suites) != 2 || suites[0].Name != "CheckoutSuite" || suites[1].Name != "launches()" {
+		t.Fatalf("Expected suites CheckoutSuite and launches(), got %+v", suites)
+	}
+	if suites[0].Tests != 2 || suites[0].Failures != 1 {
+		t.Errorf("Expected CheckoutSuite with 2 tests and 1 failure, got %d tests and %d failures", suites[0].Tests, suites[0].Failures)
+	}
+
+	t.Run("tags become properties", func(t *testing.T) {
+		tc := suites[0].TestCases[0]
+		expected := []JUnitProperty{{Name: "tag", Value: "checkout"}, {Name: "tag", Value: "critical"}}
+		if tc.Properties == nil || !reflect.DeepEqual(tc.Properties.Properties, expected) {
+			t.Errorf("Expected properties %v, got %+v", expected, tc.Properties)
+		}
+		if untagged := suites[0].TestCases[1]; untagged.Properties != nil {
+			t.Errorf("Expected no properties for %s, got %+v", untagged.Name, untagged.Properties)
+		}
+		if free := suites[1].TestCases[0]; free.Properties == nil || free.Properties.Properties[0].Value != "smoke" {
+			t.Errorf("Expected the smoke tag for launches(), got %+v", free.Properties)
+		}
+	})
+
+	t.Run("tags omitted", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{OmitTags: true})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if tc := testSuites.TestSuites[0].TestCases[0]; tc.Properties != nil {
+			t.Errorf("Expected no properties, got %+v", tc.Properties)
+		}
+	})
+
+	t.Run("failed arguments", func(t *testing.T) {
+		failure := suites[0].TestCases[0].Failure
+		if failure == nil {
+			t.Fatalf("Expected appliesDiscount(code:) to fail")
+		}
+		expected := `["EXPIRED"] CheckoutTests.swift:18: Expectation failed: (total → 100) == 90`
+		if failure.Content != expected {
+			t.Errorf("Expected content %q, got %q", expected, failure.Content)
+		}
+	})
+}
+
+func TestGoldenReport(t *testing.T) {
+	// test_results.json is the output of `xcresulttool get test-results tests`
+	// for a test plan with a unit and a UI test bundle
+	for _, fixture := range []string{"swift_testing_tags", "test_results"} {
+		t.Run(fixture, func(t *testing.T) {
+			jsonData, err := os.ReadFile(filepath.Join("testdata", fixture+".json"))
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+			expected, err := os.ReadFile(filepath.Join("testdata", fixture+".junit.xml"))
+			if err != nil {
+				t.Fatalf("Failed to read golden file: %v", err)
+			}
+
+			// The suite map is iterated in random order, every conversion must still
+			// produce the same bytes
+			opts := ConvertOptions{StartTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+			for i := 0; i < 5; i++ {
+				xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, opts)
+				if err != nil {
+					t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+				}
+				if string(xmlData) != string(expected) {
+					t.Fatalf("Expected the golden report, got:\n%s", xmlData)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncateTestCases(t *testing.T) {
+	failure := &JUnitFailure{Message: "failed", Type: "Failure"}
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name:     "LoginTests",
+				Tests:    3,
+				Failures: 1,
+				TestCases: []JUnitTestCase{
+					{Name: "testA"},
+					{Name: "testB"},
+					{Name: "testC", Failure: failure},
+				},
+			},
+			{
+				Name:      "SignupTests",
+				Tests:     2,
+				SystemOut: "Signup log",
+				TestCases: []JUnitTestCase{
+					{Name: "testD", Skipped: &JUnitSkipped{}},
+					{Name: "testE"},
+				},
+			},
+		},
+	}
+
+	omitted := truncateTestCases(&testSuites, 3)
+	if omitted != 2 {
+		t.Errorf("Expected 2 omitted test cases, got %d", omitted)
+	}
+
+	login := testSuites.TestSuites[0]
+	if len(login.TestCases) != 2 || login.TestCases[0].Name != "testA" || login.TestCases[1].Name != "testC" {
+		t.Errorf("Expected testA and testC to be kept, got %v", login.TestCases)
+	}
+	if login.Tests != 3 || login.Failures != 1 {
+		t.Errorf("Expected suite counts to be preserved, got tests=%d failures=%d", login.Tests, login.Failures)
+	}
+	if expected := "1 passed test case(s) omitted, the report is limited to 3 test cases"; login.SystemOut != expected {
+		t.Errorf("Expected note %q, got %q", expected, login.SystemOut)
+	}
+
+	signup := testSuites.TestSuites[1]
+	if len(signup.TestCases) != 1 || signup.TestCases[0].Name != "testD" {
+		t.Errorf("Expected only the skipped testD to be kept, got %v", signup.TestCases)
+	}
+	if signup.Tests != 2 {
+		t.Errorf("Expected suite test count to be preserved, got %d", signup.Tests)
+	}
+	if expected := "Signup log\n1 passed test case(s) omitted, the report is limited to 3 test cases"; signup.SystemOut != expected {
+		t.Errorf("Expected note %q, got %q", expected, signup.SystemOut)
+	}
+
+	t.Run("failures exceed the limit", func(t *testing.T) {
+		testSuites := JUnitTestSuites{
+			TestSuites: []JUnitTestSuite{
+				{
+					Name: "LoginTests",
+					TestCases: []JUnitTestCase{
+						{Name: "testA", Failure: failure},
+						{Name: "testB", Error: &JUnitError{Message: "crashed"}},
+						{Name: "testC", Failure: failure},
+						{Name: "testD"},
+					},
+				},
+			},
+		}
+
+		if omitted := truncateTestCases(&testSuites, 1); omitted != 3 {
+			t.Errorf("Expected 3 omitted test cases, got %d", omitted)
+		}
+
+		login := testSuites.TestSuites[0]
+		if len(login.TestCases) != 1 || login.TestCases[0].Name != "testA" {
+			t.Errorf("Expected only testA to be kept, got %v", login.TestCases)
+		}
+		if expected := "1 failed, 1 error, 1 passed test case(s) omitted, the report is limited to 1 test cases"; login.SystemOut != expected {
+			t.Errorf("Expected note %q, got %q", expected, login.SystemOut)
+		}
+	})
+}
+
+func TestPreserveHierarchyRollups(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "nested_suites.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	// The suite times are summed, the reported durations would replace them
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: true, SuiteTimeSource: SuiteTimeFromTestCases})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	if len(testSuites.TestSuites) != 1 {
+		t.Fatalf("Expected 1 top level suite, got %d", len(testSuites.TestSuites))
+	}
+
+	bundle := testSuites.TestSuites[0]
+	if bundle.Name != "AppTests" || len(bundle.TestSuites) != 1 {
+		t.Fatalf("Expected AppTests bundle with 1 nested suite, got %s with %d", bundle.Name, len(bundle.TestSuites))
+	}
+
+	login := bundle.TestSuites[0]
+	if login.Name != "LoginTests" || len(login.TestCases) != 1 || len(login.TestSuites) != 1 {
+		t.Fatalf("Unexpected LoginTests suite: %+v", login)
+	}
+
+	sso := login.TestSuites[0]
+	if sso.Tests != 2 || sso.Failures != 1 || sso.Time != 2.5 {
+		t.Errorf("Expected SSOTests totals tests=2 failures=1 time=2.5, got tests=%d failures=%d time=%f", sso.Tests, sso.Failures, sso.Time)
+	}
+	if sso.TestCases[0].Classname != "AppTests.LoginTests.SSOTests" {
+		t.Errorf("Unexpected classname: %s", sso.TestCases[0].Classname)
+	}
+
+	if login.Tests != 3 || login.Failures != 1 || login.Time != 3.5 {
+		t.Errorf("Expected LoginTests totals tests=3 failures=1 time=3.5, got tests=%d failures=%d time=%f", login.Tests, login.Failures, login.Time)
+	}
+	if bundle.Tests != login.Tests || bundle.Failures != login.Failures || bundle.Time != login.Time {
+		t.Errorf("Expected AppTests totals to equal the sum of its children, got tests=%d failures=%d time=%f", bundle.Tests, bundle.Failures, bundle.Time)
+	}
+}
+
+func TestIdentifierWithoutSuitePath(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "MyTest", "nodeType": "Test Case", "nodeIdentifier": "MyTest", "result": "Passed"},
+      {"name": "CartTests", "nodeType": "Test Suite", "children": [
+        {"name": "addsItem()", "nodeType": "Test Case", "nodeIdentifier": "", "result": "Failed"},
+        {"name": "removesItem()", "nodeType": "Test Case", "result": "Passed"}
+      ]}
+    ]}
+  ]
+}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	suites := testSuites.TestSuites
+	if len(suites) != 2 || suites[0].Name != "CartTests" || suites[1].Name != "MyTest" {
+		t.Fatalf("Expected suites CartTests and MyTest, got %+v", suites)
+	}
+	if suites[0].Tests != 2 || suites[0].Failures != 1 {
+		t.Errorf("Expected CartTests with 2 tests and 1 failure, got %d tests and %d failures", suites[0].Tests, suites[0].Failures)
+	}
+	if tc := suites[1].TestCases; len(tc) != 1 || tc[0].Name != "MyTest" {
+		t.Errorf("Expected the MyTest test case in the MyTest suite, got %+v", tc)
+	}
+
+	t.Run("hierarchy", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: true})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		bundle := testSuites.TestSuites[0]
+		if bundle.Tests != 3 || len(bundle.TestCases) != 1 {
+			t.Errorf("Expected AppTests with 3 tests, MyTest directly in the bundle, got %+v", bundle)
+		}
+	})
+}
+
+func TestNestedSwiftTestingSuites(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "nodeIdentifier": "LoginTests", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"},
+        {"name": "SSOTests", "nodeType": "Test Suite", "nodeIdentifier": "LoginTests/SSOTests", "children": [
+          {"name": "testGoogle()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/SSOTests/testGoogle()", "result": "Failed"},
+          {"name": "Sign in with Apple", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/SSOTests/testApple()", "result": "Passed"}
+        ]}
+      ]}
+    ]}
+  ]
+}`)
+
+	t.Run("flat", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+
+		suites := testSuites.TestSuites
+		if len(suites) != 2 || suites[0].Name != "LoginTests" || suites[1].Name != "LoginTests.SSOTests" {
+			t.Fatalf("Expected suites LoginTests and LoginTests.SSOTests, got %+v", suites)
+		}
+		if suites[1].Tests != 2 || suites[1].Failures != 1 {
+			t.Errorf("Expected LoginTests.SSOTests with 2 tests and 1 failure, got %d tests and %d failures", suites[1].Tests, suites[1].Failures)
+		}
+		for _, tc := range suites[1].TestCases {
+			if tc.Classname != "AppTests.LoginTests.SSOTests" {
+				t.Errorf("Expected classname AppTests.LoginTests.SSOTests for %s, got %s", tc.Name, tc.Classname)
+			}
+		}
+	})
+
+	t.Run("hierarchy", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: true})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+
+		login := testSuites.TestSuites[0].TestSuites[0]
+		if login.Name != "LoginTests" || len(login.TestCases) != 1 || len(login.TestSuites) != 1 {
+			t.Fatalf("Expected LoginTests with 1 test case and 1 nested suite, got %+v", login)
+		}
+		if classname := login.TestCases[0].Classname; classname != "AppTests.LoginTests" {
+			t.Errorf("Expected classname AppTests.LoginTests, got %s", classname)
+		}
+		sso := login.TestSuites[0]
+		if sso.Name != "SSOTests" || sso.Tests != 2 || sso.TestCases[0].Classname != "AppTests.LoginTests.SSOTests" {
+			t.Errorf("Expected SSOTests with 2 tests of classname AppTests.LoginTests.SSOTests, got %+v", sso)
+		}
+		if login.Tests != 3 || login.Failures != 1 {
+			t.Errorf("Expected LoginTests with 3 tests and 1 failure, got %d tests and %d failures", login.Tests, login.Failures)
+		}
+	})
+}
+
+func TestExpectedFailures(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "LoginTests", "nodeType": "Test Suite", "children": [
+  {"name": "testKnownBug()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testKnownBug()", "result": "Expected Failure", "children": [
+    {"name": "LoginTests.swift:12: Expected failure: FB1234: XCTAssertEqual failed", "nodeType": "Failure Message"}
+  ]},
+  {"name": "testFixedBug()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testFixedBug()", "result": "Failed", "children": [
+    {"name": "LoginTests.swift:20: Expected failure was not recorded", "nodeType": "Failure Message"}
+  ]}
+]}]}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+	suite := testSuites.TestSuites[0]
+	if suite.Tests != 2 || suite.Failures != 1 || suite.Skipped != 0 {
+		t.Errorf("Expected 2 tests with 1 failure, got %d tests, %d failures and %d skipped", suite.Tests, suite.Failures, suite.Skipped)
+	}
+
+	t.Run("expected failure passes", func(t *testing.T) {
+		tc := suite.TestCases[1]
+		if tc.Name != "testKnownBug()" || tc.Failure != nil || tc.Error != nil || tc.Skipped != nil {
+			t.Fatalf("Expected testKnownBug() to pass, got %+v", tc)
+		}
+		expected := &JUnitProperties{Properties: []JUnitProperty{{Name: "expectedFailure", Value: "true"}}}
+		if !reflect.DeepEqual(tc.Properties, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, tc.Properties)
+		}
+		if !strings.Contains(string(tc.SystemOut), "Expected failure: LoginTests.swift:12: Expected failure: FB1234") {
+			t.Errorf("Expected a note with the expected failure, got %q", tc.SystemOut)
+		}
+
+		xmlData, err := MarshalJUnitXML(testSuites)
+		if err != nil {
+			t.Fatalf("MarshalJUnitXML returned error: %v", err)
+		}
+		if !strings.Contains(string(xmlData), `<property name="expectedFailure" value="true"></property>`) {
+			t.Errorf("Expected the expectedFailure property in the XML, got:\n%s", xmlData)
+		}
+	})
+
+	t.Run("unexpected pass fails", func(t *testing.T) {
+		tc := suite.TestCases[0]
+		if tc.Name != "testFixedBug()" || tc.Failure == nil {
+			t.Fatalf("Expected testFixedBug() to fail, got %+v", tc)
+		}
+		if tc.Properties != nil {
+			t.Errorf("Expected no properties, got %+v", tc.Properties)
+		}
+	})
+
+	t.Run("legacy format", func(t *testing.T) {
+		testSuites, err := ConvertLegacyXCResultJSONToTestSuites([]byte(`{"testPlanSummaries": [{"summaries": [{"testableSummaries": [
+  {"name": "AppTests", "tests": [{"name": "LoginTests", "subtests": [
+    {"name": "testKnownBug()", "testStatus": "Expected Failure", "expectedFailures": [
+      {"failureReason": "FB1234", "failureSummary": {"message": "XCTAssertEqual failed"}}
+    ]}
+  ]}]}
+]}]}]}`), ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertLegacyXCResultJSONToTestSuites returned error: %v", err)
+		}
+		tc := testSuites.TestSuites[0].TestCases[0]
+		if tc.Failure != nil || tc.Properties == nil {
+			t.Errorf("Expected a passing test marked as expected failure, got %+v", tc)
+		}
+		if string(tc.SystemOut) != "Expected failure: FB1234: XCTAssertEqual failed" {
+			t.Errorf("Expected the failure reason in the note, got %q", tc.SystemOut)
+		}
+	})
+}
+
+func TestAssertionCount(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "CheckoutTests", "nodeType": "Test Suite", "children": [
+  {"name": "testCheckout()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testCheckout()", "result": "Failed",
+   "activitySummaries": {"_values": [
+     {"activitySummary": {"title": "Open the cart"}},
+     {"activitySummary": {"title": "XCTAssertEqual failed: (\"1\") is not equal to (\"2\")"}},
+     {"activitySummary": {"title": "Total is shown", "activityType": "com.apple.dt.xctest.activity-type.testAssertionFailure"}},
+     {"activitySummary": {"title": "#expect(total > 0)"}}
+   ]}},
+  {"name": "testEmptyCart()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testEmptyCart()", "result": "Passed",
+   "activitySummaries": {"_values": [{"activitySummary": {"title": "Open the cart"}}]}}
+]}]}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+	cases := testSuites.TestSuites[0].TestCases
+	if cases[0].Assertions != 3 {
+		t.Errorf("Expected 3 assertions for testCheckout(), got %d", cases[0].Assertions)
+	}
+	if cases[1].Assertions != 0 {
+		t.Errorf("Expected no assertions for testEmptyCart(), got %d", cases[1].Assertions)
+	}
+
+	xmlData, err := MarshalJUnitXML(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalJUnitXML returned error: %v", err)
+	}
+	if !strings.Contains(string(xmlData), `<testcase name="testCheckout()" classname="CheckoutTests" time="0" assertions="3">`) {
+		t.Errorf("Expected the assertions attribute on testCheckout(), got:\n%s", xmlData)
+	}
+	if !strings.Contains(string(xmlData), `<testcase name="testEmptyCart()" classname="CheckoutTests" time="0">`) {
+		t.Errorf("Expected no assertions attribute on testEmptyCart(), got:\n%s", xmlData)
+	}
+}
+
+func TestMaxFailureLength(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "DiffTests", "nodeType": "Test Suite", "children": [
+  {"name": "testSnapshot()", "nodeType": "Test Case", "nodeIdentifier": "DiffTests/testSnapshot()", "result": "Failed", "children": [
+    {"name": "DiffTests.swift:8: XCTAssertEqual failed\n- expected\n+ actual", "nodeType": "Failure Message"}
+  ]},
+  {"name": "testTitle()", "nodeType": "Test Case", "nodeIdentifier": "DiffTests/testTitle()", "result": "Failed", "children": [
+    {"name": "DiffTests.swift:20: failed", "nodeType": "Failure Message"}
+  ]}
+]}]}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{MaxFailureLength: 40})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+	cases := testSuites.TestSuites[0].TestCases
+
+	truncated := cases[0].Failure
+	if expected := "DiffTests.swift:8: XCTAssertEqual failed" + truncatedMarker; truncated.Content != expected {
+		t.Errorf("Expected content %q, got %q", expected, truncated.Content)
+	}
+	if expected := "DiffTests.swift:8: XCTAssertEqual failed"; truncated.Message != expected {
+		t.Errorf("Expected message %q, got %q", expected, truncated.Message)
+	}
+
+	if short := cases[1].Failure; short.Content != "DiffTests.swift:20: failed" || short.Message != "DiffTests.swift:20: failed" {
+		t.Errorf("Expected the short failure to be kept, got %+v", short)
+	}
+
+	t.Run("characters", func(t *testing.T) {
+		if got, truncated := truncateText("héllo wörld", 7); got != "héllo w"+truncatedMarker || !truncated {
+			t.Errorf("Expected the first 7 characters, got %q", got)
+		}
+	})
+}
+
+func TestCrashedTests(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "CrashTests", "nodeType": "Test Suite", "children": [
+        {"name": "testCrash()", "nodeType": "Test Case", "nodeIdentifier": "CrashTests/testCrash()", "result": "Failed", "duration": "1s",
+         "children": [{"name": "Crash: MyApp (4242) at -[Parser parse:]", "nodeType": "Failure Message"}]},
+        {"name": "testSignal()", "nodeType": "Test Case", "nodeIdentifier": "CrashTests/testSignal()", "result": "Failed",
+         "children": [{"name": "Test crashed with signal segv.", "nodeType": "Failure Message"}]},
+        {"name": "testUnfinished()", "nodeType": "Test Case", "nodeIdentifier": "CrashTests/testUnfinished()", "result": "unknown", "duration": "--"},
+        {"name": "testAssertion()", "nodeType": "Test Case", "nodeIdentifier": "CrashTests/testAssertion()", "result": "Failed", "duration": "1s",
+         "children": [{"name": "XCTAssertEqual failed", "nodeType": "Failure Message"}]}
+      ]}
+    ]}
+  ]
+}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	suite := testSuites.TestSuites[0]
+	if suite.Errors != 3 || suite.Failures != 1 {
+		t.Errorf("Expected 3 errors and 1 failure, got %d errors and %d failures", suite.Errors, suite.Failures)
+	}
+
+	expected := map[string]string{
+		"testCrash()":      "Crash: MyApp (4242) at -[Parser parse:]",
+		"testSignal()":     "Test crashed with signal segv.",
+		"testUnfinished()": `The test didn't finish, its result is "unknown"`,
+	}
+	for _, tc := range suite.TestCases {
+		content, isCrash := expected[tc.Name]
+		if !isCrash {
+			if tc.Failure == nil || tc.Error != nil {
+				t.Errorf("Expected %s to fail, got %+v", tc.Name, tc)
+			}
+			continue
+		}
+		if tc.Error == nil || tc.Error.Message != crashedMessage || tc.Error.Type != "Crash" || tc.Failure != nil {
+			t.Errorf("Expected %s to crash, got %+v", tc.Name, tc)
+			continue
+		}
+		if tc.Error.Content != content {
+			t.Errorf("Expected %q for %s, got %q", content, tc.Name, tc.Error.Content)
+		}
+		if tc.Name == "testUnfinished()" && tc.Time != 0 {
+			t.Errorf("Expected no time for the garbled duration, got %f", tc.Time)
+		}
+	}
+}
+
+func TestFailureElement(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "CheckoutTests", "nodeType": "Test Suite", "children": [
+  {"name": "testAssertion()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testAssertion()", "result": "Failed",
+   "children": [{"name": "XCTAssertEqual failed", "nodeType": "Failure Message"}]},
+  {"name": "testException()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testException()", "result": "Failed",
+   "children": [{"name": "Uncaught exception: NSInvalidArgumentException", "nodeType": "Failure Message"}]},
+  {"name": "testCrash()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testCrash()", "result": "Failed",
+   "children": [{"name": "Crash: MyApp (4242) at -[Cart total]", "nodeType": "Failure Message"}]},
+  {"name": "testPass()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testPass()", "result": "Passed"}
+]}]}`)
+
+	tests := []struct {
+		element  string
+		failures int
+		errors   int
+	}{
+		{"", 1, 2},
+		{FailureElementFailure, 1, 2},
+		{FailureElementError, 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.element, func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{FailureElement: tt.element})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+			suite := testSuites.TestSuites[0]
+			if suite.Failures != tt.failures || suite.Errors != tt.errors {
+				t.Errorf("Expected %d failures and %d errors, got %d failures and %d errors", tt.failures, tt.errors, suite.Failures, suite.Errors)
+			}
+
+			cases := map[string]JUnitTestCase{}
+			for _, tc := range suite.TestCases {
+				cases[tc.Name] = tc
+			}
+			assertion := cases["testAssertion()"]
+			if tt.element == FailureElementError {
+				if assertion.Error == nil || assertion.Failure != nil || assertion.Error.Message != "XCTAssertEqual failed" {
+					t.Errorf("Expected the assertion failure as an error, got %+v", assertion)
+				}
+			} else if assertion.Failure == nil || assertion.Error != nil {
+				t.Errorf("Expected the assertion failure as a failure, got %+v", assertion)
+			}
+			if crash := cases["testCrash()"]; crash.Error == nil || crash.Error.Type != "Crash" {
+				t.Errorf("Expected the crash as an error, got %+v", crash)
+			}
+			if err := validateSuiteCounts(testSuites); err != nil {
+				t.Errorf("Expected consistent counts, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+  {"name": "LoginTests", "nodeType": "Test Suite", "duration": "1s", "children": [
+    {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed", "duration": "1 fortnight",
+     "children": [{"name": "login.png", "nodeType": "Hologram"}]},
+    {"name": "testLogout()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogout()", "result": "Passed", "duration": "1 fortnight"}
+  ]},
+  {"name": "Login flows", "nodeType": "Test Group", "children": [
+    {"name": "testSSO()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testSSO()", "result": "Passed"}
+  ]}
+]}]}`)
+
+	t.Run("warnings", func(t *testing.T) {
+		c := &converter{suiteMap: map[string]*JUnitTestSuite{}, suiteDurations: map[string]float64{}, caseIndex: map[string]int{}}
+		if _, err := c.decodeTestResults(jsonData, true); err != nil {
+			t.Fatalf("decodeTestResults returned error: %v", err)
+		}
+		expected := []string{
+			`unparseable duration "1 fortnight" of "testLogin()" [LoginTests/testLogin()]`,
+			`unknown node type "Hologram" of "login.png"`,
+			`unparseable duration "1 fortnight" of "testLogout()" [LoginTests/testLogout()]`,
+			`unknown node type "Test Group" of "Login flows", processed as a container of test cases`,
+		}
+		if !reflect.DeepEqual(c.warnings, expected) {
+			t.Errorf("Expected warnings %q, got %q", expected, c.warnings)
+		}
+	})
+
+	t.Run("not strict", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if total := computeTotals(testSuites); total.Tests != 3 {
+			t.Errorf("Expected the 3 tests despite the warnings, got %d", total.Tests)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		_, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{Strict: true})
+		if !errors.Is(err, errStrict) {
+			t.Fatalf("Expected a strict mode error, got %v", err)
+		}
+		if !strings.Contains(err.Error(), `unknown node type "Hologram"`) {
+			t.Errorf("Expected the error to list the warnings, got %v", err)
+		}
+	})
+
+	t.Run("well-formed results", func(t *testing.T) {
+		jsonData, err := os.ReadFile(filepath.Join("testdata", "test_results.json"))
+		if err != nil {
+			t.Fatalf("Failed to read fixture: %v", err)
+		}
+		if _, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{Strict: true}); err != nil {
+			t.Errorf("Expected no strict mode error, got %v", err)
+		}
+	})
+}
+
+func TestMaxNodeDepth(t *testing.T) {
+	// A corrupt bundle nesting suites 2000 levels deep, next to a sane suite
+	const levels = 2000
+	deepNodes := strings.Repeat(`{"name": "NestedTests", "nodeType": "Test Suite", "children": [`, levels) +
+		`{"name": "testDeep()", "nodeType": "Test Case", "nodeIdentifier": "NestedTests/testDeep()", "result": "Passed"}` +
+		strings.Repeat(`]}`, levels)
+	jsonData := []byte(`{"testNodes": [{"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+  {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+    {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"}
+  ]},
+  ` + deepNodes + `
+]}]}`)
+
+	for _, preserveHierarchy := range []bool{false, true} {
+		t.Run(map[bool]string{false: "flat", true: "hierarchy"}[preserveHierarchy], func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: preserveHierarchy})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+			if total := computeTotals(testSuites); total.Tests != 1 {
+				t.Errorf("Expected only the test above the max depth, got %d tests", total.Tests)
+			}
+
+			_, err = ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: preserveHierarchy, Strict: true})
+			if !errors.Is(err, errStrict) || !strings.Contains(err.Error(), "nested deeper than 100 levels") {
+				t.Errorf("Expected a warning about the dropped nodes, got %v", err)
+			}
+
+			testSuites, err = ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: preserveHierarchy, MaxNodeDepth: levels + 10})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+			if total := computeTotals(testSuites); total.Tests != 2 {
+				t.Errorf("Expected both tests with a higher max depth, got %d tests", total.Tests)
+			}
+		})
+	}
+
+	t.Run("legacy test groups", func(t *testing.T) {
+		deepGroups := strings.Repeat(`{"name": "NestedTests", "subtests": [`, levels) +
+			`{"name": "testDeep()", "identifier": "NestedTests/testDeep()", "testStatus": "Success"}` +
+			strings.Repeat(`]}`, levels)
+		jsonData := []byte(`{"testPlanSummaries": [{"summaries": [{"testableSummaries": [{"name": "AppTests", "tests": [
+  {"name": "LoginTests", "subtests": [{"name": "testLogin()", "identifier": "LoginTests/testLogin()", "testStatus": "Success"}]},
+  ` + deepGroups + `
+]}]}]}]}`)
+		testSuites, err := ConvertLegacyXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertLegacyXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if total := computeTotals(testSuites); total.Tests != 1 {
+			t.Errorf("Expected only the test above the max depth, got %d tests", total.Tests)
+		}
+
+		testSuites, err = ConvertLegacyXCResultJSONToTestSuites(jsonData, ConvertOptions{MaxNodeDepth: levels + 10})
+		if err != nil {
+			t.Fatalf("ConvertLegacyXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if total := computeTotals(testSuites); total.Tests != 2 {
+			t.Errorf("Expected both tests with a higher max depth, got %d tests", total.Tests)
+		}
+	})
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"0.5s", 0.5},
+		{"1,5s", 1.5},
+		{"1,2s", 1.2},
+		{"1,234.5s", 1234.5},
+		{"2", 2},
+		{"250ms", 0.25},
+		{"1m3s", 63},
+		{"1m 3,5s", 63.5},
+		{"1h2m", 3720},
+		{"", 0},
+		{"abc", 0},
+		{"5 parsecs", 0},
+		{"NaN", 0},
+		{"-1s", 0},
+		{"1.2.3s", 0},
+		{"1" + strings.Repeat("0", 400) + "s", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := parseDuration(tt.input); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration string
+		expected float64
+	}{
+		{"string", `"0.5s"`, 0.5},
+		{"number", `0.5`, 0.5},
+		{"milliseconds", `"500ms"`, 0.5},
+		{"null", `null`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonData := []byte(`{"testNodes": [{"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+  {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+    {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed", "duration": ` + tt.duration + `}
+  ]}
+]}]}`)
+			for _, preserveHierarchy := range []bool{false, true} {
+				testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: preserveHierarchy, Strict: true})
+				if err != nil {
+					t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+				}
+				var tc JUnitTestCase
+				walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+					if len(suite.TestCases) > 0 {
+						tc = suite.TestCases[0]
+					}
+				})
+				if tc.Time != tt.expected {
+					t.Errorf("Expected time %v (hierarchy: %v), got %v", tt.expected, preserveHierarchy, tc.Time)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		var node TestNode
+		if err := json.Unmarshal([]byte(`{"duration": true}`), &node); err == nil {
+			t.Errorf("Expected an error for a boolean duration")
+		}
+	})
+}
+
+func TestRootTotals(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		Name: "AppTests",
+		TestSuites: []JUnitTestSuite{
+			{Name: "LoginTests", Tests: 3, Failures: 1, Skipped: 1, Time: 2.5},
+			{
+				Name: "AppUITests", Tests: 2, Errors: 1, Time: 4,
+				TestSuites: []JUnitTestSuite{
+					{Name: "OnboardingTests", Tests: 2, Errors: 1, Time: 4},
+				},
+			},
+		},
+	}
+
+	xmlData, err := MarshalJUnitXML(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalJUnitXML returned error: %v", err)
+	}
+	if !strings.Contains(string(xmlData), `<testsuites name="AppTests" tests="5" failures="1" errors="1" skipped="1" time="6.5">`) {
+		t.Errorf("Expected the root totals to be the sums of the top level suites, got:\n%s", xmlData)
+	}
+	if testSuites.Tests != 0 {
+		t.Errorf("Expected the marshaled test suites to be left untouched, got %d tests", testSuites.Tests)
+	}
+
+	parsed, err := ParseJUnit(bytes.NewReader(xmlData))
+	if err != nil {
+		t.Fatalf("ParseJUnit returned error: %v", err)
+	}
+	var tests, failures, errs, skipped int
+	var duration float64
+	for _, suite := range parsed.TestSuites {
+		tests += suite.Tests
+		failures += suite.Failures
+		errs += suite.Errors
+		skipped += suite.Skipped
+		duration += suite.Time
+	}
+	if parsed.Tests != tests || parsed.Failures != failures || parsed.Errors != errs || parsed.Skipped != skipped || parsed.Time != duration {
+		t.Errorf("Expected the parsed root totals to equal the sums of the suites, got %+v", parsed)
+	}
+}
+
+func TestMarshalCompactJUnitXML(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "nested_suites.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: true})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	indented, err := MarshalJUnitXML(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalJUnitXML returned error: %v", err)
+	}
+	compact, err := MarshalCompactJUnitXML(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalCompactJUnitXML returned error: %v", err)
+	}
+
+	if !bytes.HasPrefix(compact, []byte(xml.Header)) {
+		t.Errorf("Expected the XML header, got %s", compact)
+	}
+	if body := strings.TrimPrefix(string(compact), xml.Header); strings.Contains(body, "\n  <") {
+		t.Errorf("Expected no indentation, got %s", body)
+	}
+	if len(compact) >= len(indented) {
+		t.Errorf("Expected the compact XML to be smaller, got %d and %d bytes", len(compact), len(indented))
+	}
+	if err := VerifyRoundTrip(compact, testSuites); err != nil {
+		t.Errorf("Expected the compact XML to round-trip, got %v", err)
+	}
+}
+
+func TestSuiteIDs(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "nested_suites.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+		}
+		if strings.Contains(string(xmlData), " id=") {
+			t.Errorf("Expected no id attributes, got:\n%s", xmlData)
+		}
+	})
+
+	t.Run("sequential in document order", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: true, SuiteIDs: true})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+
+		var ids []int
+		walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+			if suite.ID == nil {
+				t.Fatalf("Expected an id for %s", suite.Name)
+			}
+			ids = append(ids, *suite.ID)
+		})
+		for i, id := range ids {
+			if id != i {
+				t.Errorf("Expected sequential ids starting at 0, got %v", ids)
+				break
+			}
+		}
+
+		xmlData, err := MarshalJUnitXML(testSuites)
+		if err != nil {
+			t.Fatalf("MarshalJUnitXML returned error: %v", err)
+		}
+		if !strings.Contains(string(xmlData), `<testsuite id="0" name="AppTests"`) {
+			t.Errorf("Expected the first suite with id 0, got:\n%s", xmlData)
+		}
+	})
+}
+
+func TestVerifyRoundTrip(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "nested_suites.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	for _, preserveHierarchy := range []bool{false, true} {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: preserveHierarchy})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+
+		xmlData, err := MarshalJUnitXML(testSuites)
+		if err != nil {
+			t.Fatalf("MarshalJUnitXML returned error: %v", err)
+		}
+
+		if err := VerifyRoundTrip(xmlData, testSuites); err != nil {
+			t.Errorf("Expected round-trip to succeed (preserve hierarchy: %v), got %v", preserveHierarchy, err)
+		}
+	}
+
+	t.Run("mismatch is reported", func(t *testing.T) {
+		testSuites := JUnitTestSuites{
+			TestSuites: []JUnitTestSuite{{Name: "LoginTests", TestCases: []JUnitTestCase{{Name: "testLogin()"}}}},
+		}
+		xmlData := []byte(`<testsuites><testsuite name="LoginTests"></testsuite></testsuites>`)
+
+		if err := VerifyRoundTrip(xmlData, testSuites); err == nil {
+			t.Errorf("Expected test case count mismatch error, got nil")
+		}
+	})
+
+	t.Run("suite counts mismatch is reported", func(t *testing.T) {
+		testSuites := JUnitTestSuites{
+			TestSuites: []JUnitTestSuite{{Name: "LoginTests", Tests: 1, Failures: 1, TestCases: []JUnitTestCase{{Name: "testLogin()"}}}},
+		}
+		xmlData := []byte(`<testsuites><testsuite name="LoginTests" tests="1" failures="0"><testcase name="testLogin()"></testcase></testsuite></testsuites>`)
+
+		err := VerifyRoundTrip(xmlData, testSuites)
+		if err == nil || !strings.Contains(err.Error(), "failures=1") {
+			t.Errorf("Expected suite counts mismatch error, got %v", err)
+		}
+	})
+}
+
+func TestCollapseSingletonSuites(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{Name: "LoginTests", Tests: 2, TestCases: []JUnitTestCase{{Name: "testA", Classname: "App.LoginTests"}, {Name: "testB", Classname: "App.LoginTests"}}},
+			{Name: "Param1", Tests: 1, Time: 0.5, TestCases: []JUnitTestCase{{Name: "testC", Classname: "App.Param1", Time: 0.5}}},
+			{Name: "Param2", Tests: 1, Failures: 1, Time: 1, TestCases: []JUnitTestCase{{Name: "testD", Classname: "App.Param2", Time: 1, Failure: &JUnitFailure{Message: "failed"}}}},
+			{Name: "Param3", Tests: 1, Time: 0.25, TestCases: []JUnitTestCase{{Name: "testE", Classname: "App.Param3", Time: 0.25}}},
+		},
+	}
+
+	collapseSingletonSuites(&testSuites, "Parameterized")
+
+	if len(testSuites.TestSuites) != 2 {
+		t.Fatalf("Expected 2 suites, got %d", len(testSuites.TestSuites))
+	}
+	if testSuites.TestSuites[0].Name != "LoginTests" || testSuites.TestSuites[0].Tests != 2 {
+		t.Errorf("Expected LoginTests to be untouched, got %+v", testSuites.TestSuites[0])
+	}
+
+	collapsed := testSuites.TestSuites[1]
+	if collapsed.Name != "Parameterized" {
+		t.Errorf("Expected collapsed suite name Parameterized, got %s", collapsed.Name)
+	}
+	if collapsed.Tests != 3 || collapsed.Failures != 1 || collapsed.Time != 1.75 {
+		t.Errorf("Expected collapsed totals tests=3 failures=1 time=1.75, got tests=%d failures=%d time=%f", collapsed.Tests, collapsed.Failures, collapsed.Time)
+	}
+	for i, expected := range []string{"App.Param1", "App.Param2", "App.Param3"} {
+		if collapsed.TestCases[i].Classname != expected {
+			t.Errorf("Expected classname %s, got %s", expected, collapsed.TestCases[i].Classname)
+		}
+	}
+}
+
+func TestFlattenSuites(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "test_results.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	for _, preserveHierarchy := range []bool{false, true} {
+		t.Run(map[bool]string{false: "flat", true: "hierarchy"}[preserveHierarchy], func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{
+				PreserveHierarchy:  preserveHierarchy,
+				Flatten:            true,
+				FlattenedSuiteName: "Everything",
+			})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+
+			if len(testSuites.TestSuites) != 1 {
+				t.Fatalf("Expected a single suite, got %d", len(testSuites.TestSuites))
+			}
+			suite := testSuites.TestSuites[0]
+			if suite.Name != "Everything" || len(suite.TestSuites) != 0 {
+				t.Errorf("Expected the Everything suite without nested suites, got %s with %d", suite.Name, len(suite.TestSuites))
+			}
+			if suite.Tests != 4 || suite.Failures != 1 || suite.Skipped != 1 || len(suite.TestCases) != 4 {
+				t.Errorf("Expected 4 tests, 1 failure and 1 skipped, got %d tests, %d failures and %d skipped", suite.Tests, suite.Failures, suite.Skipped)
+			}
+			classnames := map[string]bool{}
+			for _, tc := range suite.TestCases {
+				classnames[tc.Classname] = true
+			}
+			if len(classnames) != 3 {
+				t.Errorf("Expected the classnames of the 3 suites to be kept, got %v", classnames)
+			}
+		})
+	}
+}
+
+func TestParseJUnit(t *testing.T) {
+	for _, fixture := range []string{"swift_testing_mixed_severity.json", "nested_suites.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			jsonData, err := os.ReadFile(filepath.Join("testdata", fixture))
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+
+			xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{PreserveHierarchy: true})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+			}
+
+			parsed, err := ParseJUnit(bytes.NewReader(xmlData))
+			if err != nil {
+				t.Fatalf("ParseJUnit returned error: %v", err)
+			}
+
+			roundTripped, err := MarshalJUnitXML(*parsed)
+			if err != nil {
+				t.Fatalf("MarshalJUnitXML returned error: %v", err)
+			}
+			if string(roundTripped) != string(xmlData) {
+				t.Errorf("Expected round-tripped XML to be identical, got:\n%s\nexpected:\n%s", roundTripped, xmlData)
+			}
+		})
+	}
+
+	t.Run("single testsuite root", func(t *testing.T) {
+		xmlData := `<testsuite name="LoginTests" tests="2" failures="1" errors="0" time="1.5" timestamp="">
+  <testcase name="testLogin()" classname="AppTests.LoginTests" time="0.5">
+    <skipped message="No network"></skipped>
+  </testcase>
+  <testcase name="testLogout()" classname="AppTests.LoginTests" time="1">
+    <failure message="XCTAssertTrue failed" type="Failure">XCTAssertTrue failed</failure>
+    <system-out>warning: slow</system-out>
+  </testcase>
+</testsuite>`
+
+		parsed, err := ParseJUnit(strings.NewReader(xmlData))
+		if err != nil {
+			t.Fatalf("ParseJUnit returned error: %v", err)
+		}
+		if len(parsed.TestSuites) != 1 || len(parsed.TestSuites[0].TestCases) != 2 {
+			t.Fatalf("Expected 1 suite with 2 test cases, got %+v", parsed)
+		}
+
+		cases := parsed.TestSuites[0].TestCases
+		if cases[0].Skipped == nil || cases[0].Skipped.Message != "No network" {
+			t.Errorf("Expected skipped test case, got %+v", cases[0])
+		}
+		if cases[1].Failure == nil || cases[1].Failure.Message != "XCTAssertTrue failed" {
+			t.Errorf("Expected failed test case, got %+v", cases[1])
+		}
+		if cases[1].SystemOut != "warning: slow" {
+			t.Errorf("Expected system-out to be parsed, got %q", cases[1].SystemOut)
+		}
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		if _, err := ParseJUnit(strings.NewReader("<html></html>")); err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	})
+}
+
+func TestRelativizeSourcePaths(t *testing.T) {
+	newSuites := func() JUnitTestSuites {
+		message := "/Users/vagrant/git/AppTests/LoginTests.swift:42: XCTAssertTrue failed"
+		return JUnitTestSuites{
+			TestSuites: []JUnitTestSuite{
+				{
+					Name: "LoginTests",
+					TestCases: []JUnitTestCase{
+						{
+							Name:      "testLogin()",
+							Failure:   &JUnitFailure{Message: message, Content: message},
+							SystemOut: "warning: /Users/vagrant/git/AppTests/Fixtures.swift:3: deprecated",
+						},
+						{Name: "testOther()", SystemOut: "warning: /tmp/Other.swift:1: untouched"},
+						{Name: "testFlaky()", FlakyFailures: []JUnitFlakyFailure{{Message: message, Content: message}}},
+					},
+				},
+			},
+		}
+	}
+
+	for _, root := range []string{"/Users/vagrant/git", "/Users/vagrant/git/"} {
+		testSuites := newSuites()
+		relativizeSourcePaths(&testSuites, root)
+
+		tc := testSuites.TestSuites[0].TestCases[0]
+		if tc.Failure.Message != "AppTests/LoginTests.swift:42: XCTAssertTrue failed" {
+			t.Errorf("Unexpected failure message: %s", tc.Failure.Message)
+		}
+		if tc.Failure.Content != tc.Failure.Message {
+			t.Errorf("Unexpected failure content: %s", tc.Failure.Content)
+		}
+		if tc.SystemOut != "warning: AppTests/Fixtures.swift:3: deprecated" {
+			t.Errorf("Unexpected system-out: %s", tc.SystemOut)
+		}
+		if other := testSuites.TestSuites[0].TestCases[1]; other.SystemOut != "warning: /tmp/Other.swift:1: untouched" {
+			t.Errorf("Expected paths outside of the source root to be untouched, got %s", other.SystemOut)
+		}
+		if flaky := testSuites.TestSuites[0].TestCases[2].FlakyFailures[0]; flaky.Message != tc.Failure.Message || flaky.Content != tc.Failure.Message {
+			t.Errorf("Unexpected flaky failure: %+v", flaky)
+		}
+	}
+}
+
+func TestStripMethodParens(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "AppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"},
+        {"name": "testWith(param:)", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testWith(param:)", "result": "Passed"},
+        {"name": "plainName", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/plainName", "result": "Passed"}
+      ]
+    }
+  ]
+}`)
+
+	for _, tt := range []struct {
+		strip    bool
+		expected []string
+	}{
+		{false, []string{"plainName", "testLogin()", "testWith(param:)"}},
+		{true, []string{"plainName", "testLogin", "testWith(param:)"}},
+	} {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{StripMethodParens: tt.strip})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+
+		cases := testSuites.TestSuites[0].TestCases
+		if len(cases) != len(tt.expected) {
+			t.Fatalf("Expected %d test cases, got %d", len(tt.expected), len(cases))
+		}
+		for i, name := range tt.expected {
+			if cases[i].Name != name {
+				t.Errorf("Expected %s (strip: %v), got %s", name, tt.strip, cases[i].Name)
+			}
+		}
+	}
+}
+
+func TestApplyReportPortalCompat(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name: "LoginTests",
+				TestCases: []JUnitTestCase{
+					{Name: "testA", Skipped: &JUnitSkipped{}},
+					{Name: "testB", Classname: "App.LoginTests", Failure: &JUnitFailure{}},
+					{Name: "testC", Classname: "App.LoginTests", Skipped: &JUnitSkipped{Message: "No network"}},
+				},
+			},
+		},
+	}
+
+	applyReportPortalCompat(&testSuites)
+
+	cases := testSuites.TestSuites[0].TestCases
+	if cases[0].Classname != "LoginTests" {
+		t.Errorf("Expected empty classname to be set to the suite name, got %s", cases[0].Classname)
+	}
+	if cases[0].Skipped.Message != "Skipped" {
+		t.Errorf("Expected default skip message, got %s", cases[0].Skipped.Message)
+	}
+	if cases[1].Classname != "App.LoginTests" {
+		t.Errorf("Expected classname to be kept, got %s", cases[1].Classname)
+	}
+	if cases[1].Failure.Message != "Test failed" || cases[1].Failure.Content != "Test failed" {
+		t.Errorf("Expected default failure message, got %+v", cases[1].Failure)
+	}
+	if cases[2].Skipped.Message != "No network" {
+		t.Errorf("Expected skip reason to be kept, got %s", cases[2].Skipped.Message)
+	}
+}
+
+func TestRoundTimes(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name: "LoginTests", Time: 0.1 + 0.2,
+				TestCases: []JUnitTestCase{{Name: "testLogin()", Time: 0.49999999}, {Name: "testLogout()", Time: 0.12345}},
+				TestSuites: []JUnitTestSuite{
+					{Name: "SSOTests", Time: 1.0004, TestCases: []JUnitTestCase{{Name: "testSSO()", Time: 1.0004}}},
+				},
+			},
+		},
+	}
+
+	roundTimes(&testSuites, 3)
+
+	login := testSuites.TestSuites[0]
+	if login.Time != 0.3 {
+		t.Errorf("Expected suite time 0.3, got %v", login.Time)
+	}
+	if login.TestCases[0].Time != 0.5 {
+		t.Errorf("Expected 0.49999999 to become 0.5, got %v", login.TestCases[0].Time)
+	}
+	if login.TestCases[1].Time != 0.123 {
+		t.Errorf("Expected 0.12345 to become 0.123, got %v", login.TestCases[1].Time)
+	}
+	if sso := login.TestSuites[0]; sso.Time != 1 || sso.TestCases[0].Time != 1 {
+		t.Errorf("Expected the nested suite and its test case rounded to 1, got %v and %v", sso.Time, sso.TestCases[0].Time)
+	}
+
+	t.Run("conversion", func(t *testing.T) {
+		jsonData := []byte(`{"testNodes": [{"name": "LoginTests", "nodeType": "Test Suite", "children": [
+  {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed", "duration": "0.49999999s"}
+]}]}`)
+		for _, tt := range []struct {
+			decimals int
+			expected string
+		}{{0, `time="0.5"`}, {1, `time="0.5"`}, {9, `time="0.49999999"`}} {
+			xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{TimeDecimals: tt.decimals})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+			}
+			if !strings.Contains(string(xmlData), `<testcase name="testLogin()" classname="LoginTests" `+tt.expected) {
+				t.Errorf("Expected %s with %d decimals, got %s", tt.expected, tt.decimals, xmlData)
+			}
+		}
+	})
+}
+
+func TestSuiteTimeSource(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "nested_suites.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	suiteTimes := func(opts ConvertOptions) map[string]float64 {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, opts)
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		times := make(map[string]float64)
+		walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+			times[suite.Name] = suite.Time
+		})
+		return times
+	}
+
+	t.Run("test case sum", func(t *testing.T) {
+		times := suiteTimes(ConvertOptions{SuiteTimeSource: SuiteTimeFromTestCases})
+		if times["SSOTests"] != 2.5 || times["LoginTests"] != 1 {
+			t.Errorf("Expected summed suite times, got %v", times)
+		}
+	})
+
+	t.Run("reported duration with fallback", func(t *testing.T) {
+		times := suiteTimes(ConvertOptions{SuiteTimeSource: SuiteTimeFromXCResult})
+		if times["SSOTests"] != 3 {
+			t.Errorf("Expected the reported SSOTests duration 3, got %f", times["SSOTests"])
+		}
+		if times["LoginTests"] != 1 {
+			t.Errorf("Expected LoginTests without reported duration to fall back to 1, got %f", times["LoginTests"])
+		}
+	})
+
+	t.Run("reported duration by default", func(t *testing.T) {
+		// SSOTests reports 3s, its test cases take 2.5s: the rest is its setup and teardown
+		times := suiteTimes(ConvertOptions{})
+		if times["SSOTests"] != 3 {
+			t.Errorf("Expected the reported SSOTests duration 3 over the test case sum 2.5, got %f", times["SSOTests"])
+		}
+		if times["LoginTests"] != 1 {
+			t.Errorf("Expected LoginTests without reported duration to fall back to 1, got %f", times["LoginTests"])
+		}
+	})
+
+	t.Run("reported duration in hierarchy", func(t *testing.T) {
+		times := suiteTimes(ConvertOptions{SuiteTimeSource: SuiteTimeFromXCResult, PreserveHierarchy: true})
+		if times["SSOTests"] != 3 {
+			t.Errorf("Expected the reported SSOTests duration 3, got %f", times["SSOTests"])
+		}
+		if times["LoginTests"] != 4 || times["AppTests"] != 4 {
+			t.Errorf("Expected parents without reported duration to sum their children, got %v", times)
+		}
+	})
+}
+
+func TestContainerNodeTypeVariants(t *testing.T) {
+	for _, fixture := range []string{"container_types_capitalized.json", "container_types_unknown.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			jsonData, err := os.ReadFile(filepath.Join("testdata", fixture))
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+
+			if len(testSuites.TestSuites) != 2 {
+				t.Fatalf("Expected 2 test suites, got %d", len(testSuites.TestSuites))
+			}
+
+			expected := map[string]string{
+				"LoginTests":        "AppTests.LoginTests",
+				"OnboardingUITests": "AppUITests.OnboardingUITests",
+			}
+			for _, suite := range testSuites.TestSuites {
+				if len(suite.TestCases) != 1 {
+					t.Errorf("Expected 1 test case in %s, got %d", suite.Name, len(suite.TestCases))
+					continue
+				}
+				if classname := suite.TestCases[0].Classname; classname != expected[suite.Name] {
+					t.Errorf("Expected classname %s in %s, got %s", expected[suite.Name], suite.Name, classname)
+				}
+			}
+		})
+	}
+}
+
+func TestSkippedTestCases(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "AppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {
+          "name": "LoginTests",
+          "nodeType": "Test Suite",
+          "children": [
+            {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed", "duration": "1s"},
+            {
+              "name": "testSSO()",
+              "nodeType": "Test Case",
+              "nodeIdentifier": "LoginTests/testSSO()",
+              "result": "Skipped",
+              "children": [
+                {"name": "LoginTests.swift:30: Test skipped - SSO is not configured", "nodeType": "Failure Message"}
+              ]
+            },
+            {"name": "testTouchID()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testTouchID()", "result": "Skipped"}
+          ]
+        }
+      ]
+    }
+  ]
+}`)
+
+	xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+	}
+
+	if !strings.Contains(string(xmlData), `skipped="2"`) {
+		t.Errorf("Expected skipped attribute on the suite, got:\n%s", xmlData)
+	}
+
+	var testSuites JUnitTestSuites
+	if err := xml.Unmarshal(xmlData, &testSuites); err != nil {
+		t.Fatalf("Failed to unmarshal generated XML: %v", err)
+	}
+
+	suite := testSuites.TestSuites[0]
+	if suite.Tests != 3 || suite.Skipped != 2 || suite.Failures != 0 {
+		t.Errorf("Expected tests=3 skipped=2 failures=0, got tests=%d skipped=%d failures=%d", suite.Tests, suite.Skipped, suite.Failures)
+	}
+
+	cases := suite.TestCases
+	if cases[0].Skipped != nil {
+		t.Errorf("Expected testLogin() not to be skipped")
+	}
+	if cases[1].Skipped == nil || cases[1].Skipped.Message != "LoginTests.swift:30: Test skipped - SSO is not configured" {
+		t.Errorf("Expected testSSO() to be skipped with its reason, got %+v", cases[1].Skipped)
+	}
+	if cases[1].Failure != nil {
+		t.Errorf("Expected skipped test not to be a failure")
+	}
+	if cases[2].Skipped == nil || cases[2].Skipped.Message != "" {
+		t.Errorf("Expected testTouchID() to be skipped without a reason, got %+v", cases[2].Skipped)
+	}
+}
+
+func TestErroredTestCases(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "AppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {
+          "name": "SyncTests",
+          "nodeType": "Test Suite",
+          "children": [
+            {
+              "name": "testAssertion()",
+              "nodeType": "Test Case",
+              "nodeIdentifier": "SyncTests/testAssertion()",
+              "result": "Failed",
+              "children": [{"name": "SyncTests.swift:12: XCTAssertEqual failed: (1) is not equal to (2)", "nodeType": "Failure Message"}]
+            },
+            {
+              "name": "testThrows()",
+              "nodeType": "Test Case",
+              "nodeIdentifier": "SyncTests/testThrows()",
+              "result": "Failed",
+              "children": [{"name": "SyncTests.swift:20: failed: caught error: \"networkUnavailable\"", "nodeType": "Failure Message"}]
+            }
+          ]
+        }
+      ]
+    }
+  ]
+}`)
+
+	xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+	}
+
+	var testSuites JUnitTestSuites
+	if err := xml.Unmarshal(xmlData, &testSuites); err != nil {
+		t.Fatalf("Failed to unmarshal generated XML: %v", err)
+	}
+
+	suite := testSuites.TestSuites[0]
+	if suite.Failures != 1 || suite.Errors != 1 {
+		t.Errorf("Expected failures=1 errors=1, got failures=%d errors=%d", suite.Failures, suite.Errors)
+	}
+
+	assertion, thrown := suite.TestCases[0], suite.TestCases[1]
+	if assertion.Failure == nil || assertion.Error != nil {
+		t.Errorf("Expected the assertion to be a failure, got %+v", assertion)
+	}
+	if thrown.Error == nil || thrown.Failure != nil {
+		t.Fatalf("Expected the thrown error to be an error, got %+v", thrown)
+	}
+	if thrown.Error.Message != `SyncTests.swift:20: failed: caught error: "networkUnavailable"` {
+		t.Errorf("Unexpected error message: %s", thrown.Error.Message)
+	}
+}
+
+func TestRunTimestamp(t *testing.T) {
+	jsonData := []byte(`{
+  "startTime": 1714557600.5,
+  "testNodes": [
+    {
+      "name": "AppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"}
+      ]
+    }
+  ]
+}`)
+	expected := time.Unix(1714557600, 0).Format(time.RFC3339)
+
+	t.Run("start time from the JSON", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if timestamp := testSuites.TestSuites[0].Timestamp; timestamp != expected {
+			t.Errorf("Expected timestamp %s, got %s", expected, timestamp)
+		}
+	})
+
+	t.Run("start time option takes precedence", func(t *testing.T) {
+		startTime := time.Date(2024, 5, 2, 8, 30, 0, 0, time.UTC)
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{StartTime: startTime})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if timestamp := testSuites.TestSuites[0].Timestamp; timestamp != "2024-05-02T08:30:00Z" {
+			t.Errorf("Expected timestamp 2024-05-02T08:30:00Z, got %s", timestamp)
+		}
+	})
+
+	t.Run("falls back to the current time", func(t *testing.T) {
+		before := time.Now().Add(-time.Second)
+		timestamp, err := time.Parse(time.RFC3339, runTimestamp(time.Time{}, 0))
+		if err != nil {
+			t.Fatalf("Failed to parse timestamp: %v", err)
+		}
+		if timestamp.Before(before) {
+			t.Errorf("Expected the current time, got %s", timestamp)
+		}
+	})
+}
+
+func TestActivityLogSystemOut(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "AppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {
+          "name": "testCheckout()",
+          "nodeType": "Test Case",
+          "nodeIdentifier": "CheckoutTests/testCheckout()",
+          "result": "Passed",
+          "activitySummaries": {
+            "_values": [
+              {"activitySummary": {"title": "Open the cart", "messages": [{"string_value": "items < 3 && total > 0"}]}},
+              {"activitySummary": {"title": "Tap <Pay>"}},
+              {"activitySummary": {"title": "XCTAssertEqual failed: (\"0\") is not equal to (\"3\")", "activityType": "com.apple.dt.xctest.activity-type.testAssertionFailure"}}
+            ]
+          }
+        }
+      ]
+    }
+  ]
+}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{SystemOutOn: SystemOutAlways})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	tc := testSuites.TestSuites[0].TestCases[0]
+	expected := "Open the cart\n  items < 3 && total > 0\nTap <Pay>"
+	if string(tc.SystemOut) != expected {
+		t.Errorf("Expected system-out %q, got %q", expected, tc.SystemOut)
+	}
+
+	xmlData, err := MarshalJUnitXML(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalJUnitXML returned error: %v", err)
+	}
+	if !strings.Contains(string(xmlData), "<system-out><![CDATA["+expected+"]]></system-out>") {
+		t.Errorf("Expected system-out wrapped in CDATA, got %s", xmlData)
+	}
+
+	parsed, err := ParseJUnit(bytes.NewReader(xmlData))
+	if err != nil {
+		t.Fatalf("ParseJUnit returned error: %v", err)
+	}
+	if got := parsed.TestSuites[0].TestCases[0].SystemOut; string(got) != expected {
+		t.Errorf("Expected parsed system-out %q, got %q", expected, got)
+	}
+
+	// The failed assertions are logged to system-err
+	expectedErr := `XCTAssertEqual failed: ("0") is not equal to ("3")`
+	if string(tc.SystemErr) != expectedErr {
+		t.Errorf("Expected system-err %q, got %q", expectedErr, tc.SystemErr)
+	}
+	if !strings.Contains(string(xmlData), "<system-err><![CDATA["+expectedErr+"]]></system-err>") {
+		t.Errorf("Expected system-err wrapped in CDATA, got %s", xmlData)
+	}
+}
+
+func TestSystemOutOn(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "CheckoutTests", "nodeType": "Test Suite", "children": [
+  {"name": "testCheckout()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testCheckout()", "result": "Failed",
+   "activitySummaries": {"_values": [{"activitySummary": {"title": "Tap Pay"}}]}},
+  {"name": "testEmptyCart()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testEmptyCart()", "result": "Passed",
+   "activitySummaries": {"_values": [{"activitySummary": {"title": "Open the cart"}}]},
+   "children": [{"name": "Main Thread Checker: UI API called on a background thread", "nodeType": "Runtime Warning"}]}
+]}]}`)
+
+	tests := []struct {
+		systemOutOn string
+		failed      string
+		passed      string
+	}{
+		{"", "Tap Pay", "warning: Main Thread Checker: UI API called on a background thread"},
+		{SystemOutFailures, "Tap Pay", "warning: Main Thread Checker: UI API called on a background thread"},
+		{SystemOutAlways, "Tap Pay", "Open the cart\nwarning: Main Thread Checker: UI API called on a background thread"},
+		{SystemOutNever, "", "warning: Main Thread Checker: UI API called on a background thread"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.systemOutOn, func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{SystemOutOn: tt.systemOutOn})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+			cases := testSuites.TestSuites[0].TestCases
+			if got := string(cases[0].SystemOut); got != tt.failed {
+				t.Errorf("Expected system-out %q for the failed test, got %q", tt.failed, got)
+			}
+			if got := string(cases[1].SystemOut); got != tt.passed {
+				t.Errorf("Expected system-out %q for the passed test, got %q", tt.passed, got)
+			}
+		})
+	}
+}
+
+func TestFailureContext(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "CheckoutTests", "nodeType": "Test Suite", "children": [
+  {"name": "testCheckout()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testCheckout()", "result": "Failed",
+   "children": [{"name": "Total is 0", "nodeType": "Failure Message"}],
+   "activitySummaries": {"_values": [
+     {"activitySummary": {"title": "Launch the app"}},
+     {"activitySummary": {"title": "Open the cart"}},
+     {"activitySummary": {"title": "Tap Pay"}},
+     {"activitySummary": {"title": "Total is 0", "activityType": "com.apple.dt.xctest.activity-type.testAssertionFailure"}},
+     {"activitySummary": {"title": "Take screenshot"}},
+     {"activitySummary": {"title": "Terminate the app"}}
+   ]}},
+  {"name": "testEmptyCart()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testEmptyCart()", "result": "Failed",
+   "children": [{"name": "Cart isn't empty", "nodeType": "Failure Message"}],
+   "activitySummaries": {"_values": [{"activitySummary": {"title": "Open the cart"}}]}}
+]}]}`)
+
+	tests := []struct {
+		name     string
+		lines    int
+		expected string
+	}{
+		{"disabled", 0, "Total is 0"},
+		{"one line", 1, "Total is 0\n\nActivity context:\n  Tap Pay\n> Total is 0\n  Take screenshot"},
+		{"more lines than activities", 5, "Total is 0\n\nActivity context:\n  Launch the app\n  Open the cart\n  Tap Pay\n> Total is 0\n  Take screenshot\n  Terminate the app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{FailureContextLines: tt.lines})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+			cases := testSuites.TestSuites[0].TestCases
+			if content := cases[0].Failure.Content; content != tt.expected {
+				t.Errorf("Expected content %q, got %q", tt.expected, content)
+			}
+			// Without the failed activity there is no context to add
+			if content := cases[1].Failure.Content; content != "Cart isn't empty" {
+				t.Errorf("Expected content without context, got %q", content)
+			}
+		})
+	}
+
+	t.Run("first assertion", func(t *testing.T) {
+		node := TestNode{ActivitySummaries: ActivitySummaries{Values: []ActivitySummaryEntry{
+			{ActivitySummary: ActivitySummary{Title: "Open the cart"}},
+			{ActivitySummary: ActivitySummary{Title: "XCTAssertEqual failed: (\"0\") is not equal to (\"1\")"}},
+		}}}
+		expected := []string{"  Open the cart", `> XCTAssertEqual failed: ("0") is not equal to ("1")`}
+		if context := failureContext(node, "Total mismatch", 2); !reflect.DeepEqual(context, expected) {
+			t.Errorf("Expected %q, got %q", expected, context)
+		}
+	})
+}
+
+func TestDuplicateFailureMessages(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "CheckoutTests", "nodeType": "Test Suite", "children": [
+  {"name": "testCheckout()", "nodeType": "Test Case", "nodeIdentifier": "CheckoutTests/testCheckout()", "result": "Failed", "children": [
+    {"name": "CheckoutTests.swift:12: XCTAssertTrue failed", "nodeType": "Failure Message"},
+    {"name": "CheckoutTests.swift:20: XCTAssertEqual failed", "nodeType": "Failure Message"},
+    {"name": "CheckoutTests.swift:12: XCTAssertTrue failed", "nodeType": "Failure Message"}
+  ]}
+]}]}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+	expected := "CheckoutTests.swift:12: XCTAssertTrue failed\nCheckoutTests.swift:20: XCTAssertEqual failed"
+	if content := testSuites.TestSuites[0].TestCases[0].Failure.Content; content != expected {
+		t.Errorf("Expected content %q, got %q", expected, content)
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		legacyJSONData := []byte(`{"testPlanSummaries": [{"summaries": [{"testableSummaries": [
+			{"name": "LegacyTests", "tests": [{"name": "testOld()", "testStatus": "Failure", "failureSummaries": [
+				{"message": "XCTAssertTrue failed", "fileName": "OldTests.swift", "lineNumber": 12},
+				{"message": "XCTAssertEqual failed", "fileName": "OldTests.swift", "lineNumber": 20},
+				{"message": "XCTAssertTrue failed", "fileName": "OldTests.swift", "lineNumber": 12}
+			]}]}
+		]}]}]}`)
+
+		testSuites, err := ConvertLegacyXCResultJSONToTestSuites(legacyJSONData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertLegacyXCResultJSONToTestSuites returned error: %v", err)
+		}
+		expected := "OldTests.swift:12: XCTAssertTrue failed\nOldTests.swift:20: XCTAssertEqual failed"
+		if content := testSuites.TestSuites[0].TestCases[0].Failure.Content; content != expected {
+			t.Errorf("Expected content %q, got %q", expected, content)
+		}
+	})
+}
+
+func TestFailureDetails(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "AppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {
+          "name": "testCheckout()",
+          "nodeType": "Test Case",
+          "nodeIdentifier": "CheckoutTests/testCheckout()",
+          "result": "Failed",
+          "children": [
+            {
+              "name": "XCTAssertEqual failed: (\"1\") is not equal to (\"2\")",
+              "nodeType": "Failure Message",
+              "sourceLocation": {"filePath": "/src/AppTests/CheckoutTests.swift", "lineNumber": 42}
+            },
+            {"name": "CheckoutTests.swift:57: XCTAssertTrue failed", "nodeType": "Failure Message"},
+            {"name": "Network unavailable", "nodeType": "Failure Message"}
+          ]
+        }
+      ]
+    }
+  ]
+}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	failure := testSuites.TestSuites[0].TestCases[0].Failure
+	if failure == nil {
+		t.Fatalf("Expected a failure")
+	}
+	if failure.Message != `XCTAssertEqual failed: ("1") is not equal to ("2")` {
+		t.Errorf("Expected the first failure message as message, got %q", failure.Message)
+	}
+	expected := "/src/AppTests/CheckoutTests.swift:42: XCTAssertEqual failed: (\"1\") is not equal to (\"2\")\n" +
+		"CheckoutTests.swift:57: XCTAssertTrue failed\n" +
+		"Network unavailable"
+	if failure.Content != expected {
+		t.Errorf("Expected failure content %q, got %q", expected, failure.Content)
+	}
+}
+
+func TestSourceLocations(t *testing.T) {
+	t.Run("location shapes", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			json     string
+			expected SourceLocation
+		}{
+			{"XCTest", `{"filePath": "/src/LoginTests.swift", "lineNumber": 42}`, SourceLocation{FilePath: "/src/LoginTests.swift", LineNumber: 42}},
+			{"Swift Testing", `{"fileID": "AppTests/LoginTests.swift", "_filePath": "/src/LoginTests.swift", "line": 42, "column": 7}`, SourceLocation{FilePath: "/src/LoginTests.swift", LineNumber: 42, ColumnNumber: 7}},
+			{"Swift Testing file ID only", `{"fileID": "AppTests/LoginTests.swift", "line": 42}`, SourceLocation{FilePath: "AppTests/LoginTests.swift", LineNumber: 42}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var loc SourceLocation
+				if err := json.Unmarshal([]byte(tt.json), &loc); err != nil {
+					t.Fatalf("Unmarshal returned error: %v", err)
+				}
+				if loc != tt.expected {
+					t.Errorf("Expected %+v, got %+v", tt.expected, loc)
+				}
+			})
+		}
+	})
+
+	jsonData := []byte(`{"testNodes": [{"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+  {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+    {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Failed", "children": [
+      {"name": "Expectation failed: (status → 401) == 200", "nodeType": "Failure Message",
+       "sourceLocation": {"fileID": "AppTests/LoginTests.swift", "_filePath": "/src/AppTests/LoginTests.swift", "line": 12, "column": 9}}
+    ]},
+    {"name": "testLogout()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogout()", "result": "Failed", "children": [
+      {"name": "LoginTests.swift:57: XCTAssertTrue failed", "nodeType": "Failure Message"}
+    ]},
+    {"name": "testSession()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testSession()", "result": "Failed", "children": [
+      {"name": "Session expired", "nodeType": "Failure Message"}
+    ]}
+  ]}
+]}]}`)
+
+	t.Run("failure content", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		tc := testSuites.TestSuites[0].TestCases[0]
+		if expected := "/src/AppTests/LoginTests.swift:12:9: Expectation failed: (status → 401) == 200"; tc.Failure.Content != expected {
+			t.Errorf("Expected content %q, got %q", expected, tc.Failure.Content)
+		}
+		if tc.File != "" || tc.Line != 0 {
+			t.Errorf("Expected no location attributes by default, got %s:%d", tc.File, tc.Line)
+		}
+	})
+
+	t.Run("attributes", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{LocationAttributes: true, SourceRootPath: "/src"})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		expected := map[string]struct {
+			file string
+			line int
+		}{
+			"testLogin()":   {"AppTests/LoginTests.swift", 12},
+			"testLogout()":  {"LoginTests.swift", 57},
+			"testSession()": {"", 0},
+		}
+		for _, tc := range testSuites.TestSuites[0].TestCases {
+			if want := expected[tc.Name]; tc.File != want.file || tc.Line != want.line {
+				t.Errorf("Expected %s:%d for %s, got %s:%d", want.file, want.line, tc.Name, tc.File, tc.Line)
+			}
+		}
+
+		xmlData, err := MarshalJUnitXML(testSuites)
+		if err != nil {
+			t.Fatalf("MarshalJUnitXML returned error: %v", err)
+		}
+		if !bytes.Contains(xmlData, []byte(`file="AppTests/LoginTests.swift" line="12"`)) {
+			t.Errorf("Expected the file and line attributes in the XML, got %s", xmlData)
+		}
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		jsonData := []byte(`{"testPlanSummaries": [{"summaries": [{"testableSummaries": [{"name": "AppTests", "tests": [
+  {"name": "OldTests", "subtests": [{"name": "testOld()", "identifier": "OldTests/testOld()", "testStatus": "Failure",
+   "failureSummaries": [{"message": "XCTAssertTrue failed", "fileName": "OldTests.swift", "lineNumber": 12}]}]}
+]}]}]}]}`)
+		testSuites, err := ConvertLegacyXCResultJSONToTestSuites(jsonData, ConvertOptions{LocationAttributes: true})
+		if err != nil {
+			t.Fatalf("ConvertLegacyXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if tc := testSuites.TestSuites[0].TestCases[0]; tc.File != "OldTests.swift" || tc.Line != 12 {
+			t.Errorf("Expected OldTests.swift:12, got %s:%d", tc.File, tc.Line)
+		}
+	})
+}
+
+func TestExtractFailureMessage(t *testing.T) {
+	t.Run("nested two levels deep", func(t *testing.T) {
+		node := TestNode{
+			Name:     "testRetry()",
+			NodeType: "Test Case",
+			Children: []TestNode{
+				{Name: "iPhone 15", NodeType: "Device", Children: []TestNode{
+					{Name: "First Run", NodeType: "Repetition", Children: []TestNode{
+						{Name: "Test failed deep inside", NodeType: "Failure Message"},
+					}},
+				}},
+			},
+		}
+		message, ok := extractFailureMessage(node)
+		if !ok {
+			t.Fatalf("Expected a failure message to be found")
+		}
+		if message != "Test failed deep inside" {
+			t.Errorf("Expected 'Test failed deep inside', got %q", message)
+		}
+	})
+
+	t.Run("own message takes precedence over nested ones", func(t *testing.T) {
+		node := TestNode{
+			Name:     "testLogin()",
+			NodeType: "Test Case",
+			Children: []TestNode{
+				{Name: "First Run", NodeType: "Repetition", Children: []TestNode{
+					{Name: "unrelated nested message", NodeType: "Failure Message"},
+				}},
+				{Name: "XCTAssertTrue failed", NodeType: "Failure Message"},
+			},
+		}
+		if message, _ := extractFailureMessage(node); message != "XCTAssertTrue failed" {
+			t.Errorf("Expected 'XCTAssertTrue failed', got %q", message)
+		}
+	})
+
+	t.Run("no failure message", func(t *testing.T) {
+		node := TestNode{
+			Name:     "testCrash()",
+			NodeType: "Test Case",
+			Result:   "Failed",
+			Children: []TestNode{{Name: "iPhone 15", NodeType: "Device", Result: "Failed"}},
+		}
+		if message, ok := extractFailureMessage(node); ok {
+			t.Errorf("Expected no failure message, got %q", message)
+		}
+		if message := failureMessageOrDefault(node); message != defaultFailureMessage {
+			t.Errorf("Expected %q, got %q", defaultFailureMessage, message)
+		}
+	})
+}
+
+func TestClassnameOptions(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "MyAppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {
+          "name": "Login Flow",
+          "nodeType": "Test Suite",
+          "children": [
+            {"name": "case", "nodeType": "Test Case", "nodeIdentifier": "Login Flow/case", "result": "Passed"}
+          ]
+        }
+      ]
+    }
+  ]
+}`)
+
+	tests := []struct {
+		name     string
+		opts     ConvertOptions
+		expected string
+	}{
+		{"default", ConvertOptions{}, "MyAppTests.Login Flow"},
+		{"strip bundle prefix", ConvertOptions{ClassnamePrefixStrip: "MyAppTests"}, "Login Flow"},
+		{"replace spaces", ConvertOptions{ClassnameReplaceSpaces: true}, "MyAppTests.Login_Flow"},
+		{"strip and replace", ConvertOptions{ClassnamePrefixStrip: "MyAppTests.", ClassnameReplaceSpaces: true}, "Login_Flow"},
+		{"prefix not matching", ConvertOptions{ClassnamePrefixStrip: "OtherTests"}, "MyAppTests.Login Flow"},
+		{"prefix within a segment", ConvertOptions{ClassnamePrefixStrip: "MyApp"}, "MyAppTests.Login Flow"},
+		{"prefix within a nested segment", ConvertOptions{ClassnamePrefixStrip: "MyAppTests.Login"}, "MyAppTests.Login Flow"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, tt.opts)
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+			tc := testSuites.TestSuites[0].TestCases[0]
+			if tc.Classname != tt.expected {
+				t.Errorf("Expected classname %q, got %q", tt.expected, tc.Classname)
+			}
+		})
+	}
+
+	t.Run("stripped bundle prefix in the hierarchy", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{ClassnamePrefixStrip: "MyAppTests", ClassnameReplaceSpaces: true, PreserveHierarchy: true})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		tc := testSuites.TestSuites[0].TestSuites[0].TestCases[0]
+		if got := tc.Classname + "." + tc.Name; got != "Login_Flow.case" {
+			t.Errorf("Expected Login_Flow.case, got %s", got)
+		}
+	})
+}
+
+func TestSanitizeNames(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": " Checkout/Payment\tTests ", "nodeType": "Test Suite", "children": [
+        {"name": "paysWithCard()", "nodeType": "Test Case", "result": "Passed"}
+      ]}
+    ]}
+  ]
+}`)
+
+	tests := []struct {
+		name              string
+		opts              ConvertOptions
+		expectedSuite     string
+		expectedClassname string
+	}{
+		{"flat", ConvertOptions{}, "Checkout_Payment_Tests", "AppTests.Checkout_Payment_Tests"},
+		{"hierarchy", ConvertOptions{PreserveHierarchy: true}, "Checkout_Payment_Tests", "AppTests.Checkout_Payment_Tests"},
+		{"raw names", ConvertOptions{RawNames: true}, " Checkout/Payment\tTests ", "AppTests. Checkout/Payment\tTests "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, tt.opts)
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+
+			suite := testSuites.TestSuites[0]
+			if tt.opts.PreserveHierarchy {
+				suite = suite.TestSuites[0]
+			}
+			if suite.Name != tt.expectedSuite {
+				t.Errorf("Expected suite %q, got %q", tt.expectedSuite, suite.Name)
+			}
+			if classname := suite.TestCases[0].Classname; classname != tt.expectedClassname {
+				t.Errorf("Expected classname %q, got %q", tt.expectedClassname, classname)
+			}
+		})
+	}
+
+	t.Run("split files", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		paths, err := writeSuiteFiles(testSuites, t.TempDir(), MarshalJUnitXML)
+		if err != nil {
+			t.Fatalf("writeSuiteFiles returned error: %v", err)
+		}
+		if name := filepath.Base(paths[0]); name != "Checkout_Payment_Tests.xml" {
+			t.Errorf("Expected the file to be named after the suite, got %s", name)
+		}
+	})
+}
+
+func TestNodeTraceLogging(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"},
+        {"name": "testLogout()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogout()", "result": "Failed"}
+      ]},
+      {"name": "Coverage", "nodeType": "Attachment"}
+    ]}
+  ]
+}`)
+
+	var output bytes.Buffer
+	log.SetOutWriter(&output)
+	log.SetEnableDebugLog(true)
+	defer func() {
+		log.SetOutWriter(os.Stdout)
+		log.SetEnableDebugLog(false)
+	}()
+
+	if _, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{ExcludePattern: regexp.MustCompile(`testLogout`)}); err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	for _, expected := range []string{
+		`Container "AppTests" (Unit test bundle)`,
+		`Test case "testLogin()" [LoginTests/testLogin()] (Passed) added to suite LoginTests`,
+		`Test case "testLogout()" [LoginTests/testLogout()] dropped by the include and exclude patterns`,
+		`Ignoring "Coverage" (Attachment) without test cases`,
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected the log to contain %q, got:\n%s", expected, output.String())
+		}
+	}
+
+	t.Run("not verbose", func(t *testing.T) {
+		output.Reset()
+		log.SetEnableDebugLog(false)
+		if _, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{}); err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if strings.Contains(output.String(), "Test case") {
+			t.Errorf("Expected no trace logs, got:\n%s", output.String())
+		}
+	})
+}
+
+func TestGroupBy(t *testing.T) {
+	// LoginTests is part of both bundles
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"}
+      ]}
+    ]},
+    {"name": "AppUITests", "nodeType": "UI test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testLoginScreen()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLoginScreen()", "result": "Failed"}
+      ]}
+    ]}
+  ]
+}`)
+
+	tests := []struct {
+		groupBy  string
+		expected map[string]int
+	}{
+		{GroupBySuite, map[string]int{"LoginTests": 2}},
+		{GroupByClassname, map[string]int{"AppTests.LoginTests": 1, "AppUITests.LoginTests": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{GroupBy: tt.groupBy})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+
+			got := map[string]int{}
+			for _, suite := range testSuites.TestSuites {
+				got[suite.Name] = suite.Tests
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected suites %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("identifier in both bundles", func(t *testing.T) {
+		jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testA()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testA()", "result": "Passed"},
+        {"name": "testB()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testB()", "result": "Passed"}
+      ]}
+    ]},
+    {"name": "AppUITests", "nodeType": "UI test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testB()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testB()", "result": "Failed"}
+      ]}
+    ]}
+  ]
+}`)
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{GroupBy: GroupByClassname})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+
+		got := map[string]int{}
+		for _, suite := range testSuites.TestSuites {
+			got[suite.Name] = suite.Tests
+		}
+		expected := map[string]int{"AppTests.LoginTests": 2, "AppUITests.LoginTests": 1}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected suites %v, got %v", expected, got)
+		}
+	})
+}
+
+func TestDisambiguateByDevice(t *testing.T) {
+	jsonData := []byte(`{
+  "devices": [
+    {"deviceId": "A", "deviceName": "iPhone 15", "platform": "iOS Simulator", "osVersion": "17.0"},
+    {"deviceId": "B", "deviceName": "iPad Air", "platform": "iOS Simulator", "osVersion": "17.0"}
+  ],
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Failed", "duration": "3s",
+         "children": [
+          {"name": "iPhone 15", "nodeType": "Device", "nodeIdentifier": "A", "result": "Passed", "duration": "1s"},
+          {"name": "iPad Air", "nodeType": "Device", "nodeIdentifier": "B", "result": "Failed", "duration": "2s",
+           "children": [{"name": "Button not found", "nodeType": "Failure Message"}]}
+        ]}
+      ]}
+    ]}
+  ]
+}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{DisambiguateByDevice: true})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	suites := map[string]JUnitTestSuite{}
+	for _, suite := range testSuites.TestSuites {
+		suites[suite.Name] = suite
+	}
+	if len(suites) != 2 {
+		t.Fatalf("Expected a suite per device, got %+v", testSuites.TestSuites)
+	}
+
+	iPhone, iPad := suites["LoginTests [iPhone 15, iOS 17.0]"], suites["LoginTests [iPad Air, iOS 17.0]"]
+	if iPhone.Tests != 1 || iPhone.Failures != 0 || iPhone.Time != 1 {
+		t.Errorf("Expected the passed run on iPhone 15, got %+v", iPhone)
+	}
+	if iPad.Tests != 1 || iPad.Failures != 1 {
+		t.Fatalf("Expected the failed run on iPad Air, got %+v", iPad)
+	}
+	if tc := iPad.TestCases[0]; tc.Failure == nil || tc.Failure.Message != "Button not found" || !reflect.DeepEqual(tc.FailedDevices, []string{"iPad Air"}) {
+		t.Errorf("Expected the failure on iPad Air, got %+v", tc)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if len(testSuites.TestSuites) != 1 || testSuites.TestSuites[0].Name != "LoginTests" {
+			t.Errorf("Expected a single LoginTests suite, got %+v", testSuites.TestSuites)
+		}
+	})
+}
+
+func TestBundleProperties(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "AppTestPlan", "nodeType": "Test Plan", "children": [
+  {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+    {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+      {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"}
+    ]}
+  ]},
+  {"name": "AppUITests", "nodeType": "UI test bundle", "children": [
+    {"name": "OnboardingUITests", "nodeType": "Test Suite", "children": [
+      {"name": "testOnboarding()", "nodeType": "Test Case", "nodeIdentifier": "OnboardingUITests/testOnboarding()", "result": "Passed"}
+    ]}
+  ]}
+]}]}`)
+
+	bundles := func(suite JUnitTestSuite) []string {
+		var values []string
+		if suite.Properties != nil {
+			for _, property := range suite.Properties.Properties {
+				if property.Name == "bundle" {
+					values = append(values, property.Value)
+				}
+			}
+		}
+		return values
+	}
+
+	t.Run("flat", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		expected := map[string][]string{"LoginTests": {"AppTests"}, "OnboardingUITests": {"AppUITests"}}
+		if len(testSuites.TestSuites) != len(expected) {
+			t.Fatalf("Expected %d suites, got %d", len(expected), len(testSuites.TestSuites))
+		}
+		for _, suite := range testSuites.TestSuites {
+			if got := bundles(suite); !reflect.DeepEqual(got, expected[suite.Name]) {
+				t.Errorf("Expected bundles %v for %s, got %v", expected[suite.Name], suite.Name, got)
+			}
+		}
+	})
+
+	t.Run("hierarchy", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: true})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		for _, bundle := range testSuites.TestSuites {
+			if got := bundles(bundle); got != nil {
+				t.Errorf("Expected no bundle property on the bundle %s, got %v", bundle.Name, got)
+			}
+			for _, suite := range bundle.TestSuites {
+				if got := bundles(suite); !reflect.DeepEqual(got, []string{bundle.Name}) {
+					t.Errorf("Expected bundle %s for %s, got %v", bundle.Name, suite.Name, got)
+				}
+			}
+		}
+	})
+
+	t.Run("suite in several bundles", func(t *testing.T) {
+		jsonData := []byte(`{"testNodes": [
+  {"name": "CoreTests", "nodeType": "Unit test bundle", "children": [
+    {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+      {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"}
+    ]}
+  ]},
+  {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+    {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+      {"name": "testLogout()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogout()", "result": "Passed"}
+    ]}
+  ]}
+]}`)
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if got := bundles(testSuites.TestSuites[0]); !reflect.DeepEqual(got, []string{"CoreTests", "AppTests"}) {
+			t.Errorf("Expected both bundles, got %v", got)
+		}
+	})
+}
+
+func TestTestPlanConfigurations(t *testing.T) {
+	jsonData := []byte(`{"testNodes": [{"name": "AppTestPlan", "nodeType": "Test Plan", "children": [
+  {"name": "Debug", "nodeType": "Test Plan Configuration", "children": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "duration": "1s", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed", "duration": "1s"}
+      ]}
+    ]}
+  ]},
+  {"name": "Release", "nodeType": "Test Plan Configuration", "children": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "duration": "2s", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Failed", "duration": "2s"}
+      ]}
+    ]}
+  ]}
+]}]}`)
+
+	configuration := func(suite JUnitTestSuite) string {
+		if suite.Properties != nil {
+			for _, property := range suite.Properties.Properties {
+				if property.Name == "configuration" {
+					return property.Value
+				}
+			}
+		}
+		return ""
+	}
+
+	t.Run("flat", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if len(testSuites.TestSuites) != 2 {
+			t.Fatalf("Expected a suite per configuration, got %d suites", len(testSuites.TestSuites))
+		}
+		expected := []struct {
+			name, configuration string
+			failures            int
+			time                float64
+		}{
+			{"LoginTests [Debug]", "Debug", 0, 1},
+			{"LoginTests [Release]", "Release", 1, 2},
+		}
+		for i, want := range expected {
+			suite := testSuites.TestSuites[i]
+			if suite.Name != want.name {
+				t.Errorf("Expected suite %s, got %s", want.name, suite.Name)
+			}
+			if got := configuration(suite); got != want.configuration {
+				t.Errorf("Expected configuration %s, got %s", want.configuration, got)
+			}
+			if suite.Tests != 1 || suite.Failures != want.failures || suite.Time != want.time {
+				t.Errorf("Expected 1 test, %d failure(s) in %gs, got %d tests, %d failure(s) in %gs", want.failures, want.time, suite.Tests, suite.Failures, suite.Time)
+			}
+			if tc := suite.TestCases[0]; tc.Properties != nil {
+				t.Errorf("Expected the runs not to be reported as retries, got %+v", tc.Properties)
+			}
+		}
+	})
+
+	t.Run("hierarchy", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: true})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if len(testSuites.TestSuites) != 2 {
+			t.Fatalf("Expected a bundle per configuration, got %d suites", len(testSuites.TestSuites))
+		}
+		for i, name := range []string{"AppTests [Debug]", "AppTests [Release]"} {
+			bundle := testSuites.TestSuites[i]
+			if bundle.Name != name {
+				t.Errorf("Expected bundle %s, got %s", name, bundle.Name)
+			}
+			if len(bundle.TestSuites) != 1 || configuration(bundle.TestSuites[0]) != configuration(bundle) {
+				t.Errorf("Expected LoginTests with the configuration of %s, got %+v", bundle.Name, bundle.TestSuites)
+			}
+		}
+	})
+
+	t.Run("single configuration", func(t *testing.T) {
+		jsonData := []byte(`{"testNodes": [{"name": "Default", "nodeType": "Test Plan Configuration", "children": [
+  {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+    {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"}
+  ]}
+]}]}`)
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if suite := testSuites.TestSuites[0]; suite.Name != "LoginTests" || configuration(suite) != "Default" {
+			t.Errorf("Expected LoginTests with the Default configuration, got %s (%s)", suite.Name, configuration(suite))
+		}
+	})
+}
+
+func TestDeviceProperties(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "nested_suites.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+	}
+
+	parsed, err := ParseJUnit(bytes.NewReader(xmlData))
+	if err != nil {
+		t.Fatalf("ParseJUnit returned error: %v", err)
+	}
+	for _, suite := range parsed.TestSuites {
+		// Next to the bundle property of the suite
+		if suite.Properties == nil || len(suite.Properties.Properties) != 2 {
+			t.Fatalf("Expected 2 properties on suite %s, got %v", suite.Name, suite.Properties)
+		}
+		if property := suite.Properties.Properties[1]; property.Name != "device" || property.Value != "iPhone 15, iOS Simulator 17.5, arm64" {
+			t.Errorf("Expected the device property, got %+v", property)
+		}
+	}
+
+	t.Run("describes the device", func(t *testing.T) {
+		properties := deviceProperties([]Device{
+			{DeviceName: "iPhone 15 Pro", ModelName: "iPhone16,1", Platform: "iOS Simulator", OsVersion: "17.5", Architecture: "arm64"},
+			{DeviceName: "My Mac", Platform: "macOS"},
+		})
+		expected := []JUnitProperty{
+			{Name: "device", Value: "iPhone 15 Pro (iPhone16,1), iOS Simulator 17.5, arm64"},
+			{Name: "device", Value: "My Mac, macOS"},
+		}
+		if !reflect.DeepEqual(properties, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, properties)
+		}
+	})
+
+	t.Run("no devices", func(t *testing.T) {
+		xmlData, err := ConvertXCResultJSONToJUnitXML([]byte(`{"testNodes": []}`), ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+		}
+		if strings.Contains(string(xmlData), "<properties") {
+			t.Errorf("Expected no properties without devices, got %s", xmlData)
+		}
+	})
+}
+
+func TestEmptySuite(t *testing.T) {
+	emptyJSON := []byte(`{"testNodes": []}`)
+	tests := []struct {
+		name     string
+		opts     ConvertOptions
+		expected []string
+	}{
+		{"default placeholder", ConvertOptions{}, []string{"XCTest"}},
+		{"named placeholder", ConvertOptions{EmptySuiteName: "NoTests"}, []string{"NoTests"}},
+		{"omitted", ConvertOptions{EmptySuiteName: "NoTests", OmitEmptySuite: true}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(emptyJSON, tt.opts)
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+			var names []string
+			for _, suite := range testSuites.TestSuites {
+				names = append(names, suite.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("Expected suites %v, got %v", tt.expected, names)
+			}
+		})
+	}
+
+	t.Run("empty testsuites element", func(t *testing.T) {
+		xmlData, err := ConvertXCResultJSONToJUnitXML(emptyJSON, ConvertOptions{OmitEmptySuite: true})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+		}
+		if strings.Contains(string(xmlData), "<testsuite ") {
+			t.Errorf("Expected no suite, got %s", xmlData)
+		}
+		if !strings.Contains(string(xmlData), `tests="0"`) {
+			t.Errorf("Expected the root element with 0 tests, got %s", xmlData)
+		}
+	})
+
+	t.Run("placeholder not used with tests", func(t *testing.T) {
+		jsonData, err := os.ReadFile(filepath.Join("testdata", "nested_suites.json"))
+		if err != nil {
+			t.Fatalf("Failed to read fixture: %v", err)
+		}
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{EmptySuiteName: "NoTests"})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		for _, suite := range testSuites.TestSuites {
+			if suite.Name == "NoTests" {
+				t.Errorf("Expected no placeholder suite, got %+v", testSuites.TestSuites)
+			}
+		}
+	})
+}
+
+func TestRetriedTestCaseNodes(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "AppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {"name": "testFlaky()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testFlaky()", "result": "Failed", "duration": "1s",
+         "children": [{"name": "Timed out", "nodeType": "Failure Message"}]},
+        {"name": "testFlaky()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testFlaky()", "result": "Passed", "duration": "2s"},
+        {"name": "testBroken()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testBroken()", "result": "Failed", "duration": "1s",
+         "children": [{"name": "First failure", "nodeType": "Failure Message"}]},
+        {"name": "testBroken()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testBroken()", "result": "Failed", "duration": "1s",
+         "children": [{"name": "Second failure", "nodeType": "Failure Message"}]},
+        {"name": "testStable()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testStable()", "result": "Passed", "duration": "1s"},
+        {"name": "testStable()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testStable()", "result": "Failed", "duration": "1s",
+         "children": [{"name": "Failed on re-run", "nodeType": "Failure Message"}]}
+      ]
+    }
+  ]
+}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	suite := testSuites.TestSuites[0]
+	if suite.Tests != 3 {
+		t.Errorf("Expected the re-runs to be collapsed into 3 tests, got %d", suite.Tests)
+	}
+	if suite.Failures != 2 {
+		t.Errorf("Expected 2 failures, got %d", suite.Failures)
+	}
+
+	cases := make(map[string]JUnitTestCase)
+	for _, tc := range suite.TestCases {
+		cases[tc.Name] = tc
+	}
+
+	t.Run("passed after retry is flaky", func(t *testing.T) {
+		tc := cases["testFlaky()"]
+		if tc.Failure != nil {
+			t.Errorf("Expected no failure, got %v", tc.Failure)
+		}
+		if len(tc.FlakyFailures) != 1 || tc.FlakyFailures[0].Message != "Timed out" {
+			t.Errorf("Expected the failed attempt as flaky failure, got %v", tc.FlakyFailures)
+		}
+		if tc.Time != 3 {
+			t.Errorf("Expected the time of both attempts, got %f", tc.Time)
+		}
+	})
+
+	t.Run("failed every attempt", func(t *testing.T) {
+		tc := cases["testBroken()"]
+		if tc.Failure == nil || tc.Failure.Message != "Second failure" {
+			t.Errorf("Expected the failure of the latest attempt, got %v", tc.Failure)
+		}
+		if len(tc.FlakyFailures) != 0 {
+			t.Errorf("Expected no flaky failures, got %v", tc.FlakyFailures)
+		}
+	})
+
+	t.Run("run again after passing is a repetition", func(t *testing.T) {
+		tc := cases["testStable()"]
+		if tc.Failure == nil || tc.Failure.Message != "Failed on re-run" {
+			t.Errorf("Expected the failure of the repetition, got %v", tc.Failure)
+		}
+		if len(tc.FlakyFailures) != 0 {
+			t.Errorf("Expected no flaky failures, got %v", tc.FlakyFailures)
+		}
+	})
+}
+
+func TestSharedIdentifierInBundles(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed", "duration": "1s"},
+        {"name": "testLogout()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogout()", "result": "Passed", "duration": "1s"}
+      ]}
+    ]},
+    {"name": "AppUITests", "nodeType": "UI test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Failed", "duration": "2s",
+         "children": [{"name": "Login button not found", "nodeType": "Failure Message"}]}
+      ]}
+    ]}
+  ]
+}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	suite := testSuites.TestSuites[0]
+	if suite.Tests != 3 || suite.Failures != 1 {
+		t.Errorf("Expected 3 tests and 1 failure, got %d tests and %d failures", suite.Tests, suite.Failures)
+	}
+	for _, tc := range suite.TestCases {
+		if len(tc.FlakyFailures) > 0 || tc.Properties != nil {
+			t.Errorf("Expected %s not to be merged as a re-run, got %+v", tc.Name, tc)
+		}
+	}
+
+	t.Run("merged bundles", func(t *testing.T) {
+		// The same test failed in one bundle and passed in the other, on different devices
+		bundle := func(device, result string) []byte {
+			return []byte(`{"testNodes": [{"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+  {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+    {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "` + result + `",
+     "children": [{"name": "` + device + `", "nodeType": "Device", "result": "` + result + `"}]}
+  ]}
+]}]}`)
+		}
+		merged, err := MergeXCResultJSON(bundle("iPhone 15", "Failed"), bundle("iPad", "Passed"))
+		if err != nil {
+			t.Fatalf("MergeXCResultJSON returned error: %v", err)
+		}
+
+		testSuites, err := ConvertXCResultJSONToTestSuites(merged, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		suite := testSuites.TestSuites[0]
+		if suite.Tests != 2 || suite.Failures != 1 {
+			t.Errorf("Expected 2 tests and 1 failure, got %d tests and %d failures", suite.Tests, suite.Failures)
+		}
+		for _, tc := range suite.TestCases {
+			if len(tc.FlakyFailures) > 0 || len(tc.Devices) != 1 {
+				t.Errorf("Expected %s on a single device not to be merged as a re-run, got %+v", tc.Name, tc)
+			}
+		}
+	})
+
+	t.Run("different devices", func(t *testing.T) {
+		jsonData := []byte(`{"testNodes": [{"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+  {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+    {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Failed",
+     "children": [{"name": "iPhone 15", "nodeType": "Device", "result": "Failed"}]},
+    {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed",
+     "children": [{"name": "iPad", "nodeType": "Device", "result": "Passed"}]}
+  ]}
+]}]}`)
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if suite := testSuites.TestSuites[0]; suite.Tests != 2 || suite.Failures != 1 {
+			t.Errorf("Expected 2 tests and 1 failure, got %d tests and %d failures", suite.Tests, suite.Failures)
+		}
+	})
+}
+
+func TestRepeatedTestCases(t *testing.T) {
+	repetitionsJSON := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "testSync()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testSync()", "result": "Failed", "duration": "3s",
+       "children": [
+        {"name": "Repetition 1", "nodeType": "Repetition", "result": "Passed", "duration": "1s"},
+        {"name": "Repetition 2", "nodeType": "Repetition", "result": "Failed", "duration": "1s",
+         "children": [{"name": "Timed out", "nodeType": "Failure Message"}]},
+        {"name": "Repetition 3", "nodeType": "Repetition", "result": "Passed", "duration": "1s"}
+      ]},
+      {"name": "testRetried()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testRetried()", "result": "Passed", "duration": "2s",
+       "children": [
+        {"name": "Repetition 1", "nodeType": "Repetition", "result": "Failed", "duration": "1s",
+         "children": [{"name": "Timed out", "nodeType": "Failure Message"}]},
+        {"name": "Repetition 2", "nodeType": "Repetition", "result": "Passed", "duration": "1s"}
+      ]}
+    ]}
+  ]
+}`)
+	separateNodesJSON := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "testSync()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testSync()", "result": "Passed", "duration": "1s"},
+      {"name": "testSync()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testSync()", "result": "Failed", "duration": "1s",
+       "children": [{"name": "Timed out", "nodeType": "Failure Message"}]},
+      {"name": "testSync()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testSync()", "result": "Passed", "duration": "1s"}
+    ]}
+  ]
+}`)
+
+	for name, jsonData := range map[string][]byte{"repetition nodes": repetitionsJSON, "separate nodes": separateNodesJSON} {
+		t.Run(name, func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+
+			suite := testSuites.TestSuites[0]
+			tc := suite.TestCases[len(suite.TestCases)-1]
+			if tc.Name != "testSync()" || suite.Failures != 1 {
+				t.Fatalf("Expected testSync() to fail once, got %+v", suite)
+			}
+			if tc.Failure == nil || tc.Failure.Message != "Timed out" {
+				t.Errorf("Expected the failure of the second repetition, got %v", tc.Failure)
+			}
+			if len(tc.FlakyFailures) != 0 {
+				t.Errorf("Expected no flaky failures, got %v", tc.FlakyFailures)
+			}
+			if tc.Time != 3 {
+				t.Errorf("Expected the time of the 3 repetitions, got %f", tc.Time)
+			}
+			expected := []JUnitProperty{{Name: "repetitions", Value: "3"}}
+			if tc.Properties == nil || !reflect.DeepEqual(tc.Properties.Properties, expected) {
+				t.Errorf("Expected %v, got %v", expected, tc.Properties)
+			}
+		})
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(repetitionsJSON, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+
+		tc := testSuites.TestSuites[0].TestCases[0]
+		if tc.Name != "testRetried()" || tc.Failure != nil || len(tc.FlakyFailures) != 1 {
+			t.Errorf("Expected testRetried() to pass with a flaky failure, got %+v", tc)
+		}
+		if tc.Properties != nil {
+			t.Errorf("Expected no repetitions property, got %v", tc.Properties)
+		}
+	})
+}
+
+func TestReproducibleOutput(t *testing.T) {
+	// Two test plan configurations produce two bundles with the same name
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "Release", "nodeType": "Test Plan Configuration",
+      "children": [
+        {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+          {"name": "testB()", "nodeType": "Test Case", "nodeIdentifier": "Tests/testB()", "result": "Passed"}
+        ]}
+      ]
+    },
+    {
+      "name": "Debug", "nodeType": "Test Plan Configuration",
+      "children": [
+        {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+          {"name": "testA()", "nodeType": "Test Case", "nodeIdentifier": "Tests/testA()", "result": "Passed"}
+        ]}
+      ]
+    }
+  ]
+}`)
+	opts := ConvertOptions{PreserveHierarchy: true, StartTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+
+	first, err := ConvertXCResultJSONToJUnitXML(jsonData, opts)
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		again, err := ConvertXCResultJSONToJUnitXML(jsonData, opts)
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+		}
+		if !bytes.Equal(first, again) {
+			t.Fatalf("Expected identical output, got\n%s\nand\n%s", first, again)
+		}
+	}
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, opts)
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+	if len(testSuites.TestSuites) != 2 {
+		t.Fatalf("Expected 2 test suites, got %d", len(testSuites.TestSuites))
+	}
+	if name := testSuites.TestSuites[0].TestCases[0].Name; name != "testA()" {
+		t.Errorf("Expected the suites with the same name to be ordered by their first test case, got %s first", name)
+	}
+}
+
+func TestSanitizeXMLText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"unchanged", "testLogin() <a> & \"b\"\n\ttab", "testLogin() <a> & \"b\"\n\ttab"},
+		{"NUL byte", "test\x00Nul()", "testNul()"},
+		{"control characters", "\x1b[31mred\x1b[0m\x08", "[31mred[0m"},
+		{"emoji", "testRocket🚀()", "testRocket🚀()"},
+		{"invalid UTF-8", "test\xffInvalid", "test�Invalid"},
+		{"noncharacters", "a￾b", "ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeXMLText(tt.text); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIllegalXMLCharacters(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "FuzzTests", "nodeType": "Test Suite", "children": [
+      {"name": "testFuzz\u0000()", "nodeType": "Test Case", "nodeIdentifier": "FuzzTests/testFuzz()", "result": "Failed", "children": [
+        {"name": "FuzzTests.swift:12: input \u0001\u0002 🚀 was rejected", "nodeType": "Failure Message"}
+      ]},
+      {"name": "testEmoji🚀()", "nodeType": "Test Case", "nodeIdentifier": "FuzzTests/testEmoji()", "result": "Passed", "tags": ["crit\u0001ical"]}
+    ]}
+  ]
+}`)
+
+	xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+	}
+
+	testSuites, err := ParseJUnit(bytes.NewReader(xmlData))
+	if err != nil {
+		t.Fatalf("Expected well-formed XML, got %v\n%s", err, xmlData)
+	}
+	cases := testSuites.TestSuites[0].TestCases
+	if len(cases) != 2 {
+		t.Fatalf("Expected 2 test cases, got %d", len(cases))
+	}
+	if cases[0].Name != "testEmoji🚀()" {
+		t.Errorf("Expected the emoji to be kept, got %s", cases[0].Name)
+	}
+	if cases[1].Name != "testFuzz()" {
+		t.Errorf("Expected the NUL byte to be removed, got %q", cases[1].Name)
+	}
+	if cases[1].Failure == nil || strings.ContainsAny(cases[1].Failure.Message, "\x01\x02") {
+		t.Errorf("Expected a failure without control characters, got %+v", cases[1].Failure)
+	}
+	if cases[0].Properties == nil || cases[0].Properties.Properties[0].Value != "critical" {
+		t.Errorf("Expected a tag property without control characters, got %+v", cases[0].Properties)
+	}
+}
+
+func TestFailureContentCDATA(t *testing.T) {
+	content := "XCTAssertEqual failed: (\"<user id=\"1\"/>\") is not equal to (\"<user id=\"2\"/>\")\nLoginTests.swift:42 & more"
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name:  "LoginTests",
+				Tests: 2, Failures: 1, Errors: 1,
+				TestCases: []JUnitTestCase{
+					{Name: "testLogin()", Failure: &JUnitFailure{Message: "<user> mismatch", Type: "Failure", Content: content}},
+					{Name: "testLogout()", Error: &JUnitError{Message: "Thrown error", Type: "Error", Content: "a ]]> b"}},
+				},
+			},
+		},
+	}
+
+	xmlData, err := MarshalJUnitXML(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalJUnitXML returned error: %v", err)
+	}
+	xmlString := string(xmlData)
+
+	if !strings.Contains(xmlString, "<![CDATA["+content+"]]>") {
+		t.Errorf("Expected the failure body in a CDATA section, got:\n%s", xmlString)
+	}
+	if !strings.Contains(xmlString, `message="&lt;user&gt; mismatch"`) {
+		t.Errorf("Expected an escaped message attribute, got:\n%s", xmlString)
+	}
+
+	parsed, err := ParseJUnit(bytes.NewReader(xmlData))
+	if err != nil {
+		t.Fatalf("ParseJUnit returned error: %v", err)
+	}
+	cases := parsed.TestSuites[0].TestCases
+	if cases[0].Failure == nil || cases[0].Failure.Content != content {
+		t.Errorf("Expected the failure body to round-trip, got %+v", cases[0].Failure)
+	}
+	if cases[1].Error == nil || cases[1].Error.Content != "a ]]> b" {
+		t.Errorf("Expected the error body with a CDATA terminator to round-trip, got %+v", cases[1].Error)
+	}
+}
+
+func TestValidateSuiteCounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		suite   JUnitTestSuite
+		wantErr bool
+	}{
+		{"consistent", JUnitTestSuite{Name: "A", Tests: 4, Failures: 1, Errors: 1, Skipped: 2}, false},
+		{"empty", JUnitTestSuite{Name: "XCTest"}, false},
+		{"more outcomes than tests", JUnitTestSuite{Name: "A", Tests: 2, Failures: 2, Skipped: 1}, true},
+		{"negative", JUnitTestSuite{Name: "A", Tests: 1, Skipped: -1}, true},
+		{"nested", JUnitTestSuite{Name: "A", Tests: 1, TestSuites: []JUnitTestSuite{{Name: "B", Tests: 1, Failures: 2}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSuiteCounts(JUnitTestSuites{TestSuites: []JUnitTestSuite{tt.suite}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("converted suites count skipped tests", func(t *testing.T) {
+		jsonData := []byte(`{"testNodes": [{"name": "LoginTests", "nodeType": "Test Suite", "children": [
+  {"name": "testA()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testA()", "result": "Skipped"},
+  {"name": "testB()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testB()", "result": "Failed"},
+  {"name": "testC()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testC()", "result": "Passed"}
+]}]}`)
+		for _, preserveHierarchy := range []bool{false, true} {
+			testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: preserveHierarchy})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+			suite := testSuites.TestSuites[0]
+			if suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 {
+				t.Errorf("Expected 3 tests, 1 failure and 1 skipped, got %d tests, %d failures and %d skipped", suite.Tests, suite.Failures, suite.Skipped)
+			}
+			if err := validateSuiteCounts(testSuites); err != nil {
+				t.Errorf("Expected consistent counts, got %v", err)
+			}
+		}
+	})
+}
+
+func TestSuiteHostAttributes(t *testing.T) {
+	jsonData := []byte(`{
+  "devices": [{"deviceId": "A", "deviceName": "iPhone 15"}],
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"}
+      ]}
+    ]}
+  ]
+}`)
+
+	t.Run("disabled by default", func(t *testing.T) {
+		xmlData, err := ConvertXCResultJSONToJUnitXML(jsonData, ConvertOptions{Hostname: "ci-mac"})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToJUnitXML returned error: %v", err)
+		}
+		if strings.Contains(string(xmlData), "hostname=") || strings.Contains(string(xmlData), "package=") {
+			t.Errorf("Expected no hostname and package attributes, got:\n%s", xmlData)
+		}
+	})
+
+	t.Run("single device", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: true, SuiteHostAttributes: true, Hostname: "ci-mac"})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		bundle := testSuites.TestSuites[0]
+		if bundle.Hostname != "iPhone 15" || bundle.Package != "AppTests" {
+			t.Errorf("Expected hostname iPhone 15 and package AppTests, got %s and %s", bundle.Hostname, bundle.Package)
+		}
+		if suite := bundle.TestSuites[0]; suite.Hostname != "iPhone 15" || suite.Package != "AppTests" {
+			t.Errorf("Expected nested suite with hostname iPhone 15 and package AppTests, got %s and %s", suite.Hostname, suite.Package)
+		}
+	})
+
+	t.Run("configured hostname", func(t *testing.T) {
+		testSuites, err := ConvertLegacyXCResultJSONToTestSuites([]byte(`{"testPlanSummaries": [{"summaries": [{"testableSummaries": [
+  {"name": "AppTests", "tests": [{"name": "LoginTests", "subtests": [{"name": "testLogin()", "testStatus": "Success"}]}]}
+]}]}]}`), ConvertOptions{SuiteHostAttributes: true, Hostname: "ci-mac"})
+		if err != nil {
+			t.Fatalf("ConvertLegacyXCResultJSONToTestSuites returned error: %v", err)
+		}
+		if suite := testSuites.TestSuites[0]; suite.Hostname != "ci-mac" || suite.Package != "" {
+			t.Errorf("Expected hostname ci-mac and no package, got %s and %s", suite.Hostname, suite.Package)
+		}
+	})
+}
+
+func TestSuitePackage(t *testing.T) {
+	tests := []struct {
+		name       string
+		classnames []string
+		expected   string
+	}{
+		{"shared prefix", []string{"AppTests.LoginTests", "AppTests.LoginTests"}, "AppTests"},
+		{"nested prefix", []string{"App.UITests.LoginTests"}, "App.UITests"},
+		{"different prefixes", []string{"AppTests.LoginTests", "UITests.LoginTests"}, ""},
+		{"no prefix", []string{"LoginTests"}, ""},
+		{"no test cases", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suite := JUnitTestSuite{Name: "Suite"}
+			for _, classname := range tt.classnames {
+				suite.TestCases = append(suite.TestCases, JUnitTestCase{Name: "test", Classname: classname})
+			}
+			if got := suitePackage(&suite); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOmitSkipped(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "test_results.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	tests := []struct {
+		name                   string
+		omitSkipped            bool
+		expectedTests, skipped int
+	}{
+		{"included", false, 4, 1},
+		{"omitted", true, 3, 0},
+	}
+
+	for _, tt := range tests {
+		for _, preserveHierarchy := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s, hierarchy %v", tt.name, preserveHierarchy), func(t *testing.T) {
+				testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{PreserveHierarchy: preserveHierarchy, OmitSkipped: tt.omitSkipped})
+				if err != nil {
+					t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+				}
+
+				total := computeTotals(testSuites)
+				if total.Tests != tt.expectedTests || total.Skipped != tt.skipped || total.Failures != 1 {
+					t.Errorf("Expected %d tests, %d skipped and 1 failure, got %d tests, %d skipped and %d failures",
+						tt.expectedTests, tt.skipped, total.Tests, total.Skipped, total.Failures)
+				}
+				walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+					for _, tc := range suite.TestCases {
+						if tt.omitSkipped && tc.Skipped != nil {
+							t.Errorf("Expected no skipped test case, got %s", tc.Name)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestTestCasePatterns(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+      {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed"},
+        {"name": "testFlakyLogout()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testFlakyLogout()", "result": "Failed"}
+      ]},
+      {"name": "ProfileTests", "nodeType": "Test Suite", "children": [
+        {"name": "testAvatar()", "nodeType": "Test Case", "nodeIdentifier": "ProfileTests/testAvatar()", "result": "Skipped"}
+      ]}
+    ]}
+  ]
+}`)
+
+	tests := []struct {
+		name     string
+		include  string
+		exclude  string
+		expected map[string][]string
+	}{
+		{"no patterns", "", "", map[string][]string{"LoginTests": {"testFlakyLogout()", "testLogin()"}, "ProfileTests": {"testAvatar()"}}},
+		{"exclude", "", `testFlaky`, map[string][]string{"LoginTests": {"testLogin()"}, "ProfileTests": {"testAvatar()"}}},
+		{"include", `^AppTests\.LoginTests\.`, "", map[string][]string{"LoginTests": {"testFlakyLogout()", "testLogin()"}}},
+		{"include and exclude", `LoginTests`, `Flaky`, map[string][]string{"LoginTests": {"testLogin()"}}},
+	}
+
+	for _, tt := range tests {
+		for _, preserveHierarchy := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s, hierarchy %v", tt.name, preserveHierarchy), func(t *testing.T) {
+				opts := ConvertOptions{PreserveHierarchy: preserveHierarchy}
+				if tt.include != "" {
+					opts.IncludePattern = regexp.MustCompile(tt.include)
+				}
+				if tt.exclude != "" {
+					opts.ExcludePattern = regexp.MustCompile(tt.exclude)
+				}
+
+				testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, opts)
+				if err != nil {
+					t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+				}
+
+				got := map[string][]string{}
+				walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+					for _, tc := range suite.TestCases {
+						got[suite.Name] = append(got[suite.Name], tc.Name)
+					}
+					if suite.Tests < len(suite.TestCases) || suite.Failures+suite.Skipped > suite.Tests {
+						t.Errorf("Expected the counts of %s to match its test cases, got %d tests, %d failures and %d skipped",
+							suite.Name, suite.Tests, suite.Failures, suite.Skipped)
+					}
+				})
+				if !reflect.DeepEqual(got, tt.expected) {
+					t.Errorf("Expected %v, got %v", tt.expected, got)
+				}
+			})
+		}
+	}
+
+	t.Run("legacy format", func(t *testing.T) {
+		testSuites, err := ConvertLegacyXCResultJSONToTestSuites([]byte(`{"testPlanSummaries": [{"summaries": [{"testableSummaries": [
+  {"name": "AppTests", "tests": [{"name": "LoginTests", "subtests": [
+    {"name": "testLogin()", "testStatus": "Success"},
+    {"name": "testFlakyLogout()", "testStatus": "Failure"}
+  ]}]}
+]}]}]}`), ConvertOptions{ExcludePattern: regexp.MustCompile(`^LoginTests\.testFlaky`)})
+		if err != nil {
+			t.Fatalf("ConvertLegacyXCResultJSONToTestSuites returned error: %v", err)
+		}
+		suite := testSuites.TestSuites[0]
+		if suite.Tests != 1 || suite.Failures != 0 {
+			t.Errorf("Expected 1 test without failures, got %d tests and %d failures", suite.Tests, suite.Failures)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// csvHeader lists the columns of the CSV report
+var csvHeader = []string{"suite", "classname", "name", "status", "duration", "device", "message"}
+
+// MarshalCSV serializes the test suites as CSV with one row per test case
+func MarshalCSV(testSuites JUnitTestSuites) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(csvHeader); err != nil {
+		return nil, fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	var writeErr error
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		for _, tc := range suite.TestCases {
+			if writeErr != nil {
+				return
+			}
+			status, message := testCaseStatus(tc)
+			writeErr = w.Write([]string{
+				suite.Name,
+				tc.Classname,
+				tc.Name,
+				status,
+				strconv.FormatFloat(tc.Time, 'f', -1, 64),
+				strings.Join(tc.Devices, ", "),
+				message,
+			})
+		}
+	})
+	if writeErr != nil {
+		return nil, fmt.Errorf("failed to write CSV record: %w", writeErr)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write CSV: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// testCaseStatus returns the outcome of a test case and its related message
+func testCaseStatus(tc JUnitTestCase) (string, string) {
+	switch {
+	case tc.Failure != nil:
+		return "failed", tc.Failure.Message
+	case tc.Error != nil:
+		return "error", tc.Error.Message
+	case tc.Skipped != nil:
+		return "skipped", tc.Skipped.Message
+	default:
+		return "passed", ""
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMarshalCSV(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name: "LoginTests",
+				TestCases: []JUnitTestCase{
+					{Name: "testLogin()", Classname: "AppTests.LoginTests", Time: 0.5, Devices: []string{"iPhone 15"}},
+					{
+						Name:      "testLogout()",
+						Classname: "AppTests.LoginTests",
+						Time:      1.25,
+						Devices:   []string{"iPhone 15"},
+						Failure:   &JUnitFailure{Message: "XCTAssertEqual failed: (\"a,b\") is not equal to (\"c\")"},
+					},
+					{Name: "testSSO()", Classname: "AppTests.LoginTests", Skipped: &JUnitSkipped{Message: "No network"}},
+				},
+			},
+		},
+	}
+
+	csvData, err := MarshalCSV(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalCSV returned error: %v", err)
+	}
+
+	expected := `suite,classname,name,status,duration,device,message
+LoginTests,AppTests.LoginTests,testLogin(),passed,0.5,iPhone 15,
+LoginTests,AppTests.LoginTests,testLogout(),failed,1.25,iPhone 15,"XCTAssertEqual failed: (""a,b"") is not equal to (""c"")"
+LoginTests,AppTests.LoginTests,testSSO(),skipped,0,,No network
+`
+	if string(csvData) != expected {
+		t.Errorf("Expected CSV:\n%s\ngot:\n%s", expected, csvData)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+)
+
+// FlakyReport returns the test cases which failed and then passed on retry.
+// Each test case gets a note about the number of attempts it took to pass.
+func FlakyReport(testSuites JUnitTestSuites) JUnitTestSuites {
+	flaky := filterSuites(testSuites.TestSuites, isFlaky)
+	walkSuites(flaky, func(suite *JUnitTestSuite) {
+		for i := range suite.TestCases {
+			tc := &suite.TestCases[i]
+			note := fmt.Sprintf("Passed after %d attempts", len(tc.FlakyFailures)+1)
+			if tc.SystemOut != "" {
+				note += "\n" + string(tc.SystemOut)
+			}
+			tc.SystemOut = cdata(note)
+		}
+	})
+
+	return JUnitTestSuites{TestSuites: flaky}
+}
+
+// countFlakyTests returns the number of test cases which passed on retry
+func countFlakyTests(testSuites JUnitTestSuites) int {
+	count := 0
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		for _, tc := range suite.TestCases {
+			if isFlaky(tc) {
+				count++
+			}
+		}
+	})
+	return count
+}
+
+func isFlaky(tc JUnitTestCase) bool {
+	return tc.Failure == nil && tc.Error == nil && len(tc.FlakyFailures) > 0
+}
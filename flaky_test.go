@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlakyReport(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "retried_tests.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	if count := countFlakyTests(testSuites); count != 1 {
+		t.Errorf("Expected 1 flaky test, got %d", count)
+	}
+
+	report := FlakyReport(testSuites)
+	if len(report.TestSuites) != 1 {
+		t.Fatalf("Expected 1 suite, got %d", len(report.TestSuites))
+	}
+
+	suite := report.TestSuites[0]
+	if suite.Tests != 1 || suite.Failures != 0 || len(suite.TestCases) != 1 {
+		t.Fatalf("Expected only the flaky test case, got %+v", suite)
+	}
+
+	tc := suite.TestCases[0]
+	if tc.Name != "testPayment()" {
+		t.Errorf("Expected testPayment() to be flaky, got %s", tc.Name)
+	}
+	if len(tc.FlakyFailures) != 1 || tc.FlakyFailures[0].Message != "CheckoutTests.swift:31: Failed to find the Pay button" {
+		t.Errorf("Unexpected flaky failures: %+v", tc.FlakyFailures)
+	}
+	if tc.SystemOut != "Passed after 2 attempts" {
+		t.Errorf("Unexpected attempts note: %q", tc.SystemOut)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// htmlReportTemplate renders a self-contained report, without any external
+// JavaScript or CSS, so it works as a static build artifact
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Test Report</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { text-align: left; padding: 4px 12px; border-bottom: 1px solid #ddd; }
+.failed { color: #c0392b; }
+.skipped { color: #7f8c8d; }
+.passed { color: #27ae60; }
+pre { background: #f6f6f6; padding: 8px; white-space: pre-wrap; }
+figure img { max-width: 320px; border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>Test Report</h1>
+<p>{{.Tests}} tests, {{.Failures}} failures, {{.Errors}} errors, {{.Skipped}} skipped in {{printf "%.3f" .Time}}s</p>
+<table>
+<tr><th>Suite</th><th>Tests</th><th>Failures</th><th>Errors</th><th>Time (s)</th></tr>
+{{- range .Suites}}
+<tr><td>{{.Name}}</td><td>{{.Tests}}</td><td{{if .Failures}} class="failed"{{end}}>{{.Failures}}</td><td{{if .Errors}} class="failed"{{end}}>{{.Errors}}</td><td>{{printf "%.3f" .Time}}</td></tr>
+{{- end}}
+</table>
+{{- if .FailedCases}}
+<h2>Failures</h2>
+{{- range .FailedCases}}
+<details>
+<summary class="failed">{{.Classname}}.{{.Name}}: {{.Message}}</summary>
+<pre>{{.Content}}</pre>
+{{- range .Images}}
+<figure><img src="{{.Src}}" alt="{{.Name}}"><figcaption>{{.Name}}</figcaption></figure>
+{{- end}}
+</details>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// htmlReport is the data rendered by htmlReportTemplate
+type htmlReport struct {
+	Tests       int
+	Failures    int
+	Errors      int
+	Skipped     int
+	Time        float64
+	Suites      []JUnitTestSuite
+	FailedCases []htmlFailedCase
+}
+
+// htmlFailedCase is a failed test case of the HTML report
+type htmlFailedCase struct {
+	Name      string
+	Classname string
+	Message   string
+	Content   string
+	Images    []htmlImage
+}
+
+// htmlImage is an image attachment inlined into the system-out of a test case
+type htmlImage struct {
+	Name string
+	Src  template.URL
+}
+
+// inlinedImagePattern matches the notes of the inlined image attachments,
+// see inlineAttachmentNote
+var inlinedImagePattern = regexp.MustCompile(`^Attachment (.+): (data:image/[a-z0-9.+-]+;base64,[A-Za-z0-9+/]+=*)$`)
+
+// inlinedImages returns the images inlined into the system-out of a test case
+func inlinedImages(tc JUnitTestCase) []htmlImage {
+	var images []htmlImage
+	for _, line := range strings.Split(string(tc.SystemOut), "\n") {
+		if match := inlinedImagePattern.FindStringSubmatch(line); match != nil {
+			// The data URI is checked to hold a base64 encoded image only
+			images = append(images, htmlImage{Name: match[1], Src: template.URL(match[2])})
+		}
+	}
+	return images
+}
+
+// RenderHTMLReport renders a human readable HTML summary of the test suites
+// and their failures, with the images inlined into their system-out
+func RenderHTMLReport(testSuites JUnitTestSuites) ([]byte, error) {
+	report := htmlReport{}
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		report.Suites = append(report.Suites, *suite)
+		for _, tc := range suite.TestCases {
+			report.Tests++
+			report.Time += tc.Time
+			switch {
+			case tc.Failure != nil:
+				report.Failures++
+				report.FailedCases = append(report.FailedCases, htmlFailedCase{
+					Name:      tc.Name,
+					Classname: tc.Classname,
+					Message:   tc.Failure.Message,
+					Content:   tc.Failure.Content,
+					Images:    inlinedImages(tc),
+				})
+			case tc.Error != nil:
+				report.Errors++
+				report.FailedCases = append(report.FailedCases, htmlFailedCase{
+					Name:      tc.Name,
+					Classname: tc.Classname,
+					Message:   tc.Error.Message,
+					Content:   tc.Error.Content,
+					Images:    inlinedImages(tc),
+				})
+			case tc.Skipped != nil:
+				report.Skipped++
+			}
+		}
+	})
+
+	var buf bytes.Buffer
+	if err := htmlReportTemplate.Execute(&buf, report); err != nil {
+		return nil, fmt.Errorf("failed to render HTML report: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderHTMLReport(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name:     "LoginTests",
+				Tests:    2,
+				Failures: 1,
+				Time:     1.5,
+				TestCases: []JUnitTestCase{
+					{Name: "testLogin()", Classname: "AppTests.LoginTests", Time: 0.5},
+					{
+						Name:      "testLogout()",
+						Classname: "AppTests.LoginTests",
+						Time:      1,
+						Failure:   &JUnitFailure{Message: "XCTAssertEqual failed: <a> != <b>", Content: "XCTAssertEqual failed: <a> != <b>"},
+					},
+				},
+			},
+		},
+	}
+
+	html, err := RenderHTMLReport(testSuites)
+	if err != nil {
+		t.Fatalf("RenderHTMLReport returned error: %v", err)
+	}
+
+	report := string(html)
+	for _, expected := range []string{
+		"2 tests, 1 failures, 0 errors, 0 skipped in 1.500s",
+		"<td>LoginTests</td>",
+		"AppTests.LoginTests.testLogout(): XCTAssertEqual failed: &lt;a&gt; != &lt;b&gt;",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, report)
+		}
+	}
+
+	for _, external := range []string{"<script", "<link", "src="} {
+		if strings.Contains(report, external) {
+			t.Errorf("Expected a self-contained report, found %q", external)
+		}
+	}
+}
+
+func TestRenderHTMLReportInlinedImages(t *testing.T) {
+	dataURI := "data:image/png;base64,iVBORw0KGgo="
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name: "LoginTests",
+				TestCases: []JUnitTestCase{
+					{
+						Name:      "testLogout()",
+						Classname: "AppTests.LoginTests",
+						Failure:   &JUnitFailure{Message: "Logout button not found"},
+						SystemOut: cdata("Tap Logout\nAttachment Screenshot.png: " + dataURI + "\nAttachment log.txt: attachments/log.txt"),
+					},
+				},
+			},
+		},
+	}
+
+	html, err := RenderHTMLReport(testSuites)
+	if err != nil {
+		t.Fatalf("RenderHTMLReport returned error: %v", err)
+	}
+
+	report := string(html)
+	if !strings.Contains(report, `<img src="`+dataURI+`" alt="Screenshot.png">`) {
+		t.Errorf("Expected the inlined image, got:\n%s", report)
+	}
+	if strings.Count(report, "<img") != 1 {
+		t.Errorf("Expected only the inlined image to be rendered, got:\n%s", report)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Open Test Reporting (JUnit 5) namespaces
+const (
+	openTestReportingCoreNamespace   = "https://schemas.opentest4j.org/reporting/core/0.2.0"
+	openTestReportingEventsNamespace = "https://schemas.opentest4j.org/reporting/events/0.2.0"
+)
+
+// Open Test Reporting result statuses
+const (
+	otrStatusSuccessful = "SUCCESSFUL"
+	otrStatusSkipped    = "SKIPPED"
+	otrStatusFailed     = "FAILED"
+	otrStatusErrored    = "ERRORED"
+)
+
+// otrEvents represents the root of an Open Test Reporting event stream
+type otrEvents struct {
+	XMLName     xml.Name   `xml:"e:events"`
+	Namespace   string     `xml:"xmlns,attr"`
+	EventsXMLNS string     `xml:"xmlns:e,attr"`
+	Events      []otrEvent `xml:"events"`
+}
+
+// otrEvent represents a started or finished event
+type otrEvent struct {
+	XMLName  xml.Name
+	ID       string     `xml:"id,attr"`
+	ParentID string     `xml:"parentId,attr,omitempty"`
+	Name     string     `xml:"name,attr,omitempty"`
+	Time     string     `xml:"time,attr"`
+	Result   *otrResult `xml:"result,omitempty"`
+}
+
+// otrResult represents the result of a finished event
+type otrResult struct {
+	Status string `xml:"status,attr"`
+	Reason string `xml:"reason,omitempty"`
+}
+
+// MarshalOpenTestReporting serializes the test suites as an Open Test
+// Reporting event stream, the format consumed by JUnit 5 tooling
+func MarshalOpenTestReporting(testSuites JUnitTestSuites) ([]byte, error) {
+	w := &otrWriter{}
+	for _, suite := range testSuites.TestSuites {
+		w.addSuite(suite, "")
+	}
+
+	xmlData, err := xml.MarshalIndent(otrEvents{
+		Namespace:   openTestReportingCoreNamespace,
+		EventsXMLNS: openTestReportingEventsNamespace,
+		Events:      w.events,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Open Test Reporting XML: %w", err)
+	}
+
+	return append([]byte(xml.Header), xmlData...), nil
+}
+
+// otrWriter collects the events of the test suites
+type otrWriter struct {
+	lastID int
+	events []otrEvent
+}
+
+func (w *otrWriter) nextID() string {
+	w.lastID++
+	return strconv.Itoa(w.lastID)
+}
+
+func (w *otrWriter) started(id, parentID, name string, t time.Time) {
+	w.events = append(w.events, otrEvent{
+		XMLName:  xml.Name{Local: "e:started"},
+		ID:       id,
+		ParentID: parentID,
+		Name:     name,
+		Time:     t.Format(time.RFC3339Nano),
+	})
+}
+
+func (w *otrWriter) finished(id string, t time.Time, result otrResult) {
+	w.events = append(w.events, otrEvent{
+		XMLName: xml.Name{Local: "e:finished"},
+		ID:      id,
+		Time:    t.Format(time.RFC3339Nano),
+		Result:  &result,
+	})
+}
+
+// addSuite emits the events of a suite, its test cases and nested suites. Test
+// cases are laid out one after the other starting at the suite's timestamp.
+// Returns the status of the suite.
+func (w *otrWriter) addSuite(suite JUnitTestSuite, parentID string) string {
+	start, err := time.Parse(time.RFC3339, suite.Timestamp)
+	if err != nil {
+		start = time.Now()
+	}
+
+	id := w.nextID()
+	w.started(id, parentID, suite.Name, start)
+
+	status := otrStatusSuccessful
+	current := start
+	for _, tc := range suite.TestCases {
+		caseID := w.nextID()
+		w.started(caseID, id, tc.Name, current)
+		current = current.Add(secondsToDuration(tc.Time))
+
+		result := testCaseOTRResult(tc)
+		if result.Status == otrStatusFailed || result.Status == otrStatusErrored {
+			status = otrStatusFailed
+		}
+		w.finished(caseID, current, result)
+	}
+
+	for _, child := range suite.TestSuites {
+		if w.addSuite(child, id) == otrStatusFailed {
+			status = otrStatusFailed
+		}
+	}
+
+	w.finished(id, start.Add(secondsToDuration(suite.Time)), otrResult{Status: status})
+	return status
+}
+
+func testCaseOTRResult(tc JUnitTestCase) otrResult {
+	switch {
+	case tc.Failure != nil:
+		return otrResult{Status: otrStatusFailed, Reason: tc.Failure.Message}
+	case tc.Error != nil:
+		return otrResult{Status: otrStatusErrored, Reason: tc.Error.Message}
+	case tc.Skipped != nil:
+		return otrResult{Status: otrStatusSkipped, Reason: tc.Skipped.Message}
+	default:
+		return otrResult{Status: otrStatusSuccessful}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
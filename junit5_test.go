@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarshalOpenTestReporting(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name:      "LoginTests",
+				Time:      1.5,
+				Timestamp: "2024-05-01T10:00:00Z",
+				TestCases: []JUnitTestCase{
+					{Name: "testLogin()", Time: 0.5},
+					{Name: "testLogout()", Time: 1, Failure: &JUnitFailure{Message: "XCTAssertTrue failed"}},
+				},
+			},
+		},
+	}
+
+	xmlData, err := MarshalOpenTestReporting(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalOpenTestReporting returned error: %v", err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<e:events xmlns="https://schemas.opentest4j.org/reporting/core/0.2.0" xmlns:e="https://schemas.opentest4j.org/reporting/events/0.2.0">
+  <e:started id="1" name="LoginTests" time="2024-05-01T10:00:00Z"></e:started>
+  <e:started id="2" parentId="1" name="testLogin()" time="2024-05-01T10:00:00Z"></e:started>
+  <e:finished id="2" time="2024-05-01T10:00:00.5Z">
+    <result status="SUCCESSFUL"></result>
+  </e:finished>
+  <e:started id="3" parentId="1" name="testLogout()" time="2024-05-01T10:00:00.5Z"></e:started>
+  <e:finished id="3" time="2024-05-01T10:00:01.5Z">
+    <result status="FAILED">
+      <reason>XCTAssertTrue failed</reason>
+    </result>
+  </e:finished>
+  <e:finished id="1" time="2024-05-01T10:00:01.5Z">
+    <result status="FAILED"></result>
+  </e:finished>
+</e:events>`
+	if strings.TrimSpace(string(xmlData)) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, xmlData)
+	}
+}
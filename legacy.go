@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The legacy xcresulttool (Xcode 15 and older) only exports the object graph of
+// the bundle with `xcresulttool get --format json`. The test results live in
+// the ActionTestPlanRunSummaries objects referenced by the test actions, which
+// legacyTestResultsJSON collects under the "testPlanSummaries" key:
+//
+//	testPlanSummaries → summaries → testableSummaries → tests → subtests …
+//
+// Every testable (test bundle) becomes a test suite, its test groups (test
+// classes) make up the classname of the test cases.
+
+// ConvertLegacyXCResultJSONToTestSuites parses the legacy xcresulttool object
+// graph into the JUnit model
+func ConvertLegacyXCResultJSONToTestSuites(jsonData []byte, opts ConvertOptions) (JUnitTestSuites, error) {
+	return ConvertMixedXCResultJSONToTestSuites(nil, jsonData, opts)
+}
+
+// mergeLegacyXCResultJSON merges the test plan run summaries of several
+// bundles exported by legacyTestResultsJSON
+func mergeLegacyXCResultJSON(jsonDocs ...[]byte) ([]byte, error) {
+	if len(jsonDocs) == 1 {
+		return jsonDocs[0], nil
+	}
+
+	var startTime float64
+	summaries := []interface{}{}
+	for i, jsonData := range jsonDocs {
+		var root map[string]interface{}
+		if err := json.Unmarshal(jsonData, &root); err != nil {
+			return nil, fmt.Errorf("failed to parse legacy XCResult JSON #%d: %w", i+1, err)
+		}
+
+		if start := getFloatByPath(root, []string{"startTime"}); start > 0 && (startTime == 0 || start < startTime) {
+			startTime = start
+		}
+		for _, summary := range legacyValues(root["testPlanSummaries"]) {
+			summaries = append(summaries, summary)
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"startTime":         startTime,
+		"testPlanSummaries": summaries,
+	})
+}
+
+// isLegacyXCResultJSON reports whether the JSON is a legacy object graph
+// exported by legacyTestResultsJSON rather than test results
+func isLegacyXCResultJSON(jsonData []byte) bool {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(jsonData, &root); err != nil {
+		return false
+	}
+	_, isLegacy := root["testPlanSummaries"]
+	return isLegacy
+}
+
+// legacyDateLayout is the format of the dates in the legacy object graph
+const legacyDateLayout = "2006-01-02T15:04:05.000-0700"
+
+// parseLegacyDate returns the date in seconds since the epoch, or 0 if invalid
+func parseLegacyDate(value string) float64 {
+	date, err := time.Parse(legacyDateLayout, value)
+	if err != nil {
+		return 0
+	}
+	return float64(date.UnixNano()) / float64(time.Second)
+}
+
+// processXCResultJSON parses the legacy xcresulttool object graph with the
+// default conversion options. The suite times are the durations reported by
+// the testables, which the legacy parser always used.
+func processXCResultJSON(jsonData []byte) (JUnitTestSuites, error) {
+	return ConvertLegacyXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+}
+
+// processLegacyRoot adds the test cases of every testable of the object graph
+func (c *converter) processLegacyRoot(root map[string]interface{}) {
+	for _, runSummaries := range legacyValues(root["testPlanSummaries"]) {
+		for _, summary := range legacyValues(runSummaries["summaries"]) {
+			for _, testable := range legacyValues(summary["testableSummaries"]) {
+				c.processLegacyTestable(testable)
+			}
+		}
+	}
+}
+
+func (c *converter) processLegacyTestable(testable map[string]interface{}) {
+	name := c.outputSuiteName(getStringByPath(testable, []string{"name"}))
+	if name == "" {
+		name = c.outputSuiteName(getStringByPath(testable, []string{"targetName"}))
+	}
+	if name == "" {
+		name = "UnknownSuite"
+	}
+
+	// Every testable is a test bundle
+	c.bundle = name
+	defer func() { c.bundle = "" }()
+
+	if c.opts.GroupBy != GroupByClassname {
+		// A testable without test cases is reported as an empty suite
+		c.suite(name)
+	}
+	c.suiteDurations[name] += getFloatByPath(testable, []string{"duration"})
+	c.processLegacyTests(name, legacyValues(testable["tests"]), "")
+}
+
+// processLegacyTests adds the test cases found in the test groups to the suite
+// of the testable, or to the suites of their classnames
+func (c *converter) processLegacyTests(suiteName string, tests []map[string]interface{}, classname string) {
+	for _, test := range tests {
+		if subtests, isGroup := test["subtests"]; isGroup {
+			name := getStringByPath(test, []string{"name"})
+			if c.depth >= c.maxNodeDepth() {
+				c.warnf("test groups nested deeper than %d levels are dropped under %q", c.maxNodeDepth(), name)
+				continue
+			}
+			c.depth++
+			c.processLegacyTests(suiteName, legacyValues(subtests), buildClassName(classname, name))
+			c.depth--
+			continue
+		}
+		testCase := c.newLegacyTestCase(test, classname)
+		if !c.isIncluded(testCase) {
+			continue
+		}
+		if c.opts.GroupBy == GroupByClassname && testCase.Classname != "" {
+			c.suite(testCase.Classname).addTestCase(testCase)
+		} else {
+			c.suite(suiteName).addTestCase(testCase)
+		}
+	}
+}
+
+// newLegacyTestCase converts a test summary or test metadata object
+func (c *converter) newLegacyTestCase(test map[string]interface{}, classname string) JUnitTestCase {
+	name := getStringByPath(test, []string{"name"})
+	if c.opts.StripMethodParens {
+		name = stripMethodParens(name)
+	}
+
+	testCase := JUnitTestCase{
+		Name:       name,
+		Classname:  c.outputClassname(classname),
+		Time:       getFloatByPath(test, []string{"duration"}),
+		identifier: getStringByPath(test, []string{"identifier"}),
+	}
+
+	switch getStringByPath(test, []string{"testStatus"}) {
+	case "Failure":
+		failureMessage := defaultFailureMessage
+		content := failureMessage
+		if failures := legacyValues(test["failureSummaries"]); len(failures) > 0 {
+			failureMessage = getStringByPath(failures[0], []string{"message"})
+			var details []string
+			seen := map[string]bool{}
+			for _, failure := range failures {
+				if detail := legacyFailureMessage(failure); !seen[detail] {
+					seen[detail] = true
+					details = append(details, detail)
+				}
+			}
+			content = strings.Join(details, "\n")
+			if c.opts.LocationAttributes {
+				testCase.File = getStringByPath(failures[0], []string{"fileName"})
+				testCase.Line = getIntByPath(failures[0], []string{"lineNumber"})
+			}
+		}
+
+		c.setFailure(&testCase, failureMessage, content)
+	case "Skipped":
+		testCase.Skipped = &JUnitSkipped{
+			Message: getStringByPath(test, []string{"skipNoticeSummary", "message"}),
+		}
+	case expectedFailureResult:
+		message := getStringByPath(test, []string{"expectedFailures", "0", "failureReason"})
+		if issue := getStringByPath(test, []string{"expectedFailures", "0", "failureSummary", "message"}); issue != "" {
+			message = strings.TrimPrefix(message+": "+issue, ": ")
+		}
+		markExpectedFailure(&testCase, message)
+	}
+	c.limitFailureLength(&testCase)
+
+	return testCase
+}
+
+// legacyFailureMessage returns the message of a failure summary prefixed with
+// its file:line location, when reported
+func legacyFailureMessage(failure map[string]interface{}) string {
+	message := getStringByPath(failure, []string{"message"})
+	file := getStringByPath(failure, []string{"fileName"})
+	if file == "" {
+		return message
+	}
+	if line := getIntByPath(failure, []string{"lineNumber"}); line > 0 {
+		file += ":" + strconv.Itoa(line)
+	}
+	return file + ": " + message
+}
+
+// legacyValues returns the objects of a legacy array, which is either a plain
+// JSON array or an object holding the items under "_values". A single object
+// is returned as a one item array.
+func legacyValues(value interface{}) []map[string]interface{} {
+	if object, ok := value.(map[string]interface{}); ok {
+		values, isArray := object["_values"]
+		if !isArray {
+			return []map[string]interface{}{object}
+		}
+		value = values
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var objects []map[string]interface{}
+	for _, item := range items {
+		if object, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+// getValueByPath returns the value at the path, or nil if the path doesn't
+// exist. A key selects the field of an object, a number the item of an array
+// (plain or legacy "_values" array). Legacy values wrapped as {"_value": …}
+// are unwrapped along the way.
+func getValueByPath(m map[string]interface{}, path []string) interface{} {
+	var value interface{} = m
+	for _, key := range path {
+		switch current := unwrapLegacyValue(value).(type) {
+		case map[string]interface{}:
+			next, ok := current[key]
+			if !ok {
+				if items, isArray := current["_values"].([]interface{}); isArray {
+					next, ok = arrayItem(items, key)
+				}
+			}
+			if !ok {
+				return nil
+			}
+			value = next
+		case []interface{}:
+			next, ok := arrayItem(current, key)
+			if !ok {
+				return nil
+			}
+			value = next
+		default:
+			return nil
+		}
+	}
+	return unwrapLegacyValue(value)
+}
+
+// unwrapLegacyValue returns the value of a legacy {"_value": …} wrapper object
+func unwrapLegacyValue(value interface{}) interface{} {
+	if object, ok := value.(map[string]interface{}); ok {
+		if wrapped, isWrapped := object["_value"]; isWrapped {
+			return wrapped
+		}
+	}
+	return value
+}
+
+// arrayItem returns the item at the index given as a path key
+func arrayItem(items []interface{}, key string) (interface{}, bool) {
+	index, err := strconv.Atoi(key)
+	if err != nil || index < 0 || index >= len(items) {
+		return nil, false
+	}
+	return items[index], true
+}
+
+// getStringByPath returns the string at the path, or "" if it isn't a string
+func getStringByPath(m map[string]interface{}, path []string) string {
+	value, _ := getValueByPath(m, path).(string)
+	return value
+}
+
+// getFloatByPath returns the number at the path, or 0 if it isn't a finite
+// number. The legacy format reports numbers as strings, which are parsed.
+func getFloatByPath(m map[string]interface{}, path []string) float64 {
+	var number float64
+	switch value := getValueByPath(m, path).(type) {
+	case float64:
+		number = value
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0
+		}
+		number = parsed
+	}
+
+	if math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0
+	}
+	return number
+}
+
+// getIntByPath returns the number at the path truncated to an int, or 0 if it
+// isn't a number or doesn't fit into an int
+func getIntByPath(m map[string]interface{}, path []string) int {
+	number := getFloatByPath(m, path)
+	if number > math.MaxInt32 || number < math.MinInt32 {
+		return 0
+	}
+	return int(number)
+}
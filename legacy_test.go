@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConvertLegacyXCResultJSON(t *testing.T) {
+	jsonData, err := os.ReadFile(filepath.Join("testdata", "legacy_test_plan_summaries.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	testSuites, err := ConvertLegacyXCResultJSONToTestSuites(jsonData, ConvertOptions{StripMethodParens: true})
+	if err != nil {
+		t.Fatalf("ConvertLegacyXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	if len(testSuites.TestSuites) != 1 {
+		t.Fatalf("Expected 1 test suite, got %d", len(testSuites.TestSuites))
+	}
+	suite := testSuites.TestSuites[0]
+	if suite.Name != "AppTests" {
+		t.Errorf("Expected suite AppTests, got %s", suite.Name)
+	}
+	if suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 {
+		t.Errorf("Expected 3 tests, 1 failure and 1 skipped, got %d, %d and %d", suite.Tests, suite.Failures, suite.Skipped)
+	}
+	if suite.Timestamp != time.Unix(1714557600, 0).Format(time.RFC3339) {
+		t.Errorf("Expected the timestamp of the run start, got %s", suite.Timestamp)
+	}
+
+	cases := make(map[string]JUnitTestCase)
+	for _, tc := range suite.TestCases {
+		cases[tc.Name] = tc
+	}
+
+	logout, ok := cases["testLogout"]
+	if !ok {
+		t.Fatalf("Expected testLogout test case, got %v", suite.TestCases)
+	}
+	if logout.Classname != "AppTests.xctest.LoginTests" {
+		t.Errorf("Expected classname AppTests.xctest.LoginTests, got %s", logout.Classname)
+	}
+	if logout.Time != 0.25 {
+		t.Errorf("Expected time 0.25, got %f", logout.Time)
+	}
+	if logout.Failure == nil {
+		t.Fatalf("Expected a failure")
+	}
+	if logout.Failure.Message != "XCTAssertTrue failed" {
+		t.Errorf("Expected failure message XCTAssertTrue failed, got %s", logout.Failure.Message)
+	}
+	if logout.Failure.Content != "/src/AppTests/LoginTests.swift:42: XCTAssertTrue failed" {
+		t.Errorf("Unexpected failure content: %s", logout.Failure.Content)
+	}
+
+	if skipped := cases["testBiometrics"].Skipped; skipped == nil || skipped.Message != "Requires a device" {
+		t.Errorf("Expected testBiometrics to be skipped with a reason, got %v", skipped)
+	}
+}
+
+func TestMergeLegacyXCResultJSON(t *testing.T) {
+	shard1 := []byte(`{"startTime": 200, "testPlanSummaries": [{"summaries": [{"testableSummaries": [
+		{"name": "AppTests", "tests": [{"name": "testA", "testStatus": "Success", "duration": 1}]}
+	]}]}]}`)
+	shard2 := []byte(`{"startTime": 100, "testPlanSummaries": [{"summaries": [{"testableSummaries": [
+		{"name": "AppTests", "tests": [{"name": "testB", "testStatus": "Failure", "duration": 2}]}
+	]}]}]}`)
+
+	merged, err := mergeLegacyXCResultJSON(shard1, shard2)
+	if err != nil {
+		t.Fatalf("mergeLegacyXCResultJSON returned error: %v", err)
+	}
+
+	testSuites, err := processXCResultJSON(merged)
+	if err != nil {
+		t.Fatalf("processXCResultJSON returned error: %v", err)
+	}
+	if len(testSuites.TestSuites) != 1 {
+		t.Fatalf("Expected the suites to be combined, got %d suites", len(testSuites.TestSuites))
+	}
+	suite := testSuites.TestSuites[0]
+	if suite.Tests != 2 || suite.Failures != 1 {
+		t.Errorf("Expected 2 tests and 1 failure, got %d and %d", suite.Tests, suite.Failures)
+	}
+	if suite.Timestamp != time.Unix(100, 0).Format(time.RFC3339) {
+		t.Errorf("Expected the earliest start time, got %s", suite.Timestamp)
+	}
+}
+
+func TestParseLegacyDate(t *testing.T) {
+	if got := parseLegacyDate("2024-05-01T10:00:00.500+0000"); got != 1714557600.5 {
+		t.Errorf("Expected 1714557600.5, got %f", got)
+	}
+	if got := parseLegacyDate("yesterday"); got != 0 {
+		t.Errorf("Expected 0 for an invalid date, got %f", got)
+	}
+}
+
+func TestIsLegacyXCResultJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected bool
+	}{
+		{"legacy object graph", `{"startTime": 0, "testPlanSummaries": []}`, true},
+		{"test results", `{"devices": [], "testNodes": []}`, false},
+		{"not an object", `[]`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLegacyXCResultJSON([]byte(tt.json)); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHelperFunctionsLegacyValues(t *testing.T) {
+	var testMap map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+  "name": {"_type": {"_name": "String"}, "_value": "AppTests"},
+  "duration": {"_type": {"_name": "Double"}, "_value": " 1.25 "},
+  "lineNumber": {"_type": {"_name": "Int"}, "_value": "42"},
+  "tooLarge": 1e300,
+  "notANumber": "NaN",
+  "summaries": {
+    "_values": [
+      {"testableSummaries": {"_values": [{"name": {"_value": "First"}}, {"name": {"_value": "Second"}}]}}
+    ]
+  },
+  "plain": ["a", "b"],
+  "scalar": "value"
+}`), &testMap); err != nil {
+		t.Fatalf("Failed to parse test JSON: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"wrapped string", getStringByPath(testMap, []string{"name"}), "AppTests"},
+		{"wrapped numeric string", getFloatByPath(testMap, []string{"duration"}), 1.25},
+		{"wrapped int string", getIntByPath(testMap, []string{"lineNumber"}), 42},
+		{"index into legacy arrays", getStringByPath(testMap, []string{"summaries", "0", "testableSummaries", "1", "name"}), "Second"},
+		{"index into plain array", getStringByPath(testMap, []string{"plain", "1"}), "b"},
+		{"index out of range", getValueByPath(testMap, []string{"plain", "2"}), nil},
+		{"negative index", getValueByPath(testMap, []string{"plain", "-1"}), nil},
+		{"path through a scalar", getValueByPath(testMap, []string{"scalar", "nested"}), nil},
+		{"empty path", getValueByPath(map[string]interface{}{"_value": "x"}, nil), "x"},
+		{"int overflow", getIntByPath(testMap, []string{"tooLarge"}), 0},
+		{"NaN", getFloatByPath(testMap, []string{"notANumber"}), 0.0},
+		{"nil map", getStringByPath(nil, []string{"name"}), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, tt.got)
+			}
+		})
+	}
+}
+
+func TestConvertMixedXCResultJSON(t *testing.T) {
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "name": "AppTests",
+      "nodeType": "Unit test bundle",
+      "children": [
+        {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed", "duration": "1s"}
+      ]
+    }
+  ]
+}`)
+	legacyJSONData := []byte(`{"testPlanSummaries": [{"summaries": [{"testableSummaries": [
+		{"name": "LegacyTests", "tests": [{"name": "testOld()", "testStatus": "Failure", "duration": 2}]}
+	]}]}]}`)
+
+	testSuites, err := ConvertMixedXCResultJSONToTestSuites(jsonData, legacyJSONData, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertMixedXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	if len(testSuites.TestSuites) != 2 {
+		t.Fatalf("Expected 2 test suites, got %d", len(testSuites.TestSuites))
+	}
+	legacy, current := testSuites.TestSuites[0], testSuites.TestSuites[1]
+	if legacy.Name != "LegacyTests" || legacy.Failures != 1 {
+		t.Errorf("Expected LegacyTests with 1 failure, got %s with %d", legacy.Name, legacy.Failures)
+	}
+	if current.Name != "LoginTests" || current.Tests != 1 {
+		t.Errorf("Expected LoginTests with 1 test, got %s with %d", current.Name, current.Tests)
+	}
+}
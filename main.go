@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-utils/log"
@@ -13,82 +25,1516 @@ import (
 
 // Config holds the step configuration
 type Config struct {
-	XCResultPath  string `env:"xcresult_path,required"`
-	OutputDir     string `env:"output_dir,required"`
-	JUnitFilename string `env:"junit_filename,required"`
-	Verbose       string `env:"verbose"`
+	XCResultPath       string `env:"xcresult_path"`
+	XCResultPathIsGlob bool   `env:"xcresult_path_is_glob"`
+	JSONInputPath      string `env:"json_input_path"`
+	OutputDir          string `env:"output_dir,required"`
+	JUnitFilename      string `env:"junit_filename,required"`
+	Verbose            bool   `env:"verbose"`
+	Indent             bool   `env:"indent"`
+	MaxTestCases       int    `env:"max_test_cases"`
+	OutputFormat       string `env:"output_format"`
+
+	PreserveHierarchy       bool   `env:"preserve_hierarchy"`
+	CollapseSingletonSuites bool   `env:"collapse_singleton_suites"`
+	CollapsedSuiteName      string `env:"collapsed_suite_name"`
+	Flatten                 bool   `env:"flatten"`
+	FlattenedSuiteName      string `env:"flattened_suite_name"`
+	SourceRootPath          string `env:"source_root_path"`
+	FlakyReport             bool   `env:"flaky_report"`
+	EmitHTMLReport          bool   `env:"emit_html_report"`
+	StripMethodParens       bool   `env:"strip_method_parens"`
+	ClassnamePrefixStrip    string `env:"classname_prefix_strip"`
+	ClassnameReplaceSpaces  bool   `env:"classname_replace_spaces"`
+	ReportPortalCompat      bool   `env:"report_portal_compat"`
+	SuiteTimeSource         string `env:"suite_time_source"`
+	SplitBySuite            bool   `env:"split_by_suite"`
+	Append                  bool   `env:"append"`
+	SuiteIDs                bool   `env:"suite_ids"`
+	MaxFailureLength        int    `env:"max_failure_length"`
+	TagProperties           bool   `env:"tag_properties"`
+	PerformanceMetrics      bool   `env:"performance_metrics"`
+	XCResultToolRetries     int    `env:"xcresulttool_retries"`
+	CommandTimeout          string `env:"command_timeout"`
+	OutputFileMode          string `env:"output_file_mode"`
+	SystemOutOn             string `env:"system_out_on"`
+	SanitizeNames           bool   `env:"sanitize_names"`
+	GroupBy                 string `env:"group_by"`
+	GzipOutput              bool   `env:"gzip_output"`
+	DisambiguateByDevice    bool   `env:"disambiguate_by_device"`
+	DumpJSONPath            string `env:"dump_json_path"`
+	EmptySuiteName          string `env:"empty_suite_name"`
+	OmitEmptySuite          bool   `env:"omit_empty_suite"`
+	TimeDecimals            int    `env:"time_decimals"`
+	MaxNodeDepth            int    `env:"max_node_depth"`
+	FailureContextLines     int    `env:"failure_context_lines"`
+	FailureElement          string `env:"failure_element"`
+	OutputVariableName      string `env:"output_variable_name"`
+	XcodePath               string `env:"xcode_path"`
+	XCResultToolPath        string `env:"xcresulttool_path"`
+	SuiteHostAttributes     bool   `env:"suite_host_attributes"`
+	LocationAttributes      bool   `env:"location_attributes"`
+	ExportAttachments       bool   `env:"export_attachments"`
+	InlineAttachments       bool   `env:"inline_attachments"`
+	InlineAttachmentMaxSize int    `env:"inline_attachment_max_size"`
+	IncludePattern          string `env:"include_pattern"`
+	ExcludePattern          string `env:"exclude_pattern"`
+	OmitSkipped             bool   `env:"omit_skipped"`
+
+	FailOnEmpty        bool `env:"fail_on_empty"`
+	Strict             bool `env:"strict"`
+	StrictValidation   bool `env:"strict_validation"`
+	ValidateOutput     bool `env:"validate_output"`
+	PrintConfigAndExit bool `env:"print_config_and_exit"`
+	PrintVersion       bool `env:"print_version"`
+	DryRun             bool `env:"dry_run"`
+
+	BitriseTestReports   bool   `env:"bitrise_test_reports"`
+	TestName             string `env:"test_name"`
+	BitriseTestResultDir string `env:"BITRISE_TEST_RESULT_DIR"`
 }
 
+// Supported output formats
+const (
+	outputFormatJUnit  = "junit"
+	outputFormatJUnit5 = "junit5"
+	outputFormatCSV    = "csv"
+	// outputFormatJSON writes a JSON summary of the results instead of the
+	// JUnit XML, outputFormatBoth writes both
+	outputFormatJSON = "json"
+	outputFormatBoth = "both"
+)
+
 func main() {
+	if isVersionCommand(os.Args[1:]) {
+		fmt.Println(stepVersion())
+		return
+	}
+
+	if err := run(); err != nil {
+		log.Errorf("%s", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// run runs the step, the returned error decides the exit code of the step
+func run() error {
 	var config Config
 	if err := stepconf.Parse(&config); err != nil {
-		failf("Failed to parse config: %s", err)
+		return invalidConfig("Failed to parse config: %s", err)
+	}
+	if config.PrintVersion {
+		fmt.Println(stepVersion())
+		return nil
 	}
 	stepconf.Print(config)
-	log.SetEnableDebugLog(config.Verbose == "yes")
+	log.SetEnableDebugLog(config.Verbose)
+
+	switch config.OutputFormat {
+	case "":
+		config.OutputFormat = outputFormatJUnit
+	case outputFormatJUnit, outputFormatJUnit5, outputFormatCSV, outputFormatJSON, outputFormatBoth:
+	default:
+		return invalidConfig("Invalid output format: %s, supported formats: %s, %s, %s, %s, %s", config.OutputFormat,
+			outputFormatJUnit, outputFormatJUnit5, outputFormatCSV, outputFormatJSON, outputFormatBoth)
+	}
+
+	switch config.SuiteTimeSource {
+	case "":
+		config.SuiteTimeSource = SuiteTimeFromXCResult
+	case SuiteTimeFromTestCases, SuiteTimeFromXCResult:
+	default:
+		return invalidConfig("Invalid suite time source: %s, supported sources: %s, %s", config.SuiteTimeSource, SuiteTimeFromTestCases, SuiteTimeFromXCResult)
+	}
+
+	switch config.SystemOutOn {
+	case "":
+		config.SystemOutOn = SystemOutFailures
+	case SystemOutAlways, SystemOutFailures, SystemOutNever:
+	default:
+		return invalidConfig("Invalid system-out setting: %s, supported values: %s, %s, %s", config.SystemOutOn, SystemOutAlways, SystemOutFailures, SystemOutNever)
+	}
+
+	// validate_output is an alias, the validation runs if either input enables it
+	if config.ValidateOutput {
+		config.StrictValidation = true
+	}
+
+	switch config.GroupBy {
+	case "":
+		config.GroupBy = GroupBySuite
+	case GroupBySuite, GroupByClassname:
+	default:
+		return invalidConfig("Invalid grouping: %s, supported values: %s, %s", config.GroupBy, GroupBySuite, GroupByClassname)
+	}
+
+	if config.SplitBySuite && config.OutputFormat != outputFormatJUnit && config.OutputFormat != outputFormatBoth {
+		return invalidConfig("Splitting the report by suite is only supported with the %s and %s output formats", outputFormatJUnit, outputFormatBoth)
+	}
+
+	if config.GzipOutput && config.SplitBySuite {
+		return invalidConfig("Compressing the report isn't supported when splitting the report by suite")
+	}
+
+	if config.Append && (config.SplitBySuite || config.OutputFormat != outputFormatJUnit && config.OutputFormat != outputFormatBoth) {
+		return invalidConfig("Appending to an existing report is only supported with the %s and %s output formats, without splitting by suite", outputFormatJUnit, outputFormatBoth)
+	}
 
-	// Check if XCResult path exists
-	if exists, err := pathutil.IsPathExists(config.XCResultPath); err != nil {
-		failf("Failed to check if XCResult path exists: %s", err)
-	} else if !exists {
-		failf("XCResult path does not exist: %s", config.XCResultPath)
+	marshalJUnit := MarshalJUnitXML
+	if !config.Indent {
+		marshalJUnit = MarshalCompactJUnitXML
 	}
 
-	// Create output directory if it doesn't exist
-	if exists, err := pathutil.IsPathExists(config.OutputDir); err != nil {
-		failf("Failed to check if output directory exists: %s", err)
-	} else if !exists {
-		if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
-			failf("Failed to create output directory: %s", err)
+	if config.MaxFailureLength < 0 {
+		return invalidConfig("Invalid max failure length: %d, must not be negative", config.MaxFailureLength)
+	}
+
+	if config.OutputVariableName == "" {
+		config.OutputVariableName = defaultOutputVariableName
+	} else if !variableNamePattern.MatchString(config.OutputVariableName) {
+		return invalidConfig("Invalid output variable name: %s, must be letters, digits and underscores, not starting with a digit", config.OutputVariableName)
+	}
+
+	switch config.FailureElement {
+	case "":
+		config.FailureElement = FailureElementFailure
+	case FailureElementFailure, FailureElementError:
+	default:
+		return invalidConfig("Invalid failure element: %s, supported elements: %s, %s", config.FailureElement, FailureElementFailure, FailureElementError)
+	}
+
+	switch {
+	case config.TimeDecimals == 0:
+		config.TimeDecimals = defaultTimeDecimals
+	case config.TimeDecimals < 0 || config.TimeDecimals > maxTimeDecimals:
+		return invalidConfig("Invalid time decimals: %d, must be between 1 and %d", config.TimeDecimals, maxTimeDecimals)
+	}
+
+	if config.FlattenedSuiteName == "" {
+		config.FlattenedSuiteName = defaultFlattenedSuiteName
+	}
+
+	if config.InlineAttachmentMaxSize < 0 {
+		return invalidConfig("Invalid inline attachment max size: %d, must not be negative", config.InlineAttachmentMaxSize)
+	} else if config.InlineAttachmentMaxSize == 0 {
+		config.InlineAttachmentMaxSize = defaultInlineAttachmentMaxSize
+	}
+
+	if config.MaxNodeDepth < 0 {
+		return invalidConfig("Invalid max node depth: %d, must not be negative", config.MaxNodeDepth)
+	} else if config.MaxNodeDepth == 0 {
+		config.MaxNodeDepth = defaultMaxNodeDepth
+	}
+
+	if config.FailureContextLines < 0 {
+		return invalidConfig("Invalid failure context lines: %d, must not be negative", config.FailureContextLines)
+	}
+
+	if config.XCResultToolRetries < 0 {
+		return invalidConfig("Invalid xcresulttool retries: %d, must not be negative", config.XCResultToolRetries)
+	}
+	xcresultToolRetries = config.XCResultToolRetries
+
+	if config.OutputFileMode != "" {
+		mode, err := parseFileMode(config.OutputFileMode)
+		if err != nil {
+			return invalidConfig("Invalid output file mode: %s", err)
 		}
+		outputFileMode = mode
 	}
 
-	// Convert XCResult to JSON
-	log.Infof("Converting XCResult to JSON...")
-	jsonData, err := convertXCResultToJSON(config.XCResultPath)
+	if config.CommandTimeout != "" {
+		timeout, err := time.ParseDuration(config.CommandTimeout)
+		if err != nil || timeout < 0 {
+			return invalidConfig("Invalid command timeout: %s, expected a duration like 10m, or 0 for no timeout", config.CommandTimeout)
+		}
+		xcresultToolTimeout = timeout
+	}
+
+	// Pin the toolchain, xcrun picks the selected Xcode otherwise
+	if config.XCResultToolPath != "" {
+		if err := validateExecutable(config.XCResultToolPath); err != nil {
+			return invalidConfig("Invalid xcresulttool path: %s", err)
+		}
+		xcresultToolPath = config.XCResultToolPath
+	} else if config.XcodePath != "" {
+		dir, err := resolveDeveloperDir(config.XcodePath)
+		if err != nil {
+			return invalidConfig("Invalid Xcode path: %s", err)
+		}
+		developerDir = dir
+	}
+	jsonDumpPath = config.DumpJSONPath
+
+	includePattern, err := compilePattern(config.IncludePattern)
+	if err != nil {
+		return invalidConfig("Invalid include_pattern: %s", err)
+	}
+	excludePattern, err := compilePattern(config.ExcludePattern)
 	if err != nil {
-		failf("Failed to convert XCResult to JSON: %s", err)
+		return invalidConfig("Invalid exclude_pattern: %s", err)
+	}
+
+	if filepath.IsAbs(config.JUnitFilename) {
+		log.Warnf("junit_filename is an absolute path, the report is written to %s instead of output_dir", config.JUnitFilename)
+	}
+
+	if config.PrintConfigAndExit {
+		printResolvedConfig(config)
+		return nil
+	}
+
+	// Create the output directories if they don't exist, before the conversion
+	// so an unwritable output fails early. A dry run doesn't write anything.
+	if !config.DryRun {
+		if err := prepareOutputDirs(config); err != nil {
+			return failure("Failed to prepare the output directory: %s", err)
+		}
+	}
+
+	options := Options{
+		Compact:            !config.Indent,
+		PerformanceMetrics: config.PerformanceMetrics,
+		// Test results which can't be read fail the step like the ones without tests
+		FailOnUnexpectedFormat: config.FailOnEmpty,
+	}
+
+	// Pre-exported JSON is converted as is, without any xcresult bundle
+	if config.JSONInputPath != "" {
+		log.Infof("Reading XCResult JSON from %s...", config.JSONInputPath)
+		if options.JSONInput, err = readJSONInput(config.JSONInputPath, os.Stdin); err != nil {
+			return invalidConfig("Failed to read XCResult JSON: %s", err)
+		}
+	} else {
+		options.XCResultPaths = splitXCResultPaths(config.XCResultPath)
+		if len(options.XCResultPaths) == 0 {
+			return invalidConfig("No XCResult path provided")
+		}
+
+		if config.XCResultPathIsGlob {
+			matches, err := expandXCResultGlobs(options.XCResultPaths)
+			if err != nil {
+				return failure("Failed to expand XCResult path pattern: %s", err)
+			}
+			log.Printf("Found %d xcresult bundle(s) matching %s", len(matches), strings.Join(options.XCResultPaths, ", "))
+			options.XCResultPaths = matches
+		}
+	}
+
+	options.ConvertOptions = ConvertOptions{
+		MaxTestCases:            config.MaxTestCases,
+		PreserveHierarchy:       config.PreserveHierarchy,
+		CollapseSingletonSuites: config.CollapseSingletonSuites,
+		CollapsedSuiteName:      config.CollapsedSuiteName,
+		Flatten:                 config.Flatten,
+		FlattenedSuiteName:      config.FlattenedSuiteName,
+		EmptySuiteName:          config.EmptySuiteName,
+		OmitEmptySuite:          config.OmitEmptySuite,
+		TimeDecimals:            config.TimeDecimals,
+		MaxNodeDepth:            config.MaxNodeDepth,
+		FailureContextLines:     config.FailureContextLines,
+		FailureElement:          config.FailureElement,
+		Strict:                  config.Strict,
+		SourceRootPath:          config.SourceRootPath,
+		StripMethodParens:       config.StripMethodParens,
+		ClassnamePrefixStrip:    config.ClassnamePrefixStrip,
+		ClassnameReplaceSpaces:  config.ClassnameReplaceSpaces,
+		ReportPortalCompat:      config.ReportPortalCompat,
+		SuiteTimeSource:         config.SuiteTimeSource,
+		SuiteHostAttributes:     config.SuiteHostAttributes,
+		LocationAttributes:      config.LocationAttributes,
+		IncludePattern:          includePattern,
+		ExcludePattern:          excludePattern,
+		OmitSkipped:             config.OmitSkipped,
+		SuiteIDs:                config.SuiteIDs,
+		MaxFailureLength:        config.MaxFailureLength,
+		OmitTags:                !config.TagProperties,
+		SystemOutOn:             config.SystemOutOn,
+		RawNames:                !config.SanitizeNames,
+		GroupBy:                 config.GroupBy,
+		DisambiguateByDevice:    config.DisambiguateByDevice,
+	}
+	if config.SuiteHostAttributes {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Warnf("Failed to get the hostname: %s", err)
+		}
+		options.Hostname = hostname
+	}
+
+	result, err := convertTestResults(options)
+	if errors.Is(err, errUnexpectedFormat) {
+		return &stepError{ExitCode: exitCodeNoTests, Err: fmt.Errorf("Conversion failed: %w, fail_on_empty is enabled", err)}
+	} else if err != nil {
+		logXCResultToolError(err)
+		return failure("Conversion failed: %s", err)
+	}
+	testSuites := result.testSuites
+	addVersionProperty(&testSuites, stepVersion())
+
+	// Reference the screenshots and logs of the failed tests, a bundle whose
+	// attachments can't be exported doesn't fail the step
+	if config.ExportAttachments && !config.DryRun {
+		log.Infof("Exporting the attachments of the failed tests...")
+		attachments := map[string][]testAttachment{}
+		for _, pth := range result.testResultsPaths {
+			dir := attachmentsDir(config.OutputDir, pth)
+			exported, err := exportAttachments(pth, dir)
+			if err != nil {
+				log.Warnf("Failed to export the attachments of %s: %s", pth, err)
+				continue
+			}
+			log.Printf("- %s: %d test(s) with attachments", dir, len(exported))
+			for identifier, testAttachments := range exported {
+				attachments[identifier] = append(attachments[identifier], testAttachments...)
+			}
+		}
+		if result.legacyBundles > 0 {
+			log.Warnf("The attachments of the bundles read in the legacy format aren't exported")
+		}
+		var inlineMaxSize int64
+		if config.InlineAttachments {
+			inlineMaxSize = int64(config.InlineAttachmentMaxSize)
+		}
+		addAttachmentNotes(&testSuites, attachments, config.OutputDir, inlineMaxSize)
 	}
 
-	// Convert JSON to JUnit XML
-	log.Infof("Converting JSON to JUnit XML...")
-	//log.Infof("JSON data: %s", string(jsonData))
-	junitXML, err := ConvertXCResultJSONToJUnitXML(jsonData)
+	// Accumulate the results of several conversions in one report
+	if config.Append {
+		outputPath := reportPath(config)
+		existing, err := readExistingReport(outputPath)
+		if err != nil {
+			return failure("Failed to append to the existing report: %s", err)
+		}
+		if existing != nil {
+			suites, cases := countSuitesAndCases(*existing)
+			log.Printf("Appending to the existing report %s with %d test suites and %d test cases", outputPath, suites, cases)
+			testSuites = AppendJUnitTestSuites(*existing, testSuites)
+			roundTimes(&testSuites, config.TimeDecimals)
+			if config.SuiteIDs {
+				// The appended suites would repeat the ids of the existing ones
+				assignSuiteIDs(&testSuites)
+			}
+		}
+	}
+
+	if err := validateSuiteCounts(testSuites); err != nil {
+		log.Warnf("The test counts of the report don't add up: %s", err)
+	}
+
+	totals := computeTotals(testSuites)
+	if totals.Tests == 0 {
+		log.Warnf("No test cases found in the XCResult")
+	}
+
+	junitXML, err := marshalJUnit(testSuites)
 	if err != nil {
-		failf("Failed to convert JSON to JUnit XML: %s", err)
+		return failure("Failed to convert JSON to JUnit XML: %s", err)
+	}
+
+	if config.StrictValidation {
+		if err := VerifyRoundTrip(junitXML, testSuites); err != nil {
+			return failure("Generated JUnit XML failed validation: %s", err)
+		}
+		suites, cases := countSuitesAndCases(testSuites)
+		log.Printf("Generated JUnit XML passed the round-trip validation: %d test suites, %d test cases", suites, cases)
+	}
+
+	// Write the report to file
+	outputPath := reportPath(config)
+	format := config.OutputFormat
+	report := junitXML
+	switch config.OutputFormat {
+	case outputFormatBoth:
+		format = outputFormatJUnit
+	case outputFormatJSON:
+		if report, err = MarshalSummaryJSON(testSuites); err != nil {
+			return failure("Failed to convert test results to a JSON summary: %s", err)
+		}
+	case outputFormatJUnit5:
+		if report, err = MarshalOpenTestReporting(testSuites); err != nil {
+			return failure("Failed to convert test results to Open Test Reporting XML: %s", err)
+		}
+	case outputFormatCSV:
+		if report, err = MarshalCSV(testSuites); err != nil {
+			return failure("Failed to convert test results to CSV: %s", err)
+		}
+	}
+
+	if config.DryRun {
+		log.Infof("Dry run, printing the %s report instead of writing it to: %s", format, outputPath)
+		if _, err := os.Stdout.Write(report); err != nil {
+			return failure("Failed to print report: %s", err)
+		}
+		return nil
+	}
+
+	if config.SplitBySuite {
+		log.Infof("Writing one %s report per test suite to: %s", format, config.OutputDir)
+		paths, err := writeSuiteFiles(testSuites, config.OutputDir, marshalJUnit)
+		if err != nil {
+			return failure("Failed to write test suite reports: %s", err)
+		}
+		for _, pth := range paths {
+			log.Printf("- %s", filepath.Base(pth))
+		}
+	} else if config.GzipOutput {
+		log.Infof("Writing gzip compressed %s report to file: %s", format, outputPath)
+		if err := writeGzipOutputFile(outputPath, report); err != nil {
+			return failure("Failed to write report to file: %s", err)
+		}
+	} else {
+		log.Infof("Writing %s report to file: %s", format, outputPath)
+		if err := writeOutputFile(outputPath, report); err != nil {
+			return failure("Failed to write report to file: %s", err)
+		}
+	}
+
+	// Write the JSON summary for tools which only need the counts
+	if config.OutputFormat == outputFormatJSON || config.OutputFormat == outputFormatBoth {
+		// The summary is the report itself with the json output format
+		pth := outputPath
+		if config.OutputFormat == outputFormatBoth {
+			pth = summaryPath(config)
+			summary, err := MarshalSummaryJSON(testSuites)
+			if err != nil {
+				return failure("Failed to convert test results to a JSON summary: %s", err)
+			}
+
+			log.Infof("Writing JSON summary to file: %s", pth)
+			if err := writeOutputFile(pth, summary); err != nil {
+				return failure("Failed to write JSON summary to file: %s", err)
+			}
+		}
+
+		if err := exportOutput("XCRESULT_TO_JUNIT_SUMMARY_PATH", pth); err != nil {
+			return failure("Failed to export output: %s", err)
+		}
 	}
 
-	// Write JUnit XML to file
-	outputPath := filepath.Join(config.OutputDir, config.JUnitFilename)
-	log.Infof("Writing JUnit XML to file: %s", outputPath)
-	if err := os.WriteFile(outputPath, junitXML, 0644); err != nil {
-		failf("Failed to write JUnit XML to file: %s", err)
+	// Export output, the directory of the suite reports when split by suite
+	exportedPath := outputPath
+	if config.SplitBySuite {
+		exportedPath = config.OutputDir
+	}
+	if err := exportOutput(config.OutputVariableName, exportedPath); err != nil {
+		return failure("Failed to export output: %s", err)
 	}
 
-	// Export output
-	if err := exportOutput("XCRESULT_TO_JUNIT_OUTPUT_PATH", outputPath); err != nil {
-		failf("Failed to export output: %s", err)
+	// Write the report of the tests which passed on retry
+	if config.FlakyReport {
+		flakyXML, err := marshalJUnit(FlakyReport(testSuites))
+		if err != nil {
+			return failure("Failed to convert flaky tests to JUnit XML: %s", err)
+		}
+
+		flakyPath := filepath.Join(config.OutputDir, "flaky.xml")
+		log.Infof("Writing flaky tests report to file: %s", flakyPath)
+		if err := writeOutputFile(flakyPath, flakyXML); err != nil {
+			return failure("Failed to write flaky tests report to file: %s", err)
+		}
+
+		if err := exportOutput("XCRESULT_FLAKY_REPORT_PATH", flakyPath); err != nil {
+			return failure("Failed to export output: %s", err)
+		}
+		if err := exportOutput("XCRESULT_FLAKY_TEST_COUNT", strconv.Itoa(countFlakyTests(testSuites))); err != nil {
+			return failure("Failed to export output: %s", err)
+		}
+	}
+
+	// Write the HTML report for reviewers who don't want raw XML
+	if config.EmitHTMLReport {
+		html, err := RenderHTMLReport(testSuites)
+		if err != nil {
+			return failure("Failed to render HTML report: %s", err)
+		}
+
+		reportFile := strings.TrimSuffix(outputPath, gzipExt)
+		htmlPath := strings.TrimSuffix(reportFile, filepath.Ext(reportFile)) + ".html"
+		log.Infof("Writing HTML report to file: %s", htmlPath)
+		if err := writeOutputFile(htmlPath, html); err != nil {
+			return failure("Failed to write HTML report to file: %s", err)
+		}
+
+		if err := exportOutput("XCRESULT_TO_JUNIT_HTML_REPORT_PATH", htmlPath); err != nil {
+			return failure("Failed to export output: %s", err)
+		}
+	}
+
+	// Export the totals so later steps can branch on them without parsing the report
+	log.Printf("%d tests, %d failed, %d skipped in %.3fs", totals.Tests, totals.Failures, totals.Skipped, totals.Time)
+	for _, output := range []struct{ key, value string }{
+		{"XCRESULT_TEST_COUNT", strconv.Itoa(totals.Tests)},
+		{"XCRESULT_FAILURE_COUNT", strconv.Itoa(totals.Failures)},
+		{"XCRESULT_SKIPPED_COUNT", strconv.Itoa(totals.Skipped)},
+		{"XCRESULT_TOTAL_TIME", strconv.FormatFloat(totals.Time, 'f', 3, 64)},
+	} {
+		if err := exportOutput(output.key, output.value); err != nil {
+			return failure("Failed to export output: %s", err)
+		}
+	}
+
+	// Export per-device failure counts so later steps can branch per device
+	if counts := deviceFailureCounts(testSuites); len(counts) > 1 {
+		if err := exportDeviceFailureCounts(counts); err != nil {
+			return failure("Failed to export per-device failure counts: %s", err)
+		}
+	}
+
+	// Make the results available to the Bitrise Test Reports add-on
+	if config.BitriseTestReports {
+		if config.BitriseTestResultDir == "" {
+			return invalidConfig("BITRISE_TEST_RESULT_DIR is not set, can't export results for the Test Reports add-on")
+		}
+		reportDir, err := exportBitriseTestReport(config.BitriseTestResultDir, config.TestName, filepath.Base(config.JUnitFilename), junitXML)
+		if err != nil {
+			return failure("Failed to export results for the Test Reports add-on: %s", err)
+		}
+		log.Infof("Test results exported for the Test Reports add-on: %s", reportDir)
+	}
+
+	// The report is written either way, only the exit code depends on the input
+	if totals.Tests == 0 && config.FailOnEmpty {
+		return &stepError{ExitCode: exitCodeNoTests, Err: errors.New("No test cases found in the XCResult and fail_on_empty is enabled")}
 	}
 
 	log.Donef("XCResult successfully converted to JUnit XML")
+	return nil
+}
+
+// outputFileMode is the permission mode of the written reports, the
+// directories created for them get the matching mode with the search bits of
+// the readable classes set, e.g. 0755 for 0644
+var outputFileMode os.FileMode = 0644
+
+// parseFileMode parses an octal permission mode like "0644"
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not an octal number", value)
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("%s has bits set other than the permission bits", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// outputDirMode returns the permission mode of the created directories
+func outputDirMode() os.FileMode {
+	return outputFileMode | outputFileMode&0444>>2
+}
+
+// writeOutputFile writes the file with outputFileMode. The mode is set
+// explicitly as the umask would clear the group and other write bits.
+func writeOutputFile(pth string, data []byte) error {
+	if err := os.WriteFile(pth, data, outputFileMode); err != nil {
+		return err
+	}
+	return os.Chmod(pth, outputFileMode)
+}
+
+// writeGzipOutputFile writes the gzip compressed data to the file with
+// outputFileMode
+func writeGzipOutputFile(pth string, data []byte) error {
+	file, err := os.OpenFile(pth, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, outputFileMode)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(file)
+	if _, err := zw.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(pth, outputFileMode)
+}
+
+// createOutputDir creates the directory and its missing parents, the
+// directory itself gets outputDirMode regardless of the umask. An existing
+// directory is left untouched, it may be shared (e.g. /tmp) or not owned.
+func createOutputDir(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, outputDirMode()); err != nil {
+		return err
+	}
+	return os.Chmod(dir, outputDirMode())
+}
+
+// prepareOutputDirs creates the output directory and the directory of the
+// report, which differs when junit_filename has directories or is absolute,
+// and checks that they are writable
+func prepareOutputDirs(config Config) error {
+	dirs := []string{config.OutputDir}
+	if reportDir := filepath.Dir(reportPath(config)); reportDir != filepath.Clean(config.OutputDir) {
+		dirs = append(dirs, reportDir)
+	}
+
+	for _, dir := range dirs {
+		if exists, err := pathutil.IsPathExists(dir); err != nil {
+			return fmt.Errorf("failed to check if %s exists: %w", dir, err)
+		} else if !exists {
+			if err := createOutputDir(dir); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+		}
+		if err := checkWritable(dir); err != nil {
+			return err
+		}
+	}
+
+	if info, err := os.Stat(reportPath(config)); err == nil && info.IsDir() {
+		return fmt.Errorf("the report path %s is a directory", reportPath(config))
+	}
+	return nil
+}
+
+// checkWritable checks that files can be created in the directory
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".xcresult-to-junit-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// writeSuiteFiles writes every top level test suite into its own JUnit XML
+// file named after the suite, and returns the paths of the written files
+func writeSuiteFiles(suites JUnitTestSuites, dir string, marshal func(JUnitTestSuites) ([]byte, error)) ([]string, error) {
+	var paths []string
+	used := map[string]int{}
+	for _, suite := range suites.TestSuites {
+		name := safeFileName(suite.Name)
+		// Suite names differing only in special characters would overwrite each other
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, used[name])
+		}
+
+		xmlData, err := marshal(JUnitTestSuites{TestSuites: []JUnitTestSuite{suite}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert test suite %s to JUnit XML: %w", suite.Name, err)
+		}
+
+		pth := filepath.Join(dir, name+".xml")
+		if err := writeOutputFile(pth, xmlData); err != nil {
+			return nil, fmt.Errorf("failed to write test suite %s: %w", suite.Name, err)
+		}
+		paths = append(paths, pth)
+	}
+	return paths, nil
+}
+
+// readJSONInput reads pre-exported xcresulttool JSON from the file, or from
+// stdin if the path is "-"
+func readJSONInput(pth string, stdin io.Reader) ([]byte, error) {
+	var jsonData []byte
+	var err error
+	if pth == "-" {
+		jsonData, err = io.ReadAll(stdin)
+	} else {
+		jsonData, err = os.ReadFile(pth)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(jsonData) {
+		return nil, fmt.Errorf("%s is not valid JSON", pth)
+	}
+	return jsonData, nil
+}
+
+// readExistingReport parses the JUnit report at the path, it returns nil if
+// the report doesn't exist yet
+func readExistingReport(pth string) (*JUnitTestSuites, error) {
+	file, err := os.Open(pth)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(pth, gzipExt) {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a gzip compressed report: %w", pth, err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	testSuites, err := ParseJUnit(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a JUnit report: %w", pth, err)
+	}
+	return testSuites, nil
+}
+
+// junitPath returns the path of the JUnit report: junit_filename in the
+// output directory, or junit_filename itself when it's an absolute path
+func junitPath(config Config) string {
+	if filepath.IsAbs(config.JUnitFilename) {
+		return filepath.Clean(config.JUnitFilename)
+	}
+	return filepath.Join(config.OutputDir, config.JUnitFilename)
+}
+
+// reportPath returns the path of the generated report
+func reportPath(config Config) string {
+	outputPath := junitPath(config)
+	switch config.OutputFormat {
+	case outputFormatCSV:
+		outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".csv"
+	case outputFormatJSON:
+		outputPath = summaryPath(config)
+	}
+	if config.GzipOutput {
+		outputPath += gzipExt
+	}
+	return outputPath
+}
+
+// gzipExt is appended to the path of the gzip compressed report
+const gzipExt = ".gz"
+
+// summaryPath returns the path of the JSON summary, named after the JUnit report
+func summaryPath(config Config) string {
+	outputPath := junitPath(config)
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".json"
+}
+
+// printResolvedConfig prints the values derived from the configuration,
+// showing what a real run would do
+func printResolvedConfig(config Config) {
+	absPath := func(pth string) string {
+		abs, err := filepath.Abs(pth)
+		if err != nil {
+			return pth
+		}
+		return abs
+	}
+
+	enabled := func(b bool) string {
+		if b {
+			return "enabled"
+		}
+		return "disabled"
+	}
+
+	log.Printf("")
+	log.Infof("Resolved configuration:")
+	log.Printf("- Step version: %s", stepVersion())
+	if config.JSONInputPath == "-" {
+		log.Printf("- XCResult JSON: stdin")
+	} else if config.JSONInputPath != "" {
+		log.Printf("- XCResult JSON: %s", absPath(config.JSONInputPath))
+	}
+	for _, xcresultPath := range splitXCResultPaths(config.XCResultPath) {
+		log.Printf("- XCResult path: %s", absPath(xcresultPath))
+	}
+	log.Printf("- Report path: %s", absPath(reportPath(config)))
+	log.Printf("- Report path exported to: %s", config.OutputVariableName)
+	log.Printf("- Output format: %s", config.OutputFormat)
+	log.Printf("- Indented XML: %s", enabled(config.Indent))
+	log.Printf("- Split by suite: %s", enabled(config.SplitBySuite))
+	log.Printf("- Append to existing report: %s", enabled(config.Append))
+	log.Printf("- Gzip compressed report: %s", enabled(config.GzipOutput))
+	log.Printf("- Parser: xcrun xcresulttool get test-results tests (get --format json before Xcode 16)")
+	if config.XCResultToolPath != "" {
+		log.Printf("- xcresulttool: %s", absPath(config.XCResultToolPath))
+	} else if config.XcodePath != "" {
+		log.Printf("- Xcode: %s", absPath(config.XcodePath))
+	}
+	log.Printf("- xcresulttool retries: %d", config.XCResultToolRetries)
+	if config.DumpJSONPath != "" {
+		log.Printf("- xcresulttool JSON saved to: %s", absPath(config.DumpJSONPath))
+	}
+	log.Printf("- Output file mode: %04o", outputFileMode)
+	if xcresultToolTimeout > 0 {
+		log.Printf("- xcresulttool timeout: %s", xcresultToolTimeout)
+	} else {
+		log.Printf("- xcresulttool timeout: none")
+	}
+	log.Printf("- Preserve hierarchy: %s", enabled(config.PreserveHierarchy))
+	log.Printf("- Flat suites grouped by: %s", config.GroupBy)
+	log.Printf("- Suites per device: %s", enabled(config.DisambiguateByDevice))
+	log.Printf("- Suite time source: %s", config.SuiteTimeSource)
+	log.Printf("- Time decimals: %d", config.TimeDecimals)
+	log.Printf("- Max test node depth: %d", config.MaxNodeDepth)
+	log.Printf("- Assertion failures reported as: <%s>", config.FailureElement)
+	log.Printf("- Activity logs in system-out: %s", config.SystemOutOn)
+	log.Printf("- Collapse singleton suites: %s", enabled(config.CollapseSingletonSuites))
+	if config.Flatten {
+		log.Printf("- All tests in one suite: %s", config.FlattenedSuiteName)
+	} else {
+		log.Printf("- All tests in one suite: disabled")
+	}
+	if config.OmitEmptySuite {
+		log.Printf("- Suite reported without tests: none")
+	} else if config.EmptySuiteName != "" {
+		log.Printf("- Suite reported without tests: %s", config.EmptySuiteName)
+	}
+	log.Printf("- Strip method parentheses: %s", enabled(config.StripMethodParens))
+	if config.ClassnamePrefixStrip != "" {
+		log.Printf("- Classname prefix stripped: %s", config.ClassnamePrefixStrip)
+	}
+	log.Printf("- Replace spaces in classnames: %s", enabled(config.ClassnameReplaceSpaces))
+	log.Printf("- Sanitize suite names and classnames: %s", enabled(config.SanitizeNames))
+	log.Printf("- ReportPortal compatibility: %s", enabled(config.ReportPortalCompat))
+	log.Printf("- Suite hostname and package attributes: %s", enabled(config.SuiteHostAttributes))
+	log.Printf("- Failure file and line attributes: %s", enabled(config.LocationAttributes))
+	log.Printf("- Suite ids: %s", enabled(config.SuiteIDs))
+	log.Printf("- Tag properties: %s", enabled(config.TagProperties))
+	if config.IncludePattern != "" {
+		log.Printf("- Included tests: %s", config.IncludePattern)
+	}
+	if config.ExcludePattern != "" {
+		log.Printf("- Excluded tests: %s", config.ExcludePattern)
+	}
+	log.Printf("- Omit skipped tests: %s", enabled(config.OmitSkipped))
+	if config.MaxTestCases > 0 {
+		log.Printf("- Max test cases: %d", config.MaxTestCases)
+	} else {
+		log.Printf("- Max test cases: unlimited")
+	}
+	if config.MaxFailureLength > 0 {
+		log.Printf("- Max failure length: %d", config.MaxFailureLength)
+	} else {
+		log.Printf("- Max failure length: unlimited")
+	}
+	if config.FailureContextLines > 0 {
+		log.Printf("- Activities around failures: %d", config.FailureContextLines)
+	}
+	log.Printf("- Flaky tests report: %s", enabled(config.FlakyReport))
+	log.Printf("- Export attachments: %s", enabled(config.ExportAttachments))
+	if config.InlineAttachments {
+		log.Printf("- Inline images up to: %d bytes", config.InlineAttachmentMaxSize)
+	} else {
+		log.Printf("- Inline images: disabled")
+	}
+	log.Printf("- Performance metrics: %s", enabled(config.PerformanceMetrics))
+	log.Printf("- HTML report: %s", enabled(config.EmitHTMLReport))
+	log.Printf("- Fail on empty results: %s", enabled(config.FailOnEmpty))
+	log.Printf("- Strict conversion: %s", enabled(config.Strict))
+	log.Printf("- Strict validation: %s", enabled(config.StrictValidation))
+	log.Printf("- Bitrise Test Reports export: %s", enabled(config.BitriseTestReports))
+	log.Printf("- Dry run: %s", enabled(config.DryRun))
+	if config.BitriseTestReports {
+		log.Printf("- Test Reports directory: %s", filepath.Join(config.BitriseTestResultDir, safeFileName(config.TestName)))
+	}
+}
+
+// attachmentsDir returns the directory the attachments of the bundle are
+// exported to, named after the bundle. Bundles with the same name get a
+// numbered directory.
+func attachmentsDir(outputDir, xcresultPath string) string {
+	name := safeFileName(strings.TrimSuffix(filepath.Base(xcresultPath), filepath.Ext(xcresultPath)))
+	dir := filepath.Join(outputDir, "attachments", name)
+	for i := 2; ; i++ {
+		if exists, err := pathutil.IsPathExists(dir); err != nil || !exists {
+			return dir
+		}
+		dir = filepath.Join(outputDir, "attachments", fmt.Sprintf("%s_%d", name, i))
+	}
+}
+
+// validateExecutable checks that the path is an executable file
+func validateExecutable(pth string) error {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", pth)
+	}
+	if info.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", pth)
+	}
+	return nil
+}
+
+// resolveDeveloperDir returns the developer directory of an Xcode, which is
+// given either as the app (/Applications/Xcode-16.2.app) or as its developer
+// directory (/Applications/Xcode-16.2.app/Contents/Developer)
+func resolveDeveloperDir(xcodePath string) (string, error) {
+	dir := filepath.Clean(xcodePath)
+	if strings.HasSuffix(dir, ".app") {
+		dir = filepath.Join(dir, "Contents", "Developer")
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// compilePattern compiles a test name pattern, an empty pattern returns nil
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// expandXCResultGlobs returns the paths matching the glob patterns, in order
+// and without duplicates. It fails if a pattern doesn't match anything.
+func expandXCResultGlobs(patterns []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		expanded, err := pathutil.ExpandTilde(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		matches, err := filepath.Glob(expanded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no xcresult bundle matches %s", pattern)
+		}
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				paths = append(paths, match)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// xcresultFormat is the JSON format the test results were exported in
+type xcresultFormat int
+
+const (
+	// formatTestResults is the output of `xcresulttool get test-results tests` (Xcode 16+)
+	formatTestResults xcresultFormat = iota
+	// formatLegacy is the object graph of `xcresulttool get --format json`, see legacy.go
+	formatLegacy
+)
+
+// minTestResultsToolVersion is the first xcresulttool version (Xcode 16)
+// supporting the test-results subcommand
+const minTestResultsToolVersion = 23000
+
+// jsonDumpPath is where the JSON exported by xcresulttool is saved for
+// debugging, nothing is saved when empty. jsonDumps counts the saved bundles.
+var (
+	jsonDumpPath string
+	jsonDumps    int
+)
+
+// convertXCResultToJSON executes xcrun xcresulttool to get test results as
+// JSON, which is saved to jsonDumpPath when set
+func convertXCResultToJSON(xcresultPath string) ([]byte, xcresultFormat, error) {
+	output, format, err := exportXCResultJSON(xcresultPath)
+	if err == nil && jsonDumpPath != "" {
+		dumpXCResultJSON(xcresultPath, output)
+	}
+	return output, format, err
+}
+
+// dumpXCResultJSON saves the JSON exported from the bundle to jsonDumpPath.
+// The JSON of the second and further bundles is numbered, e.g. tests-2.json.
+// Failing to save it doesn't fail the conversion.
+func dumpXCResultJSON(xcresultPath string, output []byte) {
+	jsonDumps++
+	pth := jsonDumpPath
+	if jsonDumps > 1 {
+		ext := filepath.Ext(pth)
+		pth = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(pth, ext), jsonDumps, ext)
+	}
+
+	if err := createOutputDir(filepath.Dir(pth)); err != nil {
+		log.Warnf("Failed to save the xcresulttool JSON of %s: %s", xcresultPath, err)
+		return
+	}
+	// The output is written as exported, without being re-encoded
+	if err := writeOutputFile(pth, output); err != nil {
+		log.Warnf("Failed to save the xcresulttool JSON of %s: %s", xcresultPath, err)
+		return
+	}
+	log.Printf("Saved the xcresulttool JSON of %s to %s", xcresultPath, pth)
+}
+
+// exportXCResultJSON executes xcrun xcresulttool to get test results as JSON.
+// Older xcresulttool versions only export the legacy object graph.
+func exportXCResultJSON(xcresultPath string) ([]byte, xcresultFormat, error) {
+	version, err := xcresultToolVersion()
+	if err != nil {
+		log.Warnf("Failed to detect the xcresulttool version, assuming Xcode 16 or newer: %s", err)
+	} else if version < minTestResultsToolVersion {
+		log.Debugf("xcresulttool version %d doesn't support test-results, using the legacy format", version)
+		output, err := legacyTestResultsJSON(xcresultPath, false)
+		if err != nil {
+			return nil, formatLegacy, err
+		}
+		return output, formatLegacy, nil
+	}
+
+	output, err := runXCResultTool("get", "test-results", "tests", "--path", xcresultPath)
+	if err != nil {
+		// Bundles produced by an older toolchain can only be read in the legacy format
+		if !isUnavailableError(err) {
+			return nil, formatTestResults, err
+		}
+
+		log.Debugf("Test results are unavailable (%s), falling back to the legacy format", err)
+		output, err := legacyTestResultsJSON(xcresultPath, true)
+		if err != nil {
+			return nil, formatLegacy, err
+		}
+		return output, formatLegacy, nil
+	}
+
+	log.Debugf("Using the test results format, JSON output length: %d bytes", len(output))
+	return output, formatTestResults, nil
+}
+
+// isUnavailableError reports whether xcresulttool failed because the test
+// results are unavailable for the bundle
+func isUnavailableError(err error) bool {
+	// "Resource temporarily unavailable" is about the machine, not the bundle
+	return strings.Contains(strings.ToLower(err.Error()), "unavailable") && !isTransientError(err)
+}
+
+// xcresultToolVersion returns the version of xcresulttool
+func xcresultToolVersion() (int, error) {
+	output, err := runXCResultTool("version")
+	if err != nil {
+		return 0, err
+	}
+	return parseXCResultToolVersion(string(output))
+}
+
+// parseXCResultToolVersion parses the output of `xcresulttool version`, like
+// "xcresulttool version 23021, format version 3.53 (current)"
+func parseXCResultToolVersion(output string) (int, error) {
+	fields := strings.Fields(strings.ReplaceAll(output, ",", " "))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "xcresulttool" && fields[i+1] == "version" && i+2 < len(fields) {
+			version, err := strconv.Atoi(strings.SplitN(fields[i+2], ".", 2)[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid xcresulttool version: %s", fields[i+2])
+			}
+			return version, nil
+		}
+	}
+	return 0, fmt.Errorf("unexpected xcresulttool version output: %s", strings.TrimSpace(output))
+}
+
+// legacyTestResultsJSON exports the test plan run summaries referenced by the
+// test actions of the bundle, together with the start of the first action.
+// Newer xcresulttool versions need the --legacy flag for this.
+func legacyTestResultsJSON(xcresultPath string, legacyFlag bool) ([]byte, error) {
+	get := func(args ...string) ([]byte, error) {
+		args = append([]string{"get", "--format", "json", "--path", xcresultPath}, args...)
+		if legacyFlag {
+			args = append(args, "--legacy")
+		}
+		return runXCResultTool(args...)
+	}
+
+	output, err := get()
+	if err != nil {
+		return nil, err
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(output, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse the actions invocation record: %w", err)
+	}
+
+	var startTime float64
+	summaries := []interface{}{}
+	for _, action := range legacyValues(record["actions"]) {
+		if startTime == 0 {
+			startTime = parseLegacyDate(getStringByPath(action, []string{"startedTime"}))
+		}
+
+		id := getStringByPath(action, []string{"actionResult", "testsRef", "id"})
+		if id == "" {
+			continue
+		}
+
+		output, err := get("--id", id)
+		if err != nil {
+			return nil, err
+		}
+
+		var summary interface{}
+		if err := json.Unmarshal(output, &summary); err != nil {
+			return nil, fmt.Errorf("failed to parse the test plan run summaries: %w", err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	log.Debugf("Found %d test plan run summaries", len(summaries))
+	return json.Marshal(map[string]interface{}{
+		"startTime":         startTime,
+		"testPlanSummaries": summaries,
+	})
 }
 
-// convertXCResultToJSON executes xcrun xcresulttool to get test results as JSON
-func convertXCResultToJSON(xcresultPath string) ([]byte, error) {
-	cmd := exec.Command("xcrun", "xcresulttool", "get", "test-results", "tests", "--path", xcresultPath)
-	output, err := cmd.Output()
+// readTestRunStartTime reads the start of the test run from the xcresult
+// summary, the test results JSON doesn't contain it
+func readTestRunStartTime(xcresultPath string) (time.Time, error) {
+	output, err := runXCResultTool("get", "test-results", "summary", "--path", xcresultPath)
 	if err != nil {
-		//var exitErr *exec.ExitError
-		if err, ok := err.(*exec.ExitError); ok {
-			return nil, fmt.Errorf("command failed with exit code %d: %s", err.ExitCode(), string(err.Stderr))
+		return time.Time{}, err
+	}
+
+	var summary struct {
+		StartTime float64 `json:"startTime"`
+	}
+	if err := json.Unmarshal(output, &summary); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse test results summary: %w", err)
+	}
+	if summary.StartTime <= 0 {
+		return time.Time{}, fmt.Errorf("test results summary has no start time")
+	}
+
+	return time.Unix(0, int64(summary.StartTime*float64(time.Second))), nil
+}
+
+// xcresultToolRetries is the number of times a transient xcresulttool failure
+// is retried, xcresultToolBackoff the delay before the first retry, doubled
+// for every further one
+var (
+	xcresultToolRetries = 2
+	xcresultToolBackoff = time.Second
+)
+
+// xcresultToolTimeout limits a single xcresulttool invocation, which can hang
+// on a corrupt bundle. 0 means no limit.
+var xcresultToolTimeout = 10 * time.Minute
+
+// runXCResultTool executes xcrun xcresulttool with the given arguments and
+// returns its output, transient failures are retried
+func runXCResultTool(args ...string) ([]byte, error) {
+	return withRetries(xcresultToolRetries, xcresultToolBackoff, func(attempt int) ([]byte, error) {
+		log.Debugf("Running xcresulttool %s (attempt %d)", strings.Join(args, " "), attempt)
+		return execXCResultTool(args...)
+	})
+}
+
+// withRetries calls run until it succeeds, fails with a non-transient error or
+// has been retried retries times
+func withRetries(retries int, backoff time.Duration, run func(attempt int) ([]byte, error)) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		output, err := run(attempt)
+		if err == nil || attempt > retries || !isTransientError(err) {
+			return output, err
 		}
+
+		log.Debugf("xcresulttool failed with a transient error, retrying in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// transientErrorMarkers are the lowercase messages of the resource errors a
+// busy machine causes, which are worth retrying
+var transientErrorMarkers = []string{
+	"resource temporarily unavailable",
+	"resource busy",
+	"too many open files",
+	"cannot allocate memory",
+	"interrupted system call",
+	"operation timed out",
+}
+
+// isTransientError reports whether xcresulttool failed because of the machine
+// being busy rather than the bundle, which would fail the same way again
+func isTransientError(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, marker := range transientErrorMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// xcresultToolPath is the xcresulttool binary to run instead of the one
+// found by xcrun, developerDir the developer directory of the Xcode xcrun
+// uses (DEVELOPER_DIR). Both are empty by default.
+var (
+	xcresultToolPath string
+	developerDir     string
+)
+
+// xcresultToolError is returned when xcresulttool exits with an error, it
+// holds what is needed to reproduce the failure manually
+type xcresultToolError struct {
+	// Command is the invoked command line, e.g. "xcrun xcresulttool get ..."
+	Command  string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *xcresultToolError) Error() string {
+	return fmt.Sprintf("command failed with exit code %d: %s", e.ExitCode, e.Stderr)
+}
+
+// execXCResultTool executes xcresulttool once
+func execXCResultTool(args ...string) ([]byte, error) {
+	cmd := exec.Command("xcrun", append([]string{"xcresulttool"}, args...)...)
+	if xcresultToolPath != "" {
+		cmd = exec.Command(xcresultToolPath, args...)
+	} else if developerDir != "" {
+		cmd.Env = append(os.Environ(), "DEVELOPER_DIR="+developerDir)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// xcrun runs xcresulttool as a child process, the whole process group is
+	// killed on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to execute command: %w", err)
 	}
 
-	log.Debugf("XCResult JSON output length: %d bytes", len(output))
-	return output, nil
+	var timedOut int32
+	if xcresultToolTimeout > 0 {
+		pid := cmd.Process.Pid
+		timer := time.AfterFunc(xcresultToolTimeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+				log.Warnf("Failed to kill xcresulttool: %s", err)
+			}
+		})
+		defer timer.Stop()
+	}
+
+	err := cmd.Wait()
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return nil, fmt.Errorf("xcresulttool didn't finish in %s and was killed, the bundle may be corrupt", xcresultToolTimeout)
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			command := shellCommand(cmd.Args)
+			if xcresultToolPath == "" && developerDir != "" {
+				command = "DEVELOPER_DIR=" + shellCommand([]string{developerDir}) + " " + command
+			}
+			return nil, &xcresultToolError{Command: command, ExitCode: exitErr.ExitCode(), Stderr: stderr.String()}
+		}
+		return nil, fmt.Errorf("failed to execute command: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// shellCommand joins the arguments into a command line which can be pasted
+// into a shell, quoting the arguments with special characters
+func shellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:@%+,") == "" {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// noTestResultsMarkers are the lowercase stderr messages of xcresulttool for a
+// path which isn't a bundle with test results
+var noTestResultsMarkers = []string{
+	"does not exist",
+	"contains no test",
+	"no test results",
+}
+
+// logXCResultToolError logs the command and the full stderr of a failed
+// xcresulttool invocation, with guidance for the common failures
+func logXCResultToolError(err error) {
+	var toolErr *xcresultToolError
+	if !errors.As(err, &toolErr) {
+		return
+	}
+
+	log.Errorf("----- xcresulttool failed with exit code %d -----", toolErr.ExitCode)
+	log.Errorf("Command: %s", toolErr.Command)
+	log.Errorf("Stderr:")
+	log.Printf("%s", strings.TrimRight(toolErr.Stderr, "\n"))
+	log.Errorf("-----")
+
+	stderr := strings.ToLower(toolErr.Stderr)
+	for _, marker := range noTestResultsMarkers {
+		if strings.Contains(stderr, marker) {
+			log.Warnf("The bundle doesn't seem to contain test results. Check that xcresult_path points to the " +
+				"xcresult bundle of a test run (e.g. the one exported by the Xcode Test step as BITRISE_XCRESULT_PATH) " +
+				"and that the tests actually ran.")
+			break
+		}
+	}
+}
+
+// testInfo is the test-info.json sidecar expected by the Bitrise Test Reports add-on
+type testInfo struct {
+	Name string `json:"test-name"`
+}
+
+// exportBitriseTestReport writes the JUnit XML together with its test-info.json
+// into a dedicated directory under the Bitrise test result directory
+func exportBitriseTestReport(resultDir, testName, filename string, junitXML []byte) (string, error) {
+	if testName == "" {
+		testName = "XCResult"
+	}
+
+	reportDir := filepath.Join(resultDir, safeFileName(testName))
+	if err := createOutputDir(reportDir); err != nil {
+		return "", fmt.Errorf("failed to create test run directory: %w", err)
+	}
+
+	if err := writeOutputFile(filepath.Join(reportDir, filename), junitXML); err != nil {
+		return "", fmt.Errorf("failed to write JUnit XML: %w", err)
+	}
+
+	info, err := json.Marshal(testInfo{Name: testName})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal test-info.json: %w", err)
+	}
+	if err := writeOutputFile(filepath.Join(reportDir, "test-info.json"), info); err != nil {
+		return "", fmt.Errorf("failed to write test-info.json: %w", err)
+	}
+
+	return reportDir, nil
 }
 
+// safeFileName turns a test or suite name into a file name safe on every file
+// system. The characters are replaced like in the sanitized suite names, so a
+// suite is spelled the same in the report and in its file name.
+func safeFileName(value string) string {
+	name := sanitizeName(value)
+	// "." and ".." would refer to the directory itself or its parent
+	if name != "" && strings.Trim(name, ".") == "" {
+		name = strings.Repeat("_", len(name))
+	}
+	return name
+}
+
+// testTotals holds the counts and the duration of all test suites
+type testTotals struct {
+	Tests int
+	// Failures counts both failed and errored test cases
+	Failures int
+	Skipped  int
+	Time     float64
+}
+
+// computeTotals sums the top level test suites, which include the counts of
+// their nested suites
+func computeTotals(suites JUnitTestSuites) testTotals {
+	var totals testTotals
+	for _, suite := range suites.TestSuites {
+		totals.Tests += suite.Tests
+		totals.Failures += suite.Failures + suite.Errors
+		totals.Skipped += suite.Skipped
+		totals.Time += suite.Time
+	}
+	return totals
+}
+
+// maxDeviceOutputs caps the number of exported per-device outputs
+const maxDeviceOutputs = 10
+
+// deviceFailureCounts returns the number of failed test cases per device
+func deviceFailureCounts(testSuites JUnitTestSuites) map[string]int {
+	counts := make(map[string]int)
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		for _, tc := range suite.TestCases {
+			for _, device := range tc.Devices {
+				if _, ok := counts[device]; !ok {
+					counts[device] = 0
+				}
+			}
+			for _, device := range tc.FailedDevices {
+				counts[device]++
+			}
+		}
+	})
+	return counts
+}
+
+// deviceOutputKey returns the output key of a device's failure count. The
+// device name is uppercased and every run of characters other than A-Z and 0-9
+// is replaced with a single underscore, e.g. "iPhone 15 Pro" becomes
+// XCRESULT_FAILURES_IPHONE_15_PRO.
+func deviceOutputKey(device string) string {
+	var key strings.Builder
+	underscore := false
+	for _, r := range strings.ToUpper(device) {
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			key.WriteRune(r)
+			underscore = false
+		} else if !underscore {
+			key.WriteRune('_')
+			underscore = true
+		}
+	}
+	return "XCRESULT_FAILURES_" + strings.Trim(key.String(), "_")
+}
+
+// exportDeviceFailureCounts exports the failure count of each device. Devices
+// whose names sanitize to the same key share a summed count.
+func exportDeviceFailureCounts(counts map[string]int) error {
+	outputs := make(map[string]int)
+	for device, count := range counts {
+		outputs[deviceOutputKey(device)] += count
+	}
+
+	keys := make([]string, 0, len(outputs))
+	for key := range outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(keys) > maxDeviceOutputs {
+		log.Warnf("Found %d devices, only exporting the failure counts of the first %d", len(keys), maxDeviceOutputs)
+		keys = keys[:maxDeviceOutputs]
+	}
+
+	for _, key := range keys {
+		log.Debugf("Exporting %s=%d", key, outputs[key])
+		if err := exportOutput(key, strconv.Itoa(outputs[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultOutputVariableName is the env var the path of the report is exported to
+const defaultOutputVariableName = "XCRESULT_TO_JUNIT_OUTPUT_PATH"
+
+// variableNamePattern matches the valid env var names
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // exportOutput exports a step output
 func exportOutput(key, value string) error {
 	cmd := exec.Command("envman", "add", "--key", key, "--value", value)
@@ -97,8 +1543,46 @@ func exportOutput(key, value string) error {
 	return cmd.Run()
 }
 
-// failf prints an error message and exits
-func failf(format string, args ...interface{}) {
-	log.Errorf(format, args...)
-	os.Exit(1)
+// Exit codes of the step
+const (
+	exitCodeFailure = 1
+	// exitCodeInvalidConfig is returned for invalid inputs
+	exitCodeInvalidConfig = 2
+	// exitCodeNoTests is returned when no tests were found and fail_on_empty
+	// is enabled
+	exitCodeNoTests = 3
+)
+
+// stepError is an error which fails the step with its exit code
+type stepError struct {
+	ExitCode int
+	Err      error
+}
+
+func (e *stepError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *stepError) Unwrap() error {
+	return e.Err
+}
+
+// failure returns an error failing the step with exitCodeFailure
+func failure(format string, args ...interface{}) error {
+	return &stepError{ExitCode: exitCodeFailure, Err: fmt.Errorf(format, args...)}
+}
+
+// invalidConfig returns an error failing the step with exitCodeInvalidConfig
+func invalidConfig(format string, args ...interface{}) error {
+	return &stepError{ExitCode: exitCodeInvalidConfig, Err: fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the exit code of the error, exitCodeFailure unless it is
+// a stepError
+func exitCode(err error) int {
+	var stepErr *stepError
+	if errors.As(err, &stepErr) {
+		return stepErr.ExitCode
+	}
+	return exitCodeFailure
 }
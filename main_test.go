@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 )
@@ -45,8 +52,8 @@ func TestStepConfigValidation(t *testing.T) {
 		if config.JUnitFilename != "junit.xml" {
 			t.Errorf("Expected JUnitFilename to be junit.xml, got %s", config.JUnitFilename)
 		}
-		if config.Verbose != "yes" {
-			t.Errorf("Expected Verbose to be yes, got %s", config.Verbose)
+		if !config.Verbose {
+			t.Errorf("Expected Verbose to be enabled")
 		}
 	})
 
@@ -66,6 +73,72 @@ func TestStepConfigValidation(t *testing.T) {
 	})
 }
 
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	unexpectedJSON := filepath.Join(dir, "unexpected.json")
+	if err := os.WriteFile(unexpectedJSON, []byte(`{"tests": []}`), 0644); err != nil {
+		t.Fatalf("Failed to write JSON input: %v", err)
+	}
+	setInputs := func(t *testing.T, inputs map[string]string) {
+		for key, value := range inputs {
+			os.Setenv(key, value)
+		}
+		t.Cleanup(func() {
+			for key := range inputs {
+				os.Unsetenv(key)
+			}
+		})
+	}
+
+	tests := []struct {
+		name     string
+		inputs   map[string]string
+		exitCode int
+	}{
+		{"invalid input", map[string]string{"output_format": "yaml"}, exitCodeInvalidConfig},
+		{"invalid output variable name", map[string]string{"xcresult_path": "Test.xcresult", "output_variable_name": "UI-TESTS"}, exitCodeInvalidConfig},
+		{"missing bundle", map[string]string{"xcresult_path": filepath.Join(dir, "Missing.xcresult")}, exitCodeFailure},
+		{"print config", map[string]string{"xcresult_path": "Test.xcresult", "print_config_and_exit": "yes"}, 0},
+		{"print version", map[string]string{"xcresult_path": filepath.Join(dir, "Missing.xcresult"), "print_version": "yes"}, 0},
+		{"unexpected format", map[string]string{"json_input_path": unexpectedJSON, "fail_on_empty": "yes"}, exitCodeNoTests},
+		{"validate output", map[string]string{"json_input_path": filepath.Join("testdata", "test_results.json"), "validate_output": "yes", "dry_run": "yes"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setInputs(t, map[string]string{"output_dir": dir, "junit_filename": "junit.xml"})
+			setInputs(t, tt.inputs)
+
+			err := run()
+			if tt.exitCode == 0 {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			if code := exitCode(err); code != tt.exitCode {
+				t.Errorf("Expected exit code %d, got %d (%v)", tt.exitCode, code, err)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	noTests := &stepError{ExitCode: exitCodeNoTests, Err: errors.New("no tests")}
+	if code := exitCode(fmt.Errorf("export failed: %w", noTests)); code != exitCodeNoTests {
+		t.Errorf("Expected exit code %d for a wrapped step error, got %d", exitCodeNoTests, code)
+	}
+	if code := exitCode(errors.New("failed")); code != exitCodeFailure {
+		t.Errorf("Expected exit code %d for other errors, got %d", exitCodeFailure, code)
+	}
+	if err := invalidConfig("Invalid indent: %s", "maybe"); err.Error() != "Invalid indent: maybe" {
+		t.Errorf("Expected the formatted message, got %q", err.Error())
+	}
+}
+
 func TestExportOutput(t *testing.T) {
 	// Skip this test in CI environments where envman might not be available
 	if os.Getenv("CI") != "" {
@@ -79,3 +152,719 @@ func TestExportOutput(t *testing.T) {
 		}
 	})
 }
+
+func TestVariableNamePattern(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{defaultOutputVariableName, true},
+		{"UI_TESTS_JUNIT_PATH", true},
+		{"_private2", true},
+		{"2ND_RUN_PATH", false},
+		{"UI-TESTS", false},
+		{"UI TESTS", false},
+		{"$UI_TESTS", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid := variableNamePattern.MatchString(tt.name); valid != tt.valid {
+				t.Errorf("Expected valid=%v, got %v", tt.valid, valid)
+			}
+		})
+	}
+}
+
+func TestExportBitriseTestReport(t *testing.T) {
+	resultDir, err := os.MkdirTemp("", "test-results")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(resultDir)
+
+	junitXML := []byte("<testsuites></testsuites>")
+	reportDir, err := exportBitriseTestReport(resultDir, "Unit Tests/iOS", "junit.xml", junitXML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if reportDir != filepath.Join(resultDir, "Unit Tests_iOS") {
+		t.Errorf("Unexpected test run directory: %s", reportDir)
+	}
+
+	content, err := os.ReadFile(filepath.Join(reportDir, "junit.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read JUnit XML: %v", err)
+	}
+	if string(content) != string(junitXML) {
+		t.Errorf("Expected JUnit XML to be %s, got %s", junitXML, content)
+	}
+
+	info, err := os.ReadFile(filepath.Join(reportDir, "test-info.json"))
+	if err != nil {
+		t.Fatalf("Failed to read test-info.json: %v", err)
+	}
+	if string(info) != `{"test-name":"Unit Tests/iOS"}` {
+		t.Errorf("Unexpected test-info.json: %s", info)
+	}
+
+	t.Run("existing directory", func(t *testing.T) {
+		// Exporting again into the directory doesn't change its mode
+		if err := os.Chmod(reportDir, 0755); err != nil {
+			t.Fatalf("Failed to chmod %s: %v", reportDir, err)
+		}
+		if _, err := exportBitriseTestReport(resultDir, "Unit Tests/iOS", "junit.xml", junitXML); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		info, err := os.Stat(reportDir)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", reportDir, err)
+		}
+		if mode := info.Mode().Perm(); mode != 0755 {
+			t.Errorf("Expected the directory to keep mode 755, got %o", mode)
+		}
+	})
+}
+
+func TestDeviceFailureCounts(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name: "LoginTests",
+				TestCases: []JUnitTestCase{
+					{Name: "testA", Devices: []string{"iPhone 15", "iPad Air (5th generation)"}, FailedDevices: []string{"iPhone 15"}},
+					{Name: "testB", Devices: []string{"iPhone 15", "iPad Air (5th generation)"}, FailedDevices: []string{"iPhone 15"}},
+					{Name: "testC", Devices: []string{"iPhone 15", "iPad Air (5th generation)"}},
+				},
+			},
+		},
+	}
+
+	counts := deviceFailureCounts(testSuites)
+	if len(counts) != 2 {
+		t.Fatalf("Expected 2 devices, got %d", len(counts))
+	}
+	if counts["iPhone 15"] != 2 {
+		t.Errorf("Expected 2 failures on iPhone 15, got %d", counts["iPhone 15"])
+	}
+	if counts["iPad Air (5th generation)"] != 0 {
+		t.Errorf("Expected 0 failures on iPad Air, got %d", counts["iPad Air (5th generation)"])
+	}
+}
+
+func TestComputeTotals(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{Name: "LoginTests", Tests: 4, Failures: 1, Errors: 1, Skipped: 1, Time: 1.5},
+			{
+				Name: "AppUITests", Tests: 2, Failures: 1, Time: 3,
+				TestSuites: []JUnitTestSuite{
+					{Name: "OnboardingTests", Tests: 2, Failures: 1, Time: 3},
+				},
+			},
+		},
+	}
+
+	expected := testTotals{Tests: 6, Failures: 3, Skipped: 1, Time: 4.5}
+	if totals := computeTotals(testSuites); totals != expected {
+		t.Errorf("Expected %+v, got %+v", expected, totals)
+	}
+	if totals := computeTotals(JUnitTestSuites{}); totals != (testTotals{}) {
+		t.Errorf("Expected zero totals, got %+v", totals)
+	}
+}
+
+func TestDeviceOutputKey(t *testing.T) {
+	tests := map[string]string{
+		"iPhone 15":                 "XCRESULT_FAILURES_IPHONE_15",
+		"iPad Air (5th generation)": "XCRESULT_FAILURES_IPAD_AIR_5TH_GENERATION",
+		"Apple TV 4K (3rd gen) ":    "XCRESULT_FAILURES_APPLE_TV_4K_3RD_GEN",
+	}
+
+	for device, expected := range tests {
+		if key := deviceOutputKey(device); key != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, device, key)
+		}
+	}
+}
+
+func TestExpandXCResultGlobs(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{"App-abc/Logs/Test/Run1.xcresult", "App-abc/Logs/Test/Run2.xcresult", "App-def/Logs/Test/Run3.xcresult"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create xcresult dir: %v", err)
+		}
+	}
+
+	t.Run("matches every bundle once", func(t *testing.T) {
+		patterns := []string{
+			filepath.Join(tempDir, "*", "Logs", "Test", "*.xcresult"),
+			filepath.Join(tempDir, "App-abc", "Logs", "Test", "Run1.xcresult"),
+		}
+		paths, err := expandXCResultGlobs(patterns)
+		if err != nil {
+			t.Fatalf("expandXCResultGlobs returned error: %v", err)
+		}
+		if len(paths) != 3 {
+			t.Fatalf("Expected 3 paths, got %d: %v", len(paths), paths)
+		}
+		if filepath.Base(paths[0]) != "Run1.xcresult" || filepath.Base(paths[2]) != "Run3.xcresult" {
+			t.Errorf("Expected paths in order, got %v", paths)
+		}
+	})
+
+	t.Run("fails without matches", func(t *testing.T) {
+		if _, err := expandXCResultGlobs([]string{filepath.Join(tempDir, "*", "*.xcresult")}); err == nil {
+			t.Errorf("Expected an error when nothing matches")
+		}
+	})
+}
+
+func TestWriteSuiteFiles(t *testing.T) {
+	dir := t.TempDir()
+	suites := JUnitTestSuites{TestSuites: []JUnitTestSuite{
+		{Name: "LoginTests", Tests: 1, TestCases: []JUnitTestCase{{Name: "testLogin", Classname: "LoginTests"}}},
+		{Name: "UI Tests/Onboarding", Tests: 1, TestCases: []JUnitTestCase{{Name: "testStart", Classname: "Onboarding"}}},
+		{Name: "UI Tests:Onboarding", Tests: 1, TestCases: []JUnitTestCase{{Name: "testEnd", Classname: "Onboarding"}}},
+		{Name: "..", Tests: 1, TestCases: []JUnitTestCase{{Name: "testUp", Classname: "Up"}}},
+	}}
+
+	paths, err := writeSuiteFiles(suites, dir, MarshalJUnitXML)
+	if err != nil {
+		t.Fatalf("writeSuiteFiles returned error: %v", err)
+	}
+
+	expected := []string{"LoginTests.xml", "UI Tests_Onboarding.xml", "UI Tests_Onboarding_2.xml", "__.xml"}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %d files, got %d", len(expected), len(paths))
+	}
+	for i, pth := range paths {
+		if pth != filepath.Join(dir, expected[i]) {
+			t.Errorf("Expected %s, got %s", filepath.Join(dir, expected[i]), pth)
+		}
+	}
+
+	f, err := os.Open(paths[1])
+	if err != nil {
+		t.Fatalf("Failed to open suite file: %v", err)
+	}
+	defer f.Close()
+	parsed, err := ParseJUnit(f)
+	if err != nil {
+		t.Fatalf("Failed to parse suite file: %v", err)
+	}
+	if len(parsed.TestSuites) != 1 || parsed.TestSuites[0].Name != "UI Tests/Onboarding" {
+		t.Errorf("Expected the file to hold the UI Tests/Onboarding suite, got %+v", parsed.TestSuites)
+	}
+}
+
+func TestParseXCResultToolVersion(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected int
+		wantErr  bool
+	}{
+		{"xcresulttool version 23021, format version 3.53 (current)\n", 23021, false},
+		{"xcresulttool version 22608, format version 3.49 (current)", 22608, false},
+		{"xcresulttool version 21545.1, format version 3.39", 21545, false},
+		{"unknown", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			version, err := parseXCResultToolVersion(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if version != tt.expected {
+				t.Errorf("Expected version %d, got %d", tt.expected, version)
+			}
+		})
+	}
+}
+
+func TestIsUnavailableError(t *testing.T) {
+	unavailable := fmt.Errorf("command failed with exit code 1: Error: Test results are unavailable for this result bundle")
+	if !isUnavailableError(unavailable) {
+		t.Errorf("Expected %q to be an unavailability error", unavailable)
+	}
+
+	other := fmt.Errorf("command failed with exit code 1: Error: the file couldn't be opened")
+	if isUnavailableError(other) {
+		t.Errorf("Expected %q not to be an unavailability error", other)
+	}
+
+	transient := fmt.Errorf("failed to execute command: fork/exec /usr/bin/xcrun: resource temporarily unavailable")
+	if isUnavailableError(transient) {
+		t.Errorf("Expected %q not to be an unavailability error", transient)
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	transient := fmt.Errorf("command failed with exit code 1: Error: Too many open files")
+
+	tests := []struct {
+		name     string
+		errs     []error
+		retries  int
+		attempts int
+		wantErr  bool
+	}{
+		{"success", []error{nil}, 2, 1, false},
+		{"transient then success", []error{transient, transient, nil}, 2, 3, false},
+		{"transient until out of retries", []error{transient, transient, transient, nil}, 2, 3, true},
+		{"no retries", []error{transient, nil}, 0, 1, true},
+		{"genuine error", []error{fmt.Errorf("command failed with exit code 1: Error: invalid bundle"), nil}, 2, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			output, err := withRetries(tt.retries, time.Millisecond, func(attempt int) ([]byte, error) {
+				attempts++
+				if attempt != attempts {
+					t.Errorf("Expected attempt %d, got %d", attempts, attempt)
+				}
+				if err := tt.errs[attempt-1]; err != nil {
+					return nil, err
+				}
+				return []byte("{}"), nil
+			})
+
+			if attempts != tt.attempts {
+				t.Errorf("Expected %d attempts, got %d", tt.attempts, attempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && string(output) != "{}" {
+				t.Errorf("Expected the output of the successful attempt, got %s", output)
+			}
+		})
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	if pattern, err := compilePattern(""); pattern != nil || err != nil {
+		t.Errorf("Expected no pattern for an empty value, got %v, %v", pattern, err)
+	}
+	if pattern, err := compilePattern(`^LoginTests\.`); err != nil || !pattern.MatchString("LoginTests.testLogin()") {
+		t.Errorf("Expected a matching pattern, got %v, %v", pattern, err)
+	}
+	if _, err := compilePattern("testLogin("); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+}
+
+func TestValidateExecutable(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "xcresulttool")
+	if err := os.WriteFile(executable, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write executable: %v", err)
+	}
+	plain := filepath.Join(dir, "README")
+	if err := os.WriteFile(plain, []byte("readme"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := validateExecutable(executable); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	for _, pth := range []string{plain, dir, filepath.Join(dir, "missing")} {
+		if err := validateExecutable(pth); err == nil {
+			t.Errorf("Expected an error for %s", pth)
+		}
+	}
+}
+
+func TestExecXCResultToolTimeout(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "xcresulttool")
+	// The child process keeps stdout open, it has to be killed as well
+	if err := os.WriteFile(executable, []byte("#!/bin/sh\necho started\nsleep 30 &\nwait\n"), 0755); err != nil {
+		t.Fatalf("Failed to write executable: %v", err)
+	}
+
+	defer func(pth string, timeout time.Duration) {
+		xcresultToolPath, xcresultToolTimeout = pth, timeout
+	}(xcresultToolPath, xcresultToolTimeout)
+	xcresultToolPath = executable
+
+	t.Run("hanging command is killed", func(t *testing.T) {
+		xcresultToolTimeout = 100 * time.Millisecond
+		start := time.Now()
+		_, err := execXCResultTool("version")
+		if err == nil || !strings.Contains(err.Error(), "didn't finish in 100ms") {
+			t.Errorf("Expected a timeout error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("Expected the command to be killed, it took %s", elapsed)
+		}
+	})
+
+	t.Run("command finishing in time", func(t *testing.T) {
+		if err := os.WriteFile(executable, []byte("#!/bin/sh\necho 'xcresulttool version 23021'\n"), 0755); err != nil {
+			t.Fatalf("Failed to write executable: %v", err)
+		}
+		xcresultToolTimeout = 10 * time.Second
+		output, err := execXCResultTool("version")
+		if err != nil {
+			t.Fatalf("execXCResultTool returned error: %v", err)
+		}
+		if string(output) != "xcresulttool version 23021\n" {
+			t.Errorf("Expected the output of the command, got %q", output)
+		}
+	})
+}
+
+func TestResolveDeveloperDir(t *testing.T) {
+	dir := t.TempDir()
+	xcode := filepath.Join(dir, "Xcode-16.2.app")
+	developer := filepath.Join(xcode, "Contents", "Developer")
+	if err := os.MkdirAll(developer, 0755); err != nil {
+		t.Fatalf("Failed to create developer dir: %v", err)
+	}
+
+	for _, pth := range []string{xcode, xcode + "/", developer} {
+		got, err := resolveDeveloperDir(pth)
+		if err != nil {
+			t.Errorf("Expected no error for %s, got %v", pth, err)
+		} else if got != developer {
+			t.Errorf("Expected %s for %s, got %s", developer, pth, got)
+		}
+	}
+
+	if _, err := resolveDeveloperDir(filepath.Join(dir, "Xcode-15.app")); err == nil {
+		t.Errorf("Expected an error for a missing Xcode")
+	}
+}
+
+func TestAttachmentsDir(t *testing.T) {
+	outputDir := t.TempDir()
+	first := attachmentsDir(outputDir, "/tmp/shard 1/Tests.xcresult")
+	if expected := filepath.Join(outputDir, "attachments", "Tests"); first != expected {
+		t.Errorf("Expected %s, got %s", expected, first)
+	}
+	if err := os.MkdirAll(first, 0755); err != nil {
+		t.Fatalf("Failed to create attachments dir: %v", err)
+	}
+	if second, expected := attachmentsDir(outputDir, "/tmp/shard 2/Tests.xcresult"), filepath.Join(outputDir, "attachments", "Tests_2"); second != expected {
+		t.Errorf("Expected %s, got %s", expected, second)
+	}
+}
+
+func TestReadExistingReport(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing report", func(t *testing.T) {
+		testSuites, err := readExistingReport(filepath.Join(dir, "missing.xml"))
+		if testSuites != nil || err != nil {
+			t.Errorf("Expected no report and no error, got %v, %v", testSuites, err)
+		}
+	})
+
+	t.Run("existing report", func(t *testing.T) {
+		pth := filepath.Join(dir, "junit.xml")
+		xmlData, err := MarshalJUnitXML(JUnitTestSuites{TestSuites: []JUnitTestSuite{{Name: "LoginTests", Tests: 1, TestCases: []JUnitTestCase{{Name: "testLogin()"}}}}})
+		if err != nil {
+			t.Fatalf("MarshalJUnitXML returned error: %v", err)
+		}
+		if err := os.WriteFile(pth, xmlData, 0644); err != nil {
+			t.Fatalf("Failed to write report: %v", err)
+		}
+
+		testSuites, err := readExistingReport(pth)
+		if err != nil {
+			t.Fatalf("readExistingReport returned error: %v", err)
+		}
+		if len(testSuites.TestSuites) != 1 || testSuites.TestSuites[0].Tests != 1 {
+			t.Errorf("Expected the LoginTests suite with 1 test, got %+v", testSuites.TestSuites)
+		}
+	})
+
+	t.Run("not a JUnit report", func(t *testing.T) {
+		pth := filepath.Join(dir, "report.xml")
+		if err := os.WriteFile(pth, []byte(`{"tests": 1}`), 0644); err != nil {
+			t.Fatalf("Failed to write report: %v", err)
+		}
+		if _, err := readExistingReport(pth); err == nil {
+			t.Errorf("Expected an error for a file which isn't a JUnit report")
+		}
+	})
+}
+
+func TestReadJSONInput(t *testing.T) {
+	dir := t.TempDir()
+	pth := filepath.Join(dir, "tests.json")
+	if err := os.WriteFile(pth, []byte(`{"testNodes": []}`), 0644); err != nil {
+		t.Fatalf("Failed to write JSON: %v", err)
+	}
+
+	t.Run("file", func(t *testing.T) {
+		jsonData, err := readJSONInput(pth, nil)
+		if err != nil {
+			t.Fatalf("readJSONInput returned error: %v", err)
+		}
+		if string(jsonData) != `{"testNodes": []}` {
+			t.Errorf("Expected the file content, got %s", jsonData)
+		}
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		jsonData, err := readJSONInput("-", strings.NewReader(`{"testPlanSummaries": []}`))
+		if err != nil {
+			t.Fatalf("readJSONInput returned error: %v", err)
+		}
+		if string(jsonData) != `{"testPlanSummaries": []}` {
+			t.Errorf("Expected the stdin content, got %s", jsonData)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := readJSONInput(filepath.Join(dir, "missing.json"), nil); err == nil {
+			t.Errorf("Expected an error for a missing file")
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		if _, err := readJSONInput("-", strings.NewReader(`{"testNodes": [`)); err == nil {
+			t.Errorf("Expected an error for invalid JSON")
+		}
+	})
+}
+
+func TestOutputFileMode(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		if mode, err := parseFileMode("0664"); err != nil || mode != 0664 {
+			t.Errorf("Expected 0664, got %o, %v", mode, err)
+		}
+		for _, value := range []string{"rw-r--r--", "0888", "01777", "-1"} {
+			if _, err := parseFileMode(value); err == nil {
+				t.Errorf("Expected an error for %s", value)
+			}
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		defer func(mode os.FileMode) { outputFileMode = mode }(outputFileMode)
+		outputFileMode = 0660
+
+		dir := filepath.Join(t.TempDir(), "reports")
+		if err := createOutputDir(dir); err != nil {
+			t.Fatalf("createOutputDir returned error: %v", err)
+		}
+		pth := filepath.Join(dir, "junit.xml")
+		if err := writeOutputFile(pth, []byte("<testsuites/>")); err != nil {
+			t.Fatalf("writeOutputFile returned error: %v", err)
+		}
+
+		for pth, expected := range map[string]os.FileMode{dir: 0770, pth: 0660} {
+			info, err := os.Stat(pth)
+			if err != nil {
+				t.Fatalf("Failed to stat %s: %v", pth, err)
+			}
+			if mode := info.Mode().Perm(); mode != expected {
+				t.Errorf("Expected mode %o for %s, got %o", expected, filepath.Base(pth), mode)
+			}
+		}
+	})
+}
+
+func TestCreateOutputDir(t *testing.T) {
+	defer func(mode os.FileMode) { outputFileMode = mode }(outputFileMode)
+	outputFileMode = 0660
+
+	t.Run("existing directory", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.Chmod(dir, 0755); err != nil {
+			t.Fatalf("Failed to chmod %s: %v", dir, err)
+		}
+		if err := createOutputDir(dir); err != nil {
+			t.Fatalf("createOutputDir returned error: %v", err)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", dir, err)
+		}
+		if mode := info.Mode().Perm(); mode != 0755 {
+			t.Errorf("Expected the existing directory to keep mode 755, got %o", mode)
+		}
+	})
+
+	t.Run("file in place of the directory", func(t *testing.T) {
+		pth := filepath.Join(t.TempDir(), "junit.xml")
+		if err := os.WriteFile(pth, nil, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", pth, err)
+		}
+		if err := createOutputDir(pth); err == nil {
+			t.Errorf("Expected an error for a file")
+		}
+	})
+}
+
+func TestPrepareOutputDirs(t *testing.T) {
+	t.Run("filename with directories", func(t *testing.T) {
+		dir := t.TempDir()
+		config := Config{OutputDir: filepath.Join(dir, "out"), JUnitFilename: filepath.Join("reports", "ios", "junit.xml")}
+		if err := prepareOutputDirs(config); err != nil {
+			t.Fatalf("prepareOutputDirs returned error: %v", err)
+		}
+		expected := filepath.Join(dir, "out", "reports", "ios", "junit.xml")
+		if pth := reportPath(config); pth != expected {
+			t.Errorf("Expected the report path %s, got %s", expected, pth)
+		}
+		if info, err := os.Stat(filepath.Dir(expected)); err != nil || !info.IsDir() {
+			t.Errorf("Expected the report directory to be created, got %v", err)
+		}
+		if entries, err := os.ReadDir(filepath.Dir(expected)); err != nil || len(entries) != 0 {
+			t.Errorf("Expected the writability check to leave no file, got %v, %v", entries, err)
+		}
+	})
+
+	t.Run("absolute filename", func(t *testing.T) {
+		dir := t.TempDir()
+		filename := filepath.Join(dir, "reports", "junit.xml")
+		config := Config{OutputDir: filepath.Join(dir, "out"), JUnitFilename: filename, OutputFormat: outputFormatBoth}
+		if err := prepareOutputDirs(config); err != nil {
+			t.Fatalf("prepareOutputDirs returned error: %v", err)
+		}
+		if pth := reportPath(config); pth != filename {
+			t.Errorf("Expected the absolute report path %s, got %s", filename, pth)
+		}
+		if pth := summaryPath(config); pth != filepath.Join(dir, "reports", "junit.json") {
+			t.Errorf("Expected the summary next to the report, got %s", pth)
+		}
+		if _, err := os.Stat(filepath.Dir(filename)); err != nil {
+			t.Errorf("Expected the report directory to be created, got %v", err)
+		}
+	})
+
+	t.Run("report path is a directory", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir, "junit.xml"), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := prepareOutputDirs(Config{OutputDir: dir, JUnitFilename: "junit.xml"}); err == nil {
+			t.Errorf("Expected an error for a directory in place of the report")
+		}
+	})
+}
+
+func TestGzipOutput(t *testing.T) {
+	config := Config{OutputDir: t.TempDir(), JUnitFilename: "junit.xml", OutputFormat: outputFormatJUnit, GzipOutput: true}
+	pth := reportPath(config)
+	if filepath.Base(pth) != "junit.xml.gz" {
+		t.Fatalf("Expected the report to be named junit.xml.gz, got %s", pth)
+	}
+
+	report := []byte(`<testsuites><testsuite name="LoginTests" tests="1"><testcase name="testLogin()"></testcase></testsuite></testsuites>`)
+	if err := writeGzipOutputFile(pth, report); err != nil {
+		t.Fatalf("writeGzipOutputFile returned error: %v", err)
+	}
+
+	file, err := os.Open(pth)
+	if err != nil {
+		t.Fatalf("Failed to open report: %v", err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Expected a gzip compressed report, got %v", err)
+	}
+	if data, err := io.ReadAll(zr); err != nil || !bytes.Equal(data, report) {
+		t.Errorf("Expected the compressed report, got %s, %v", data, err)
+	}
+
+	t.Run("append", func(t *testing.T) {
+		existing, err := readExistingReport(pth)
+		if err != nil {
+			t.Fatalf("readExistingReport returned error: %v", err)
+		}
+		if len(existing.TestSuites) != 1 || existing.TestSuites[0].Name != "LoginTests" {
+			t.Errorf("Expected the LoginTests suite, got %+v", existing.TestSuites)
+		}
+	})
+}
+
+func TestDumpXCResultJSON(t *testing.T) {
+	defer func(pth string, dumps int) {
+		jsonDumpPath, jsonDumps = pth, dumps
+	}(jsonDumpPath, jsonDumps)
+	dir := t.TempDir()
+	jsonDumpPath, jsonDumps = filepath.Join(dir, "debug", "tests.json"), 0
+
+	first := []byte(`{"testNodes": []}`)
+	second := []byte(`{"testNodes": [{"name": "AppTests"}]}`)
+	dumpXCResultJSON("First.xcresult", first)
+	dumpXCResultJSON("Second.xcresult", second)
+
+	for pth, expected := range map[string][]byte{"tests.json": first, "tests-2.json": second} {
+		data, err := os.ReadFile(filepath.Join(dir, "debug", pth))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", pth, err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("Expected %s to be %s, got %s", pth, expected, data)
+		}
+	}
+
+	t.Run("existing directory", func(t *testing.T) {
+		// The dump next to other files doesn't change the mode of their directory
+		shared := t.TempDir()
+		if err := os.Chmod(shared, 0755); err != nil {
+			t.Fatalf("Failed to chmod %s: %v", shared, err)
+		}
+		jsonDumpPath, jsonDumps = filepath.Join(shared, "tests.json"), 0
+		dumpXCResultJSON("Test.xcresult", first)
+
+		if _, err := os.Stat(jsonDumpPath); err != nil {
+			t.Errorf("Expected the JSON to be saved, got %v", err)
+		}
+		info, err := os.Stat(shared)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", shared, err)
+		}
+		if mode := info.Mode().Perm(); mode != 0755 {
+			t.Errorf("Expected the directory to keep mode 755, got %o", mode)
+		}
+	})
+
+	t.Run("unwritable path", func(t *testing.T) {
+		// A file in place of the directory fails the write, which is only a warning
+		jsonDumpPath = filepath.Join(dir, "debug", "tests.json", "tests.json")
+		dumpXCResultJSON("Test.xcresult", first)
+	})
+}
+
+func TestShellCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"xcrun", "xcresulttool", "get", "--path", "/tmp/Test.xcresult"}, "xcrun xcresulttool get --path /tmp/Test.xcresult"},
+		{[]string{"xcrun", "--path", "/tmp/My Tests.xcresult"}, "xcrun --path '/tmp/My Tests.xcresult'"},
+		{[]string{"echo", "it's", ""}, `echo 'it'\''s' ''`},
+	}
+
+	for _, tt := range tests {
+		if got := shellCommand(tt.args); got != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, got)
+		}
+	}
+}
+
+func TestXCResultToolError(t *testing.T) {
+	toolErr := &xcresultToolError{Command: "xcrun xcresulttool version", ExitCode: 1, Stderr: "Error: Resource temporarily unavailable"}
+	if expected := "command failed with exit code 1: Error: Resource temporarily unavailable"; toolErr.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, toolErr.Error())
+	}
+	if !isTransientError(toolErr) {
+		t.Errorf("Expected %q to be a transient error", toolErr)
+	}
+
+	var target *xcresultToolError
+	if !errors.As(fmt.Errorf("failed to export: %w", toolErr), &target) || target.Command != toolErr.Command {
+		t.Errorf("Expected the wrapped error to be found")
+	}
+}
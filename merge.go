@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitXCResultPaths splits a newline or pipe separated list of xcresult paths
+func splitXCResultPaths(value string) []string {
+	var paths []string
+	for _, line := range strings.Split(value, "\n") {
+		for _, pth := range strings.Split(line, "|") {
+			if pth = strings.TrimSpace(pth); pth != "" {
+				paths = append(paths, pth)
+			}
+		}
+	}
+	return paths
+}
+
+// MergeXCResultJSON merges the test results of several xcresult bundles (e.g.
+// parallel test shards) into a single XCResult JSON document. Containers with
+// the same name and node type are combined so their suites end up in one
+// JUnit test suite. The test cases are marked with the bundle they come from,
+// the runs of a test in different bundles aren't re-runs of each other.
+func MergeXCResultJSON(jsonDocs ...[]byte) ([]byte, error) {
+	if len(jsonDocs) == 1 {
+		return jsonDocs[0], nil
+	}
+
+	var merged XCResultRoot
+	knownDevices := map[string]bool{}
+	for i, jsonData := range jsonDocs {
+		var root XCResultRoot
+		if err := json.Unmarshal(jsonData, &root); err != nil {
+			return nil, fmt.Errorf("failed to parse XCResult JSON #%d: %w", i+1, err)
+		}
+
+		for _, device := range root.Devices {
+			if device.DeviceID != "" && knownDevices[device.DeviceID] {
+				continue
+			}
+			knownDevices[device.DeviceID] = true
+			merged.Devices = append(merged.Devices, device)
+		}
+
+		if root.StartTime > 0 && (merged.StartTime == 0 || root.StartTime < merged.StartTime) {
+			merged.StartTime = root.StartTime
+		}
+
+		markSource(root.TestNodes, i+1)
+		merged.TestNodes = mergeTestNodes(merged.TestNodes, root.TestNodes)
+	}
+
+	return json.Marshal(merged)
+}
+
+// markSource sets the source bundle of the test cases among the nodes
+func markSource(nodes []TestNode, source int) {
+	for i := range nodes {
+		if classifyNode(nodes[i]) == nodeKindTestCase {
+			nodes[i].Source = source
+			continue
+		}
+		markSource(nodes[i].Children, source)
+	}
+}
+
+// mergeTestNodes appends the nodes to the existing ones, combining the
+// containers which are present in both
+func mergeTestNodes(existing, nodes []TestNode) []TestNode {
+	for _, node := range nodes {
+		idx := -1
+		if classifyNode(node) != nodeKindTestCase && len(node.Children) > 0 {
+			for i, other := range existing {
+				if other.Name == node.Name && strings.EqualFold(other.NodeType, node.NodeType) && classifyNode(other) != nodeKindTestCase {
+					idx = i
+					break
+				}
+			}
+		}
+
+		if idx == -1 {
+			existing = append(existing, node)
+			continue
+		}
+
+		target := &existing[idx]
+		target.Children = mergeTestNodes(target.Children, node.Children)
+		target.Duration = formatDuration(parseDuration(string(target.Duration)) + parseDuration(string(node.Duration)))
+		target.Result = mergeResult(target.Result, node.Result)
+	}
+	return existing
+}
+
+// mergeResult returns the result of a combined container, which fails if
+// either of its parts failed
+func mergeResult(a, b string) string {
+	if a == "Failed" || b == "Failed" {
+		return "Failed"
+	}
+	if a == "" {
+		return b
+	}
+	return a
+}
+
+func formatDuration(seconds float64) Duration {
+	return Duration(strconv.FormatFloat(seconds, 'f', -1, 64) + "s")
+}
+
+// AppendJUnitTestSuites appends the test suites of a conversion to the ones of
+// an existing report. Suites with the same name are combined: their test cases
+// and nested suites are merged and their counts and times summed.
+func AppendJUnitTestSuites(existing, added JUnitTestSuites) JUnitTestSuites {
+	return JUnitTestSuites{TestSuites: appendSuites(existing.TestSuites, added.TestSuites)}
+}
+
+func appendSuites(existing, added []JUnitTestSuite) []JUnitTestSuite {
+	merged := append([]JUnitTestSuite{}, existing...)
+	for _, suite := range added {
+		idx := -1
+		for i := range merged {
+			if merged[i].Name == suite.Name {
+				idx = i
+				break
+			}
+		}
+
+		if idx == -1 {
+			merged = append(merged, suite)
+			continue
+		}
+
+		target := &merged[idx]
+		target.TestCases = append(target.TestCases, suite.TestCases...)
+		target.TestSuites = appendSuites(target.TestSuites, suite.TestSuites)
+		target.Tests += suite.Tests
+		target.Failures += suite.Failures
+		target.Errors += suite.Errors
+		target.Skipped += suite.Skipped
+		target.Time += suite.Time
+	}
+	return merged
+}
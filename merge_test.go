@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitXCResultPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{"single path", "Test.xcresult", []string{"Test.xcresult"}},
+		{"pipe separated", "a.xcresult|b.xcresult", []string{"a.xcresult", "b.xcresult"}},
+		{"newline separated", "a.xcresult\nb.xcresult\n", []string{"a.xcresult", "b.xcresult"}},
+		{"mixed with blanks", " a.xcresult | \n\nb.xcresult|c.xcresult ", []string{"a.xcresult", "b.xcresult", "c.xcresult"}},
+		{"empty", "  ", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitXCResultPaths(tt.value); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMergeXCResultJSON(t *testing.T) {
+	shard1 := []byte(`{
+  "startTime": 1714557700,
+  "devices": [{"deviceId": "A", "deviceName": "iPhone 15"}],
+  "testNodes": [
+    {
+      "name": "AppTests", "nodeType": "Unit test bundle", "duration": "2s", "result": "Passed",
+      "children": [
+        {
+          "name": "LoginTests", "nodeType": "Test Suite", "duration": "2s", "result": "Passed",
+          "children": [
+            {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "duration": "2s", "result": "Passed"}
+          ]
+        }
+      ]
+    }
+  ]
+}`)
+	shard2 := []byte(`{
+  "startTime": 1714557600,
+  "devices": [{"deviceId": "A", "deviceName": "iPhone 15"}],
+  "testNodes": [
+    {
+      "name": "AppTests", "nodeType": "Unit test bundle", "duration": "3s", "result": "Failed",
+      "children": [
+        {
+          "name": "LoginTests", "nodeType": "Test Suite", "duration": "1s", "result": "Failed",
+          "children": [
+            {"name": "testLogout()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogout()", "duration": "1s", "result": "Failed"}
+          ]
+        },
+        {
+          "name": "ProfileTests", "nodeType": "Test Suite", "duration": "2s", "result": "Passed",
+          "children": [
+            {"name": "testAvatar()", "nodeType": "Test Case", "nodeIdentifier": "ProfileTests/testAvatar()", "duration": "2s", "result": "Passed"}
+          ]
+        }
+      ]
+    }
+  ]
+}`)
+
+	merged, err := MergeXCResultJSON(shard1, shard2)
+	if err != nil {
+		t.Fatalf("MergeXCResultJSON returned error: %v", err)
+	}
+
+	for _, preserveHierarchy := range []bool{false, true} {
+		t.Run(map[bool]string{false: "flat", true: "hierarchy"}[preserveHierarchy], func(t *testing.T) {
+			testSuites, err := ConvertXCResultJSONToTestSuites(merged, ConvertOptions{PreserveHierarchy: preserveHierarchy})
+			if err != nil {
+				t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+			}
+
+			suites := testSuites.TestSuites
+			if preserveHierarchy {
+				if len(suites) != 1 {
+					t.Fatalf("Expected 1 test bundle, got %d", len(suites))
+				}
+				if suites[0].Tests != 3 || suites[0].Failures != 1 {
+					t.Errorf("Expected bundle with 3 tests and 1 failure, got %d tests and %d failures", suites[0].Tests, suites[0].Failures)
+				}
+				suites = suites[0].TestSuites
+			}
+
+			if len(suites) != 2 {
+				t.Fatalf("Expected 2 test suites, got %d", len(suites))
+			}
+			login := suites[0]
+			if login.Name != "LoginTests" {
+				t.Fatalf("Expected suite LoginTests, got %s", login.Name)
+			}
+			if login.Tests != 2 || login.Failures != 1 {
+				t.Errorf("Expected LoginTests with 2 tests and 1 failure, got %d tests and %d failures", login.Tests, login.Failures)
+			}
+		})
+	}
+
+	t.Run("source bundles", func(t *testing.T) {
+		var root XCResultRoot
+		if err := json.Unmarshal(merged, &root); err != nil {
+			t.Fatalf("Failed to parse merged JSON: %v", err)
+		}
+		login := root.TestNodes[0].Children[0]
+		if len(login.Children) != 2 || login.Children[0].Source != 1 || login.Children[1].Source != 2 {
+			t.Errorf("Expected the test cases of LoginTests marked with their bundle, got %+v", login.Children)
+		}
+		if root.TestNodes[0].Source != 0 || login.Source != 0 {
+			t.Errorf("Expected no source on the containers")
+		}
+	})
+
+	t.Run("devices and start time", func(t *testing.T) {
+		testSuites, err := ConvertXCResultJSONToTestSuites(merged, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+		}
+		tc := testSuites.TestSuites[0].TestCases[0]
+		if !reflect.DeepEqual(tc.Devices, []string{"iPhone 15"}) {
+			t.Errorf("Expected devices [iPhone 15], got %v", tc.Devices)
+		}
+		if timestamp := testSuites.TestSuites[0].Timestamp; timestamp != runTimestamp(time.Time{}, 1714557600) {
+			t.Errorf("Expected the earliest start time, got %s", timestamp)
+		}
+	})
+
+	t.Run("single document is returned as is", func(t *testing.T) {
+		got, err := MergeXCResultJSON(shard1)
+		if err != nil {
+			t.Fatalf("MergeXCResultJSON returned error: %v", err)
+		}
+		if string(got) != string(shard1) {
+			t.Errorf("Expected the document to be unchanged")
+		}
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		if _, err := MergeXCResultJSON(shard1, []byte("{")); err == nil {
+			t.Errorf("Expected an error for invalid JSON")
+		}
+	})
+}
+
+func TestMergeXCResultJSONSameTest(t *testing.T) {
+	// The same test failed on one device and passed on the other
+	bundle := func(device, result string) []byte {
+		return []byte(`{
+  "devices": [{"deviceId": "` + device + `", "deviceName": "` + device + `"}],
+  "testNodes": [{"name": "AppTests", "nodeType": "Unit test bundle", "children": [
+    {"name": "LoginTests", "nodeType": "Test Suite", "children": [
+      {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "` + result + `"}
+    ]}
+  ]}]
+}`)
+	}
+
+	merged, err := MergeXCResultJSON(bundle("iPhone 15", "Failed"), bundle("iPad", "Passed"))
+	if err != nil {
+		t.Fatalf("MergeXCResultJSON returned error: %v", err)
+	}
+	testSuites, err := ConvertXCResultJSONToTestSuites(merged, ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	login := testSuites.TestSuites[0]
+	if len(login.TestCases) != 2 || login.Tests != 2 || login.Failures != 1 {
+		t.Fatalf("Expected 2 test cases with 1 failure, got %d test cases, tests=%d, failures=%d", len(login.TestCases), login.Tests, login.Failures)
+	}
+	if len(login.TestCases[0].FlakyFailures) > 0 || login.TestCases[0].Failure == nil || login.TestCases[1].Failure != nil {
+		t.Errorf("Expected a failed and a passed test case, got %+v", login.TestCases)
+	}
+}
+
+func TestAppendJUnitTestSuites(t *testing.T) {
+	existing := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name: "AppTests", Tests: 2, Failures: 1, Time: 3, Timestamp: "2024-05-01T10:00:00Z",
+				TestSuites: []JUnitTestSuite{
+					{Name: "LoginTests", Tests: 2, Failures: 1, Time: 3, TestCases: []JUnitTestCase{{Name: "testLogin()"}, {Name: "testLogout()"}}},
+				},
+			},
+		},
+	}
+	added := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{
+				Name: "AppTests", Tests: 2, Skipped: 1, Time: 1, Timestamp: "2024-05-01T11:00:00Z",
+				TestSuites: []JUnitTestSuite{
+					{Name: "LoginTests", Tests: 1, Time: 0.5, TestCases: []JUnitTestCase{{Name: "testSSO()"}}},
+					{Name: "ProfileTests", Tests: 1, Skipped: 1, Time: 0.5, TestCases: []JUnitTestCase{{Name: "testAvatar()"}}},
+				},
+			},
+			{Name: "AppUITests", Tests: 1, Errors: 1, Time: 5, TestCases: []JUnitTestCase{{Name: "testOnboarding()"}}},
+		},
+	}
+
+	merged := AppendJUnitTestSuites(existing, added)
+	if len(merged.TestSuites) != 2 {
+		t.Fatalf("Expected 2 test suites, got %d", len(merged.TestSuites))
+	}
+
+	app := merged.TestSuites[0]
+	if app.Tests != 4 || app.Failures != 1 || app.Skipped != 1 || app.Time != 4 {
+		t.Errorf("Expected AppTests with 4 tests, 1 failure, 1 skipped in 4s, got %+v", app)
+	}
+	if app.Timestamp != "2024-05-01T10:00:00Z" {
+		t.Errorf("Expected the timestamp of the existing suite, got %s", app.Timestamp)
+	}
+	if len(app.TestSuites) != 2 || len(app.TestSuites[0].TestCases) != 3 || app.TestSuites[0].Tests != 3 {
+		t.Errorf("Expected LoginTests with 3 test cases and ProfileTests appended, got %+v", app.TestSuites)
+	}
+	if merged.TestSuites[1].Name != "AppUITests" {
+		t.Errorf("Expected AppUITests appended, got %s", merged.TestSuites[1].Name)
+	}
+	if len(existing.TestSuites[0].TestSuites[0].TestCases) != 2 {
+		t.Errorf("Expected the existing suites to be left untouched")
+	}
+	if err := validateSuiteCounts(merged); err != nil {
+		t.Errorf("Expected consistent counts, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// defaultMaxPercentRegression is the regression Xcode tolerates when the
+// baseline of a metric doesn't set one
+const defaultMaxPercentRegression = 10
+
+// performanceMetric is a metric measured by a performance test, with the
+// measurements of every run of the test
+type performanceMetric struct {
+	DisplayName          string    `json:"displayName"`
+	UnitOfMeasurement    string    `json:"unitOfMeasurement"`
+	Measurements         []float64 `json:"measurements"`
+	BaselineAverage      *float64  `json:"baselineAverage"`
+	MaxPercentRegression *float64  `json:"maxPercentRegression"`
+}
+
+// testMetrics is the output of `xcresulttool get test-results metrics`,
+// listing the metrics of the performance tests per test run
+type testMetrics []struct {
+	TestIdentifier string `json:"testIdentifier"`
+	TestRuns       []struct {
+		Metrics []performanceMetric `json:"metrics"`
+	} `json:"testRuns"`
+}
+
+// readPerformanceMetrics returns the metrics of the performance tests of the
+// bundle by test identifier
+func readPerformanceMetrics(xcresultPath string) (map[string][]performanceMetric, error) {
+	output, err := runXCResultTool("get", "test-results", "metrics", "--path", xcresultPath)
+	if err != nil {
+		return nil, err
+	}
+	return parsePerformanceMetrics(output)
+}
+
+// parsePerformanceMetrics returns the metrics by test identifier. The
+// measurements of a metric in several runs (e.g. on several devices) are
+// merged.
+func parsePerformanceMetrics(data []byte) (map[string][]performanceMetric, error) {
+	var tests testMetrics
+	if err := json.Unmarshal(data, &tests); err != nil {
+		return nil, fmt.Errorf("failed to parse performance metrics: %w", err)
+	}
+
+	metrics := map[string][]performanceMetric{}
+	for _, test := range tests {
+		index := map[string]int{}
+		for _, run := range test.TestRuns {
+			for _, metric := range run.Metrics {
+				if idx, seen := index[metric.DisplayName]; seen {
+					merged := &metrics[test.TestIdentifier][idx]
+					merged.Measurements = append(merged.Measurements, metric.Measurements...)
+					continue
+				}
+				index[metric.DisplayName] = len(metrics[test.TestIdentifier])
+				metrics[test.TestIdentifier] = append(metrics[test.TestIdentifier], metric)
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// addPerformanceProperties adds the average of every metric of the
+// performance tests as a property of the test case. A metric with a baseline
+// also gets its baseline average, the change compared to it and whether the
+// change is a regression.
+func addPerformanceProperties(testSuites *JUnitTestSuites, metrics map[string][]performanceMetric) {
+	walkSuites(testSuites.TestSuites, func(suite *JUnitTestSuite) {
+		for i := range suite.TestCases {
+			tc := &suite.TestCases[i]
+			for _, metric := range metrics[tc.identifier] {
+				if len(metric.Measurements) == 0 {
+					continue
+				}
+				prefix := "performance." + metric.DisplayName + "."
+				average := averageOf(metric.Measurements)
+				tc.addProperty(prefix+"average", formatMeasurement(average, metric.UnitOfMeasurement))
+
+				if metric.BaselineAverage == nil || *metric.BaselineAverage == 0 {
+					continue
+				}
+				baseline := *metric.BaselineAverage
+				change := (average - baseline) / baseline * 100
+				maxRegression := float64(defaultMaxPercentRegression)
+				if metric.MaxPercentRegression != nil {
+					maxRegression = *metric.MaxPercentRegression
+				}
+				result := "within baseline"
+				if change > maxRegression {
+					result = "regression"
+				}
+
+				tc.addProperty(prefix+"baseline", formatMeasurement(baseline, metric.UnitOfMeasurement))
+				tc.addProperty(prefix+"change", fmt.Sprintf("%+.1f%%", change))
+				tc.addProperty(prefix+"result", result)
+			}
+		}
+	})
+}
+
+// averageOf returns the average of the values
+func averageOf(values []float64) float64 {
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// formatMeasurement formats the value with its unit, e.g. "0.123 s"
+func formatMeasurement(value float64, unit string) string {
+	formatted := strconv.FormatFloat(value, 'f', 3, 64)
+	if unit == "" {
+		return formatted
+	}
+	return formatted + " " + unit
+}
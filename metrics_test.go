@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPerformanceProperties(t *testing.T) {
+	metricsJSON := []byte(`[
+  {
+    "testIdentifier": "LaunchTests/testLaunchPerformance()",
+    "testRuns": [
+      {"metrics": [
+        {"displayName": "Clock Monotonic Time", "unitOfMeasurement": "s", "measurements": [0.1, 0.14], "baselineAverage": 0.1, "maxPercentRegression": 10},
+        {"displayName": "Memory Physical", "unitOfMeasurement": "kB", "measurements": [2048]}
+      ]},
+      {"metrics": [
+        {"displayName": "Clock Monotonic Time", "unitOfMeasurement": "s", "measurements": [0.12], "baselineAverage": 0.1, "maxPercentRegression": 10}
+      ]}
+    ]
+  },
+  {
+    "testIdentifier": "ParserTests/testParsePerformance()",
+    "testRuns": [
+      {"metrics": [
+        {"displayName": "Time", "unitOfMeasurement": "s", "measurements": [0.5], "baselineAverage": 0.48}
+      ]}
+    ]
+  }
+]`)
+
+	metrics, err := parsePerformanceMetrics(metricsJSON)
+	if err != nil {
+		t.Fatalf("parsePerformanceMetrics returned error: %v", err)
+	}
+	if launch := metrics["LaunchTests/testLaunchPerformance()"]; len(launch) != 2 || len(launch[0].Measurements) != 3 {
+		t.Fatalf("Expected 2 metrics with the measurements of both runs merged, got %+v", launch)
+	}
+
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{Name: "LaunchTests", TestCases: []JUnitTestCase{
+				{Name: "testLaunchPerformance()", identifier: "LaunchTests/testLaunchPerformance()"},
+				{Name: "testLaunch()", identifier: "LaunchTests/testLaunch()"},
+			}},
+			{Name: "ParserTests", TestCases: []JUnitTestCase{
+				{Name: "testParsePerformance()", identifier: "ParserTests/testParsePerformance()"},
+			}},
+		},
+	}
+	addPerformanceProperties(&testSuites, metrics)
+
+	launch := testSuites.TestSuites[0].TestCases
+	expected := []JUnitProperty{
+		{Name: "performance.Clock Monotonic Time.average", Value: "0.120 s"},
+		{Name: "performance.Clock Monotonic Time.baseline", Value: "0.100 s"},
+		{Name: "performance.Clock Monotonic Time.change", Value: "+20.0%"},
+		{Name: "performance.Clock Monotonic Time.result", Value: "regression"},
+		{Name: "performance.Memory Physical.average", Value: "2048.000 kB"},
+	}
+	if launch[0].Properties == nil || !reflect.DeepEqual(launch[0].Properties.Properties, expected) {
+		t.Errorf("Expected properties %v, got %+v", expected, launch[0].Properties)
+	}
+	if launch[1].Properties != nil {
+		t.Errorf("Expected no properties for testLaunch(), got %+v", launch[1].Properties)
+	}
+
+	parser := testSuites.TestSuites[1].TestCases[0]
+	if parser.Properties == nil || parser.Properties.Properties[3].Value != "within baseline" {
+		t.Errorf("Expected a change within the default maximum regression, got %+v", parser.Properties)
+	}
+
+	t.Run("invalid metrics", func(t *testing.T) {
+		if _, err := parsePerformanceMetrics([]byte(`{"metrics"`)); err == nil {
+			t.Errorf("Expected an error for invalid JSON")
+		}
+	})
+}
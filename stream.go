@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// The test results JSON of a big UI test run can be hundreds of MB. Instead
+// of unmarshalling the whole node tree, the flat conversion scans it node by
+// node: the children of containers are processed into the suites one by one,
+// only test case nodes (with their issues, devices and repetitions) are held
+// in memory at once. The children are located in the JSON without copying
+// them and processed once the name and node type of their parent are known,
+// xcresulttool sorts the keys so the children precede them.
+
+// decodeTestResults decodes the test results JSON. In flat mode the test nodes
+// are processed while decoding and the returned root has no test nodes.
+func (c *converter) decodeTestResults(jsonData []byte, streamNodes bool) (XCResultRoot, error) {
+	var root XCResultRoot
+	err := scanObject(jsonData, func(key string, value []byte) error {
+		switch {
+		case key == "devices":
+			if err := json.Unmarshal(value, &root.Devices); err != nil {
+				return err
+			}
+			c.devices = root.Devices
+		case key == "startTime":
+			return json.Unmarshal(value, &root.StartTime)
+		case key == "testNodes" && streamNodes:
+			return c.streamTestNodes(value, "")
+		case key == "testNodes":
+			return json.Unmarshal(value, &root.TestNodes)
+		}
+		return nil
+	})
+	return root, err
+}
+
+// streamTestNodes processes the JSON array of test nodes
+func (c *converter) streamTestNodes(data []byte, classname string) error {
+	c.depth++
+	defer func() { c.depth-- }()
+	return scanArray(data, func(value []byte) error {
+		return c.streamTestNode(value, classname)
+	})
+}
+
+// streamTestNode processes the JSON test node. The node is decoded without
+// its children, the children of a container are streamed, the other nodes
+// are decoded as a whole and processed as usual. So are the nodes at the
+// deepest level processed, whose children are dropped.
+func (c *converter) streamTestNode(data []byte, classname string) error {
+	var children []byte
+	fields := []byte{'{'}
+	err := scanObject(data, func(key string, value []byte) error {
+		if key == "children" {
+			children = value
+			return nil
+		}
+
+		quoted, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		if len(fields) > 1 {
+			fields = append(fields, ',')
+		}
+		fields = append(append(append(fields, quoted...), ':'), value...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var node TestNode
+	if err := json.Unmarshal(append(fields, '}'), &node); err != nil {
+		return err
+	}
+
+	if children != nil && c.depth < c.maxNodeDepth() {
+		switch classifyNodeType(node.NodeType) {
+		case nodeKindContainer:
+			log.Debugf("Container %s (%s)", describeNode(node), node.NodeType)
+			bundle := c.bundle
+			if isBundleNode(node) {
+				c.bundle = node.Name
+			}
+			err = c.streamTestNodes(children, buildClassName(classname, node.Name))
+			c.bundle = bundle
+			if err != nil {
+				return err
+			}
+			if isSuiteNode(node) {
+				c.suiteDurations[c.configurationKey(node.Name)] += c.nodeDuration(node)
+			}
+			return nil
+		case nodeKindPassThrough:
+			log.Debugf("Passing through %s (%s)", describeNode(node), node.NodeType)
+			c.withConfiguration(node, func() {
+				err = c.streamTestNodes(children, classname)
+			})
+			return err
+		}
+	}
+
+	if children != nil {
+		if err := json.Unmarshal(children, &node.Children); err != nil {
+			return err
+		}
+	}
+	nodes := []TestNode{node}
+	c.limitNodeDepth(nodes, c.depth)
+	c.processTestNodes(nodes, classname)
+	return nil
+}
+
+// scanObject calls fn with the key and the raw value of every field of the
+// JSON object in data. The values are slices of data, they are only checked
+// to be complete, fn decodes them.
+func scanObject(data []byte, fn func(key string, value []byte) error) error {
+	i := skipSpace(data, 0)
+	if err := expectByte(data, i, '{'); err != nil {
+		return err
+	}
+	i = skipSpace(data, i+1)
+	if i < len(data) && data[i] == '}' {
+		return expectEnd(data, i+1)
+	}
+
+	for {
+		if err := expectByte(data, i, '"'); err != nil {
+			return err
+		}
+		end, err := stringEnd(data, i)
+		if err != nil {
+			return err
+		}
+		var key string
+		if err := json.Unmarshal(data[i:end], &key); err != nil {
+			return err
+		}
+
+		i = skipSpace(data, end)
+		if err := expectByte(data, i, ':'); err != nil {
+			return err
+		}
+		i = skipSpace(data, i+1)
+		if end, err = valueEnd(data, i); err != nil {
+			return err
+		}
+		if err := fn(key, data[i:end]); err != nil {
+			return err
+		}
+
+		i = skipSpace(data, end)
+		if i < len(data) && data[i] == ',' {
+			i = skipSpace(data, i+1)
+			continue
+		}
+		if err := expectByte(data, i, '}'); err != nil {
+			return err
+		}
+		return expectEnd(data, i+1)
+	}
+}
+
+// scanArray calls fn with every raw element of the JSON array (or null) in
+// data, like scanObject
+func scanArray(data []byte, fn func(value []byte) error) error {
+	i := skipSpace(data, 0)
+	if bytes.HasPrefix(data[i:], []byte("null")) {
+		return expectEnd(data, i+len("null"))
+	}
+	if err := expectByte(data, i, '['); err != nil {
+		return err
+	}
+	i = skipSpace(data, i+1)
+	if i < len(data) && data[i] == ']' {
+		return expectEnd(data, i+1)
+	}
+
+	for {
+		end, err := valueEnd(data, i)
+		if err != nil {
+			return err
+		}
+		if err := fn(data[i:end]); err != nil {
+			return err
+		}
+
+		i = skipSpace(data, end)
+		if i < len(data) && data[i] == ',' {
+			i = skipSpace(data, i+1)
+			continue
+		}
+		if err := expectByte(data, i, ']'); err != nil {
+			return err
+		}
+		return expectEnd(data, i+1)
+	}
+}
+
+// valueEnd returns the offset following the JSON value starting at offset i
+// of data. Objects and arrays are matched by their brackets only.
+func valueEnd(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, errUnexpectedEnd
+	}
+
+	switch data[i] {
+	case '"':
+		return stringEnd(data, i)
+	case '{', '[':
+		var open []byte
+		for ; i < len(data); i++ {
+			switch b := data[i]; b {
+			case '"':
+				end, err := stringEnd(data, i)
+				if err != nil {
+					return 0, err
+				}
+				i = end - 1
+			case '{', '[':
+				open = append(open, b)
+			case '}', ']':
+				expected := byte('{')
+				if b == ']' {
+					expected = '['
+				}
+				if open[len(open)-1] != expected {
+					return 0, fmt.Errorf("invalid JSON: unexpected %c at offset %d", b, i)
+				}
+				open = open[:len(open)-1]
+				if len(open) == 0 {
+					return i + 1, nil
+				}
+			}
+		}
+		return 0, errUnexpectedEnd
+	default:
+		// A number or a literal, checked when decoded
+		start := i
+		for i < len(data) && !bytes.ContainsRune([]byte(",:{}[] \t\r\n"), rune(data[i])) {
+			i++
+		}
+		if i == start {
+			return 0, fmt.Errorf("invalid JSON: unexpected %c at offset %d", data[i], i)
+		}
+		return i, nil
+	}
+}
+
+// stringEnd returns the offset following the JSON string starting at offset i
+// of data
+func stringEnd(data []byte, i int) (int, error) {
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, errUnexpectedEnd
+}
+
+// errUnexpectedEnd is returned for truncated JSON
+var errUnexpectedEnd = errors.New("invalid JSON: unexpected end of input")
+
+// skipSpace returns the offset of the first non-whitespace byte of data from i
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// expectByte checks that data holds the expected byte at offset i
+func expectByte(data []byte, i int, expected byte) error {
+	if i >= len(data) {
+		return errUnexpectedEnd
+	}
+	if data[i] != expected {
+		return fmt.Errorf("invalid JSON: expected %c, got %c at offset %d", expected, data[i], i)
+	}
+	return nil
+}
+
+// expectEnd checks that only whitespace follows offset i of data
+func expectEnd(data []byte, i int) error {
+	if i = skipSpace(data, i); i < len(data) {
+		return fmt.Errorf("invalid JSON: unexpected %c at offset %d", data[i], i)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStreamedConversion(t *testing.T) {
+	// Keys in mixed order: the children precede the name and node type of some
+	// containers, as in the sorted xcresulttool output, and the devices follow
+	// the test nodes
+	jsonData := []byte(`{
+  "testNodes": [
+    {
+      "children": [
+        {
+          "nodeType": "Test Suite",
+          "children": [
+            {"children": [{"name": "Timed out", "nodeType": "Failure Message"}], "name": "testSync()", "nodeType": "Test Case", "nodeIdentifier": "SyncTests/testSync()", "result": "Failed", "duration": "2s"}
+          ],
+          "name": "SyncTests",
+          "duration": "5s"
+        },
+        {
+          "name": "LoginTests",
+          "nodeType": "Test Suite",
+          "duration": "3s",
+          "children": [
+            {"name": "testLogin()", "nodeType": "Test Case", "nodeIdentifier": "LoginTests/testLogin()", "result": "Passed", "duration": "1s"}
+          ]
+        }
+      ],
+      "name": "AppTests",
+      "nodeType": "Unit test bundle"
+    }
+  ],
+  "testPlanConfigurations": [{"configurationId": "1", "configurationName": "Default"}],
+  "devices": [{"deviceId": "A", "deviceName": "iPhone 15"}]
+}`)
+
+	testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{SuiteTimeSource: SuiteTimeFromXCResult})
+	if err != nil {
+		t.Fatalf("ConvertXCResultJSONToTestSuites returned error: %v", err)
+	}
+
+	if len(testSuites.TestSuites) != 2 {
+		t.Fatalf("Expected 2 test suites, got %d", len(testSuites.TestSuites))
+	}
+	login, sync := testSuites.TestSuites[0], testSuites.TestSuites[1]
+	if login.Name != "LoginTests" || login.Time != 3 {
+		t.Errorf("Expected LoginTests with the reported time 3, got %s with %f", login.Name, login.Time)
+	}
+	if sync.Name != "SyncTests" || sync.Failures != 1 || sync.Time != 5 {
+		t.Errorf("Expected SyncTests with 1 failure and the reported time 5, got %s with %d and %f", sync.Name, sync.Failures, sync.Time)
+	}
+
+	tc := sync.TestCases[0]
+	if tc.Classname != "AppTests.SyncTests" {
+		t.Errorf("Expected classname AppTests.SyncTests, got %s", tc.Classname)
+	}
+	if tc.Failure == nil || tc.Failure.Message != "Timed out" {
+		t.Errorf("Expected the failure message, got %v", tc.Failure)
+	}
+	if !reflect.DeepEqual(tc.FailedDevices, []string{"iPhone 15"}) {
+		t.Errorf("Expected the device declared after the test nodes, got %v", tc.FailedDevices)
+	}
+}
+
+func TestStreamedConversionSortedKeys(t *testing.T) {
+	// xcresulttool sorts the keys, the fixture with the same results lists the
+	// children before the name and node type of every node
+	var reports [][]byte
+	for _, fixture := range []string{"test_results.json", "test_results_sorted.json"} {
+		jsonData, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Fatalf("Failed to read fixture: %v", err)
+		}
+		testSuites, err := ConvertXCResultJSONToTestSuites(jsonData, ConvertOptions{})
+		if err != nil {
+			t.Fatalf("ConvertXCResultJSONToTestSuites returned error for %s: %v", fixture, err)
+		}
+		xmlData, err := MarshalJUnitXML(testSuites)
+		if err != nil {
+			t.Fatalf("MarshalJUnitXML returned error: %v", err)
+		}
+		reports = append(reports, xmlData)
+	}
+
+	if !bytes.Equal(reports[0], reports[1]) {
+		t.Errorf("Expected the same report for sorted keys, got:\n%s\nand:\n%s", reports[0], reports[1])
+	}
+}
+
+func TestStreamedConversionInvalidJSON(t *testing.T) {
+	for _, jsonData := range []string{
+		`{"testNodes": [{"name": "AppTests", "nodeType": "Unit test bundle", "children": [`,
+		`{"testNodes": {}}`,
+		`{"testNodes": [{"children": [{"name": "testA()"]}]}`,
+		`{"testNodes": [{"children": [], "name": "AppTests"}] "devices": []}`,
+		`[]`,
+	} {
+		if _, err := ConvertXCResultJSONToTestSuites([]byte(jsonData), ConvertOptions{}); err == nil {
+			t.Errorf("Expected an error for %s", jsonData)
+		}
+	}
+}
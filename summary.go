@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// TestSummary is a compact, machine readable summary of the test results
+type TestSummary struct {
+	Tests    int            `json:"tests"`
+	Failures int            `json:"failures"`
+	Errors   int            `json:"errors"`
+	Skipped  int            `json:"skipped"`
+	Time     float64        `json:"time"`
+	Suites   []SuiteSummary `json:"suites"`
+}
+
+// SuiteSummary holds the counts and the duration of a top level test suite
+type SuiteSummary struct {
+	Name     string  `json:"name"`
+	Tests    int     `json:"tests"`
+	Failures int     `json:"failures"`
+	Errors   int     `json:"errors"`
+	Skipped  int     `json:"skipped"`
+	Time     float64 `json:"time"`
+}
+
+// NewTestSummary summarizes the test suites, nested suites are counted in
+// their top level suite
+func NewTestSummary(testSuites JUnitTestSuites) TestSummary {
+	summary := TestSummary{Suites: []SuiteSummary{}}
+	for _, suite := range testSuites.TestSuites {
+		summary.Suites = append(summary.Suites, SuiteSummary{
+			Name:     suite.Name,
+			Tests:    suite.Tests,
+			Failures: suite.Failures,
+			Errors:   suite.Errors,
+			Skipped:  suite.Skipped,
+			Time:     suite.Time,
+		})
+		summary.Tests += suite.Tests
+		summary.Failures += suite.Failures
+		summary.Errors += suite.Errors
+		summary.Skipped += suite.Skipped
+		summary.Time += suite.Time
+	}
+	// Summing the rounded suite times mustn't bring back long decimals
+	summary.Time = roundSeconds(summary.Time, maxTimeDecimals)
+	return summary
+}
+
+// MarshalSummaryJSON serializes the summary of the test suites as JSON
+func MarshalSummaryJSON(testSuites JUnitTestSuites) ([]byte, error) {
+	data, err := json.MarshalIndent(NewTestSummary(testSuites), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal test summary: %w", err)
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalSummaryJSON(t *testing.T) {
+	testSuites := JUnitTestSuites{
+		TestSuites: []JUnitTestSuite{
+			{Name: "LoginTests", Tests: 3, Failures: 1, Skipped: 1, Time: 2.5},
+			{
+				Name: "AppUITests", Tests: 2, Errors: 1, Time: 4,
+				TestSuites: []JUnitTestSuite{
+					{Name: "OnboardingTests", Tests: 2, Errors: 1, Time: 4},
+				},
+			},
+		},
+	}
+
+	data, err := MarshalSummaryJSON(testSuites)
+	if err != nil {
+		t.Fatalf("MarshalSummaryJSON returned error: %v", err)
+	}
+	if !strings.HasSuffix(string(data), "}\n") {
+		t.Errorf("Expected the summary to end with a newline")
+	}
+
+	var summary TestSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("Failed to parse summary: %v", err)
+	}
+
+	expected := TestSummary{
+		Tests: 5, Failures: 1, Errors: 1, Skipped: 1, Time: 6.5,
+		Suites: []SuiteSummary{
+			{Name: "LoginTests", Tests: 3, Failures: 1, Skipped: 1, Time: 2.5},
+			{Name: "AppUITests", Tests: 2, Errors: 1, Time: 4},
+		},
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, summary)
+	}
+
+	t.Run("no suites", func(t *testing.T) {
+		data, err := MarshalSummaryJSON(JUnitTestSuites{})
+		if err != nil {
+			t.Fatalf("MarshalSummaryJSON returned error: %v", err)
+		}
+		if !strings.Contains(string(data), `"suites": []`) {
+			t.Errorf("Expected an empty suites array, got %s", data)
+		}
+	})
+}
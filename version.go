@@ -0,0 +1,46 @@
+package main
+
+import (
+	"runtime/debug"
+)
+
+// version is the version of the step, set at build time with
+// -ldflags "-X main.version=1.2.3"
+var version string
+
+// develVersion is reported when the version of the step is unknown, e.g. when
+// it runs with `go run`
+const develVersion = "devel"
+
+// stepVersion returns the version set at build time, or else the module
+// version the binary was built from (e.g. with `go install …@v1.2.3`)
+func stepVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return develVersion
+}
+
+// isVersionCommand reports whether the command line arguments ask for the
+// version of the step, e.g. `bitrise-step-xcresult-to-junit version`
+func isVersionCommand(args []string) bool {
+	if len(args) != 1 {
+		return false
+	}
+	switch args[0] {
+	case "version", "-version", "--version":
+		return true
+	}
+	return false
+}
+
+// addVersionProperty adds the version of the step as the converterVersion
+// property of the top level suites, to tell which version wrote a report
+func addVersionProperty(testSuites *JUnitTestSuites, version string) {
+	for i := range testSuites.TestSuites {
+		testSuites.TestSuites[i].addProperty("converterVersion", version)
+	}
+}
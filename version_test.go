@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStepVersion(t *testing.T) {
+	t.Run("unknown version", func(t *testing.T) {
+		// Test binaries have no module version
+		if got := stepVersion(); got != develVersion {
+			t.Errorf("Expected %s, got %s", develVersion, got)
+		}
+	})
+
+	t.Run("set at build time", func(t *testing.T) {
+		version = "1.2.3"
+		t.Cleanup(func() { version = "" })
+		if got := stepVersion(); got != "1.2.3" {
+			t.Errorf("Expected 1.2.3, got %s", got)
+		}
+	})
+}
+
+func TestIsVersionCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"version"}, true},
+		{[]string{"--version"}, true},
+		{nil, false},
+		{[]string{"version", "extra"}, false},
+		{[]string{"convert"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := isVersionCommand(tt.args); got != tt.expected {
+			t.Errorf("Expected %v for %v, got %v", tt.expected, tt.args, got)
+		}
+	}
+}
+
+func TestAddVersionProperty(t *testing.T) {
+	shared := []JUnitProperty{{Name: "device", Value: "iPhone 15"}}
+	testSuites := JUnitTestSuites{TestSuites: []JUnitTestSuite{
+		{Name: "LoginTests", Properties: &JUnitProperties{Properties: shared}},
+		{Name: "ProfileTests", Properties: &JUnitProperties{Properties: shared}},
+	}}
+
+	addVersionProperty(&testSuites, "1.2.3")
+
+	for _, suite := range testSuites.TestSuites {
+		properties := suite.Properties.Properties
+		if len(properties) != 2 || properties[1] != (JUnitProperty{Name: "converterVersion", Value: "1.2.3"}) {
+			t.Errorf("Expected the converterVersion property on %s, got %+v", suite.Name, properties)
+		}
+	}
+}